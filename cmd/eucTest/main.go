@@ -9,6 +9,10 @@ import (
 func main() {
 	var data = []float64{0.2, 0.4, 0.7}
 	constraint := 3
-	res := euclidean.EuclideanDistance(0.0, 0.9, constraint, data)
+	res, err := euclidean.TrellisDecode(constraint, data)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
 	fmt.Println(res)
 }
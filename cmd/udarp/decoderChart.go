@@ -55,6 +55,7 @@ func (conf *Config) serveHTTP() {
 
 	http.Handle("/", fs)
 	http.HandleFunc("/ws", handleWs)
+	http.HandleFunc("/api/status", conf.handleStatus)
 
 	misc.Log("info", fmt.Sprintf("Starting http server on %s", conf.HTTP_Listen_Addr))
 	http.ListenAndServe(conf.HTTP_Listen_Addr, nil)
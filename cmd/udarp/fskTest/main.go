@@ -6,7 +6,6 @@ import (
 
 	"github.com/8ff/udarp/pkg/audio"
 	"github.com/8ff/udarp/pkg/fskGenerator"
-	"github.com/gen2brain/malgo"
 )
 
 func main() {
@@ -18,14 +17,14 @@ func main() {
 		os.Exit(1)
 	}
 
-	deviceConfig := malgo.DefaultDeviceConfig(malgo.Playback)
-	deviceConfig.Playback.DeviceID = defaultPlaybackDevice.ID.Pointer()
-	deviceConfig.Playback.Format = malgo.FormatS16
-	deviceConfig.Playback.Channels = 1
-	deviceConfig.SampleRate = 44100
-	deviceConfig.Alsa.NoMMap = 1
+	sink, err := audio.NewPlaybackSink(defaultPlaybackDevice, 44100)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer sink.Close()
 
-	err = audio.PlayWave(deviceConfig, wave)
+	_, err = sink.Write(wave)
 	if err != nil {
 		fmt.Println(err)
 	}
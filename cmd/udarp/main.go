@@ -6,23 +6,35 @@ Figure out a way to detect preamble and based on that preamble figure out the le
 */
 
 import (
-	"bufio"
-	"encoding/binary"
+	"context"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math"
 	"math/cmplx"
+	"net/http"
 	"os"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/8ff/udarp/pkg/audio"
+	"github.com/8ff/udarp/pkg/auth"
+	"github.com/8ff/udarp/pkg/bbs"
+	"github.com/8ff/udarp/pkg/beacon"
 	"github.com/8ff/udarp/pkg/buffer"
+	"github.com/8ff/udarp/pkg/calibrate"
+	"github.com/8ff/udarp/pkg/filetransfer"
+	"github.com/8ff/udarp/pkg/frame"
 	"github.com/8ff/udarp/pkg/fskGenerator"
+	"github.com/8ff/udarp/pkg/fskModem"
 	"github.com/8ff/udarp/pkg/misc"
+	"github.com/8ff/udarp/pkg/pack"
+	"github.com/8ff/udarp/pkg/remote"
 	"github.com/8ff/udarp/pkg/txControl"
 
 	"github.com/gen2brain/malgo"
@@ -37,6 +49,18 @@ type Config struct {
 	StdinDebug       bool
 	PlaybackDevice   *malgo.DeviceInfo
 	CaptureDevice    *malgo.DeviceInfo
+	CaptureFile      string // Read capture audio from this file instead of CaptureDevice, "-" for stdin
+	PlaybackFile     string // Write TX audio to this file instead of PlaybackDevice, "-" for stdout
+	Capture          audio.Source
+	Playback         audio.Sink
+	Audio            *audio.Engine // Set when a device is used, Capture and/or Playback then point at it
+	AudioLatency     time.Duration // Output latency of the sound card
+	CaptureMeter     *audio.Meter
+	Samples          *buffer.Ring // Capture on its way to the decoder
+	PlaybackMeter    *audio.Meter
+	TXLevel          float64 // Output level, 0..1 of full scale, from calibration or UDARP_TX_LEVEL
+	TXLevelFile      string  // Where --calibrate-tx stores the level
+	CalibrateTX      bool
 	WindowSize       int
 	WindowsInFrame   int
 	SampleRate       uint32
@@ -50,8 +74,40 @@ type Config struct {
 	RigCtldSerialPort string
 	RigCtldBaudRate   string
 	RigCtldModelId    string
+	Rig               *txControl.TxControl
+	TXLock            sync.Mutex        // Held while the rig is keyed, beacon slots and frame batches take turns
+	Frames            *fskModem.Decoder // Frames heard in the capture
+	Beacon            struct {
+		Callsign     string
+		Grid         string
+		Power        int
+		Interval     time.Duration
+		RandomSlot   bool
+		MaxDutyCycle float64
+		Service      *beacon.Beacon
+	}
+	FileTransfer struct {
+		Callsign string
+		Dir      string
+		Service  *filetransfer.Service
+	}
+	BBS struct {
+		Callsign string
+		Dir      string // Message store, the BBS is disabled if empty
+		Service  *bbs.BBS
+	}
+	Remote struct {
+		Callsign  string
+		Keys      string // CALL=hexkey entries separated by commas, remote control is disabled if empty
+		Operators string // CALL=command command entries separated by commas
+		Bands     string // lo-hi in Hz separated by commas, set-frequency is refused outside them
+		Service   *remote.Controller
+	}
 }
 
+// Tone keyed by txData and listened for by the frame decoder
+const toneFreq = 1500.00
+
 type Tone struct {
 	SampleRate    int
 	BitDurationMS int
@@ -60,11 +116,11 @@ type Tone struct {
 }
 
 func (conf *Config) toneDecoder() error {
-	// 	//	// Get PCM data on stdin, processes it and pushes it on the channel
+	// 	//	// Get PCM data from conf.Capture, processes it and pushes it on the channel
 	// 	// We expect audio to be S16_LE
 
 	// spectrum := make(map[int64]map[float64][]float64)
-	sampleRate := 44100
+	sampleRate := int(conf.Capture.SampleRate())
 	// The smaller the window size, the less accurate frequency is
 	windowSize := 1000 // in ms
 	windowSamples := int(float32(sampleRate) * float32(windowSize) / 1000.0)
@@ -90,145 +146,118 @@ func (conf *Config) toneDecoder() error {
 	fmt.Fprintf(os.Stderr, "FFT_SIZE: %d\n", fftSize)
 	fmt.Fprintf(os.Stderr, "SPECTRAL_WIDTH: %v[hertz]\n", spectralWidth)
 
-	// Initialize the context.
-	ctx, err := malgo.InitContext(nil, malgo.ContextConfig{}, func(message string) {})
-	if err != nil {
-		return err
-	}
-	defer func() {
-		_ = ctx.Uninit()
-		ctx.Free()
-	}()
-
-	// buffer := new(bytes.Buffer)
-	var buffer buffer.Buffer
-	buffer.Grow(10000) // Set the buffer size to 10000 bytes
-	fftBlockCounter := 0
 	t := int64(0)
 	// timeSlot := make([]map[float64][]float64, 0)
 
-	if !conf.StdinDebug { // If STDIN debug is enabled, we don't want to start the device
-		// Configure the device.
-		deviceConfig := malgo.DefaultDeviceConfig(malgo.Duplex)
-		deviceConfig.Capture.DeviceID = conf.CaptureDevice.ID.Pointer()
-		deviceConfig.Capture.Format = malgo.FormatS16
-		deviceConfig.Capture.Channels = 1
-		deviceConfig.SampleRate = conf.SampleRate
-		deviceConfig.Alsa.NoMMap = 1
+	// Audio from a file is decoded to the end, the frame length is then derived from how much there was
+	offline := conf.CaptureFile != ""
+	if offline {
+		conf.WindowsInFrame = 1000 // Set this to something high, it is set to the number of windows read at the end of the file
+	} else {
+		misc.Log("info", ">> [Recording...]")
+	}
+	go conf.feedSamples(offline)
 
-		// Callback which is called when the device receives frames.
-		onRecvFrames := func(audioSample2, audioSample []byte, framecount uint32) {
-			buffer.Write(audioSample)
+	samples := make([]float32, windowSamples)
+	var pending map[float64][]float64 // Last window of a file is held back until we know it is the last
+	windows := 0
+	for {
+		if _, err := conf.Samples.ReadFull(context.Background(), samples); err != nil {
+			// A partial window at the end is dropped
+			if !offline {
+				return fmt.Errorf("capture stopped: %w", err)
+			}
+			if pending != nil {
+				conf.WindowsInFrame = windows
+				misc.Log("debug", fmt.Sprintf("Auto adjusted WindowsInFrame to %d", conf.WindowsInFrame))
+				conf.TimeSlotChannel <- pending
+			}
+			misc.Log("info", "End of capture file")
+			select {} // Keep serving the results
+		}
+		for _, f := range conf.Frames.Write(samples) {
+			conf.handleFrame(f)
 		}
 
-		misc.Log("info", ">> [Recording...]")
-		captureCallbacks := malgo.DeviceCallbacks{
-			Data: onRecvFrames,
+		// Window is filled, process FFT for the window
+		for i, sample := range samples {
+			w[i] = float64(sample)
 		}
+		t += int64(windowSize)
+		window.Apply(w, window.Hamming)
+		c := fft.FFTReal(w)
+
+		// spectrum[t] = make(map[float64][]float64)
+		windowAngle := 0.0
+		freqSlice := make(map[float64][]float64, 0)
+
+		for i := 0; i < len(c)/2; i++ {
+			freq := math.Round(float64(i) * float64(sampleRate) / float64(fftSize))
+			//						freq := float64(i) * float64(sampleRate) / float64(fftSize)
+			if freq < conf.Freq.Lo || freq > conf.Freq.Hi { // We only care about the frequencies we are interested in
+				continue
+			}
 
-		// Initialize the device.
-		device, err := malgo.InitDevice(ctx.Context, deviceConfig, captureCallbacks)
-		if err != nil {
-			misc.Log("debug", fmt.Sprintf("Failed to initialize device: %s", err.Error()))
-			misc.Log("debug", fmt.Sprintf("DeviceConfig: %s", conf.CaptureDevice.ID.String()))
+			r, angle := cmplx.Polar(c[i])
+			angle *= 360.0 / (2 * math.Pi)
+			if dsputils.Float64Equal(r, 0) {
+				angle = 0 // (When the magnitude is close to 0, the angle is meaningless)
+			}
+			r = r / float64(fftSize)
 
-			return err
+			if freq == 0.0 {
+				windowAngle = angle
+			}
+
+			if freq > 1512.00 && freq < 1522.00 { // TEMPORARY
+				freqSlice[freq] = append(freqSlice[freq], r)
+				fmt.Sprintf("%d,%f,%f,%.1f\n", t, freq, r, windowAngle) // FREQ/R/ANGLE // This is placeholder to avoid warnings about unused variables
+			}
 		}
 
-		err = device.Start()
-		if err != nil {
-			return err
+		// Push push parsed FFT of the window to channel
+		if !offline {
+			conf.TimeSlotChannel <- freqSlice
+			continue
 		}
-	} else {
-		// If we are in debug mode, we read from STDIN
-		conf.WindowsInFrame = 1000 // Set this to something high, we will then subtract the number of windows we read from STDIN
-
-		r := bufio.NewReader(os.Stdin)
-		stdinBuffer := make([]byte, 100)
-		for {
-			bytesRead, err := r.Read(stdinBuffer)
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				return err
-			}
-			buffer.Write(stdinBuffer[:bytesRead])
+		if pending != nil {
+			conf.TimeSlotChannel <- pending
 		}
+		pending = freqSlice
+		windows++
 	}
+}
+
+// Convert capture audio into conf.Samples. A file is read no faster than it is decoded,
+// live capture never waits on the decoder, what does not fit is dropped and counted.
+func (conf *Config) feedSamples(offline bool) {
+	defer conf.Samples.Close()
 
+	pcm := make([]byte, 8192)
+	samples := make([]float32, len(pcm)/2)
+	kept := 0 // Odd byte carried over to the next read
 	for {
-		///////////////////////////////////////////////////////////
-		//	fmt.Printf("%d\n", buffer.Bytes())
-
-		for {
-			if buffer.Len() >= 2 {
-				actual_len := buffer.Len()
-				// Below code fills "w" with samples, once its filled (fftBlockCounter == windowSamples) it runs the fft, then rewrites "w" from 0
-				// This means that we are doing fft on blocks, rather replacing a part of samples
-				// Maybe replace 3% of samples and do the fft, take a look at graphs to see if it makes a difference
-				// GET AROUND THIS
-				if fftBlockCounter < windowSamples { // Fill the window
-					data := buffer.Next(2)
-					if len(data) < 2 || len(w) < fftBlockCounter {
-						// TODO: Remove Debug
-						fmt.Printf("fftBlockCounter: %d | windowSamples: %d | actual_len: %d | data: %v | len(w): %d | len(data): %d | buffer.Len(): %d\n", fftBlockCounter, windowSamples, actual_len, data, len(w), len(data), buffer.Len())
-					}
-					w[fftBlockCounter] = float64(int16(binary.LittleEndian.Uint16(data))) / 32768.0
-					fftBlockCounter++
-				} else {
-					// Window is filled, process FFT for the window
-					t += int64(windowSize)
-					window.Apply(w, window.Hamming)
-					c := fft.FFTReal(w)
-
-					// spectrum[t] = make(map[float64][]float64)
-					windowAngle := 0.0
-					freqSlice := make(map[float64][]float64, 0)
-
-					for i := 0; i < len(c)/2; i++ {
-						freq := math.Round(float64(i) * float64(sampleRate) / float64(fftSize))
-						//						freq := float64(i) * float64(sampleRate) / float64(fftSize)
-						if freq < conf.Freq.Lo || freq > conf.Freq.Hi { // We only care about the frequencies we are interested in
-							continue
-						}
-
-						r, angle := cmplx.Polar(c[i])
-						angle *= 360.0 / (2 * math.Pi)
-						if dsputils.Float64Equal(r, 0) {
-							angle = 0 // (When the magnitude is close to 0, the angle is meaningless)
-						}
-						r = r / float64(fftSize)
-
-						if freq == 0.0 {
-							windowAngle = angle
-						}
-
-						if freq > 1512.00 && freq < 1522.00 { // TEMPORARY
-							freqSlice[freq] = append(freqSlice[freq], r)
-							fmt.Sprintf("%d,%f,%f,%.1f\n", t, freq, r, windowAngle) // FREQ/R/ANGLE // This is placeholder to avoid warnings about unused variables
-						}
-					}
-
-					// Automatically adjust WindowsInFrame if stdinDebug is enabled
-					if conf.StdinDebug {
-						if buffer.Len() < int(conf.SampleRate)*2 {
-							conf.WindowsInFrame = 1000 - conf.WindowsInFrame + 1
-							misc.Log("debug", fmt.Sprintf("Auto adjusted WindowsInFrame to %d", conf.WindowsInFrame))
-						} else {
-							conf.WindowsInFrame--
-						}
-					}
-					// Push push parsed FFT of the window to channel
-					conf.TimeSlotChannel <- freqSlice
-					fftBlockCounter = 0
-				}
-			} else {
-				break
+		n, err := conf.Capture.Read(pcm[kept:])
+		n += kept
+		count := audio.S16ToFloat32(samples, pcm[:n])
+		kept = n % 2
+		if kept == 1 {
+			pcm[0] = pcm[n-1]
+		}
+
+		if offline {
+			conf.Samples.WriteWait(context.Background(), samples[:count])
+		} else {
+			conf.Samples.Write(samples[:count])
+		}
+
+		if err != nil {
+			if err != io.EOF {
+				misc.Log("error", fmt.Sprintf("Capture failed: %s", err))
 			}
+			return
 		}
 	}
-	// device.Uninit()
 }
 
 func (conf *Config) fetchWindow() {
@@ -273,7 +302,7 @@ func (conf *Config) parseFrame(frame []map[float64][]float64) {
 			channelAvg += rSum
 		}
 		channelAvg = channelAvg / float64(len(freqKeys))
-		fmt.Printf("Q: %d,%f\n", index, channelAvg)
+		fmt.Fprintf(os.Stderr, "Q: %d,%f\n", index, channelAvg)
 
 		// Add frame to chartData
 		chartFrames = append(chartFrames, []string{strconv.Itoa(index), fmt.Sprintf("%.3f", channelAvg*1000)})
@@ -350,17 +379,33 @@ func (conf *Config) parseFrame(frame []map[float64][]float64) {
 	}
 }
 
-// Function that checks if there is a -l flag
+// Function that checks if there is a -l flag, with --json the list is printed as JSON
 func (conf *Config) parseFlags() bool {
+	list, listJSON := false, false
 	for _, arg := range os.Args {
 		switch arg {
 		case "-l", "--list-devices", "--list":
-			audio.PrettyPrintDevices()
-			os.Exit(0)
+			list = true
+		case "--json":
+			listJSON = true
 		case "--stdin":
 			conf.StdinDebug = true
+		case "--calibrate-tx":
+			conf.CalibrateTX = true
 		}
 	}
+
+	if list {
+		if listJSON {
+			if err := audio.PrintDevicesJSON(os.Stdout); err != nil {
+				misc.Log("error", fmt.Sprintf("Error getting audio devices: %s", err))
+				os.Exit(1)
+			}
+		} else {
+			audio.PrettyPrintDevices()
+		}
+		os.Exit(0)
+	}
 	return false
 }
 
@@ -388,48 +433,74 @@ func (conf *Config) parseEnv() {
 		conf.StdinDebug = true
 	}
 
-	if !conf.StdinDebug {
-		// Check audio devices
-		playbackHash := os.Getenv("UDARP_PLAYBACK_DEVICE")
-		captureHash := os.Getenv("UDARP_CAPTURE_DEVICE")
+	// Audio files replace the devices, stdin debug reads capture audio from stdin
+	conf.CaptureFile = os.Getenv("UDARP_CAPTURE_FILE")
+	conf.PlaybackFile = os.Getenv("UDARP_PLAYBACK_FILE")
+	if conf.PlaybackFile == "-" {
+		// Stdout carries the TX audio, anything else written there would end up in it
+		misc.LogOutput = os.Stderr
+	}
+	if conf.StdinDebug && conf.CaptureFile == "" {
+		conf.CaptureFile = "-"
+	}
 
-		// Check if audio devices are set
-		if playbackHash == "" || captureHash == "" {
-			misc.Log("error", "Audio devices not set. Please set UDARP_PLAYBACK_DEVICE and UDARP_CAPTURE_DEVICE environment variables using the -l flag to list devices.")
+	// Check audio devices, stdin debug runs without a playback device.
+	// Devices are picked by name, substring, re:regexp, the hash shown by -l, or "default".
+	if conf.CaptureFile == "" {
+		captureSelector := os.Getenv("UDARP_CAPTURE_DEVICE")
+		if captureSelector == "" {
+			misc.Log("error", "Capture device not set. Please set UDARP_CAPTURE_DEVICE using the -l flag to list devices, or UDARP_CAPTURE_FILE.")
 			os.Exit(1)
 		}
 
-		if playbackHash == "default" {
-			conf.PlaybackDevice, err = audio.GetDefaultPlaybackDevice()
-			if err != nil {
-				misc.Log("error", fmt.Sprintf("Error getting default playback device: %s", err))
-				os.Exit(1)
-			}
-		} else {
-			conf.PlaybackDevice, err = audio.DeviceFromHash(playbackHash)
-			if err != nil {
-				misc.Log("error", fmt.Sprintf("Error getting playback device: %s", err))
-				os.Exit(1)
-			}
+		conf.CaptureDevice, err = audio.SelectDevice(malgo.Capture, captureSelector)
+		if err != nil {
+			misc.Log("error", fmt.Sprintf("Error getting capture device: %s", err))
+			os.Exit(1)
 		}
+		misc.Log("info", fmt.Sprintf("Using [%s - %s] as capture device", captureSelector, conf.CaptureDevice.Name()))
+	}
 
-		if captureHash == "default" {
-			conf.CaptureDevice, err = audio.GetDefaultCaptureDevice()
-			if err != nil {
-				misc.Log("error", fmt.Sprintf("Error getting default capture device: %s", err))
-				os.Exit(1)
-			}
-		} else {
-			conf.CaptureDevice, err = audio.DeviceFromHash(captureHash)
-			if err != nil {
-				misc.Log("error", fmt.Sprintf("Error getting capture device: %s", err))
-				os.Exit(1)
-			}
+	if conf.PlaybackFile == "" && !conf.StdinDebug {
+		playbackSelector := os.Getenv("UDARP_PLAYBACK_DEVICE")
+		if playbackSelector == "" {
+			misc.Log("error", "Playback device not set. Please set UDARP_PLAYBACK_DEVICE using the -l flag to list devices, or UDARP_PLAYBACK_FILE.")
+			os.Exit(1)
 		}
 
-		// Print device names
-		misc.Log("info", fmt.Sprintf("Using [%s - %s] as playback device", playbackHash, conf.PlaybackDevice.Name()))
-		misc.Log("info", fmt.Sprintf("Using [%s - %s] as capture device", captureHash, conf.CaptureDevice.Name()))
+		conf.PlaybackDevice, err = audio.SelectDevice(malgo.Playback, playbackSelector)
+		if err != nil {
+			misc.Log("error", fmt.Sprintf("Error getting playback device: %s", err))
+			os.Exit(1)
+		}
+		misc.Log("info", fmt.Sprintf("Using [%s - %s] as playback device", playbackSelector, conf.PlaybackDevice.Name()))
+	}
+
+	// Read output latency, TX keeps PTT this long after the last sample has gone to the sound card
+	audioLatency, err := strconv.Atoi(os.Getenv("UDARP_AUDIO_LATENCY"))
+	if err != nil {
+		audioLatency = 0
+	}
+	conf.AudioLatency = time.Duration(audioLatency) * time.Millisecond
+
+	// Read TX level, an explicit level wins over the calibrated one
+	conf.TXLevelFile = os.Getenv("UDARP_TX_LEVEL_FILE")
+	if conf.TXLevelFile == "" {
+		conf.TXLevelFile = "tx_level.json"
+	}
+	conf.TXLevel, err = strconv.ParseFloat(os.Getenv("UDARP_TX_LEVEL"), 64)
+	if err != nil {
+		conf.TXLevel, err = calibrate.Load(conf.TXLevelFile)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				misc.Log("warning", fmt.Sprintf("Ignoring TX calibration: %s", err))
+			}
+			conf.TXLevel = 1
+		}
+	}
+	if conf.TXLevel <= 0 || conf.TXLevel > 1 {
+		misc.Log("error", fmt.Sprintf("TX level %f is out of range, it has to be above 0 and at most 1", conf.TXLevel))
+		os.Exit(1)
 	}
 
 	// Read window size
@@ -505,12 +576,59 @@ func (conf *Config) parseEnv() {
 		conf.RigCtldModelId = "1"
 	}
 
+	// Read beacon settings, beacon is disabled if no callsign is set
+	conf.Beacon.Callsign = os.Getenv("UDARP_BEACON_CALLSIGN")
+	conf.Beacon.Grid = os.Getenv("UDARP_BEACON_GRID")
+
+	conf.Beacon.Power, err = strconv.Atoi(os.Getenv("UDARP_BEACON_POWER"))
+	if err != nil {
+		conf.Beacon.Power = 37
+	}
+
+	beaconInterval, err := strconv.Atoi(os.Getenv("UDARP_BEACON_INTERVAL"))
+	if err != nil {
+		beaconInterval = 10
+	}
+	conf.Beacon.Interval = time.Duration(beaconInterval) * time.Minute
+
+	conf.Beacon.RandomSlot = os.Getenv("UDARP_BEACON_RANDOM_SLOT") != "false"
+
+	conf.Beacon.MaxDutyCycle, err = strconv.ParseFloat(os.Getenv("UDARP_BEACON_MAX_DUTY_CYCLE"), 64)
+	if err != nil {
+		conf.Beacon.MaxDutyCycle = 0.2
+	}
+
+	// Read file transfer settings, file transfer is disabled if no directory is set
+	conf.FileTransfer.Callsign = os.Getenv("UDARP_FILE_CALLSIGN")
+	if conf.FileTransfer.Callsign == "" {
+		conf.FileTransfer.Callsign = conf.Beacon.Callsign
+	}
+	conf.FileTransfer.Dir = os.Getenv("UDARP_FILE_DIR")
+
+	// Read BBS settings, the BBS is disabled if no directory is set
+	conf.BBS.Callsign = os.Getenv("UDARP_BBS_CALLSIGN")
+	if conf.BBS.Callsign == "" {
+		conf.BBS.Callsign = conf.Beacon.Callsign
+	}
+	conf.BBS.Dir = os.Getenv("UDARP_BBS_DIR")
+
+	// Read remote control settings, remote control is disabled if no keys are set
+	conf.Remote.Callsign = os.Getenv("UDARP_REMOTE_CALLSIGN")
+	if conf.Remote.Callsign == "" {
+		conf.Remote.Callsign = conf.Beacon.Callsign
+	}
+	conf.Remote.Keys = os.Getenv("UDARP_REMOTE_KEYS")
+	conf.Remote.Operators = os.Getenv("UDARP_REMOTE_OPERATORS")
+	conf.Remote.Bands = os.Getenv("UDARP_REMOTE_BANDS")
+
 	// Print out all the configs
 	misc.Log("debug", "********* Config **********")
 	misc.Log("debug", fmt.Sprintf("HTTP listen addr: %s", conf.HTTP_Listen_Addr))
 	misc.Log("debug", fmt.Sprintf("Stdin debug: %t", conf.StdinDebug))
-	misc.Log("debug", fmt.Sprintf("Playback device: %s", conf.PlaybackDevice.Name()))
-	misc.Log("debug", fmt.Sprintf("Capture device: %s", conf.CaptureDevice.Name()))
+	misc.Log("debug", fmt.Sprintf("Playback: %s", audioName(conf.PlaybackFile, conf.PlaybackDevice)))
+	misc.Log("debug", fmt.Sprintf("Capture: %s", audioName(conf.CaptureFile, conf.CaptureDevice)))
+	misc.Log("debug", fmt.Sprintf("Audio latency: %s", conf.AudioLatency))
+	misc.Log("debug", fmt.Sprintf("TX level: %.2f", conf.TXLevel))
 	misc.Log("debug", fmt.Sprintf("Window size: %d", conf.WindowSize))
 	misc.Log("debug", fmt.Sprintf("Windows in frame: %d", conf.WindowsInFrame))
 	misc.Log("debug", fmt.Sprintf("Sample rate: %d", conf.SampleRate))
@@ -521,18 +639,157 @@ func (conf *Config) parseEnv() {
 	misc.Log("debug", fmt.Sprintf("Rigctld serial port: %s", conf.RigCtldSerialPort))
 	misc.Log("debug", fmt.Sprintf("Rigctld baud rate: %s", conf.RigCtldBaudRate))
 	misc.Log("debug", fmt.Sprintf("Rigctld model id: %s", conf.RigCtldModelId))
+	misc.Log("debug", fmt.Sprintf("Beacon: %s %s %ddBm every %s", conf.Beacon.Callsign, conf.Beacon.Grid, conf.Beacon.Power, conf.Beacon.Interval))
+	misc.Log("debug", fmt.Sprintf("File transfer: %s %s", conf.FileTransfer.Callsign, conf.FileTransfer.Dir))
+	misc.Log("debug", fmt.Sprintf("BBS: %s %s", conf.BBS.Callsign, conf.BBS.Dir))
+	misc.Log("debug", fmt.Sprintf("Remote control: %s operators %q bands %q", conf.Remote.Callsign, conf.Remote.Operators, conf.Remote.Bands))
+
+}
+
+// Name of the file or device used for audio, for logging
+func audioName(file string, device *malgo.DeviceInfo) string {
+	switch {
+	case file != "":
+		return "file " + file
+	case device != nil:
+		return device.Name()
+	default:
+		return "none"
+	}
+}
+
+// Open the capture source and playback sink, files take precedence over devices.
+// When both directions use a device they share one full duplex engine that stays open.
+func (conf *Config) openAudio() {
+	var err error
+	if conf.CaptureFile == "" && conf.PlaybackFile == "" && conf.PlaybackDevice != nil {
+		conf.Audio, err = audio.NewEngine(audio.EngineParams{Capture: conf.CaptureDevice, Playback: conf.PlaybackDevice, SampleRate: conf.SampleRate, Latency: conf.AudioLatency})
+		if err != nil {
+			misc.Log("error", fmt.Sprintf("Error opening audio devices: %s", err))
+			os.Exit(1)
+		}
+		conf.Capture, conf.Playback = conf.Audio, conf.Audio
+		return
+	}
+
+	if conf.CaptureFile != "" {
+		conf.Capture, err = audio.OpenFile(conf.CaptureFile, conf.SampleRate)
+	} else {
+		conf.Capture, err = audio.NewCaptureSource(conf.CaptureDevice, conf.SampleRate)
+	}
+	if err != nil {
+		misc.Log("error", fmt.Sprintf("Error opening capture audio: %s", err))
+		os.Exit(1)
+	}
+
+	switch {
+	case conf.PlaybackFile != "":
+		conf.Playback, err = audio.CreateFile(conf.PlaybackFile, conf.SampleRate)
+	case conf.PlaybackDevice != nil:
+		conf.Audio, err = audio.NewEngine(audio.EngineParams{Playback: conf.PlaybackDevice, Mode: malgo.Playback, SampleRate: conf.SampleRate, Latency: conf.AudioLatency})
+		conf.Playback = conf.Audio
+	}
+	if err != nil {
+		misc.Log("error", fmt.Sprintf("Error opening playback audio: %s", err))
+		os.Exit(1)
+	}
+}
+
+// Meter capture and playback levels and warn when the input clips or is too quiet to decode
+func (conf *Config) startMeters() {
+	conf.CaptureMeter = audio.NewMeter(audio.MeterParams{}, conf.Capture.SampleRate())
+	conf.PlaybackMeter = audio.NewMeter(audio.MeterParams{}, conf.SampleRate)
+	conf.Capture = audio.MeterSource(conf.Capture, conf.CaptureMeter)
+
+	go func() {
+		var last audio.Level
+		var lastOverruns uint64
+		for range time.Tick(time.Second) {
+			if overruns := conf.overruns(); overruns > lastOverruns {
+				misc.Log("warning", fmt.Sprintf("Decoder is falling behind, %d captured samples dropped", overruns-lastOverruns))
+				lastOverruns = overruns
+			}
+
+			level := conf.CaptureMeter.Level()
+			if level.Updated.IsZero() {
+				continue
+			}
+			// Only warn when the state changes, not every window
+			if level.Clipping && !last.Clipping {
+				misc.Log("warning", fmt.Sprintf("Capture is clipping, peak %.1f dBFS, %d samples clipped so far. Lower the rig's audio output or the input gain", level.Peak, level.Clipped))
+			}
+			if level.TooQuiet && !last.TooQuiet {
+				misc.Log("warning", fmt.Sprintf("Capture is too quiet to decode, RMS %.1f dBFS. Raise the rig's audio output or the input gain", level.RMS))
+			}
+			last = level
+		}
+	}()
+}
 
+// Captured samples dropped on the way to the decoder, by the sound card engine or the decoder buffer
+func (conf *Config) overruns() uint64 {
+	overruns := conf.Samples.Overruns()
+	if conf.Audio != nil {
+		overruns += conf.Audio.CaptureOverruns()
+	}
+	return overruns
+}
+
+// Serve levels and TX settings as JSON
+func (conf *Config) handleStatus(w http.ResponseWriter, r *http.Request) {
+	status := struct {
+		Capture  audio.Level `json:"capture"`
+		Playback audio.Level `json:"playback"`
+		TXLevel  float64     `json:"tx_level"`
+		Overruns uint64      `json:"overruns"`
+	}{conf.CaptureMeter.Level(), conf.PlaybackMeter.Level(), conf.TXLevel, conf.overruns()}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// Step the TX level while reading ALC and power from the rig, then store the clean level
+func (conf *Config) calibrateTX() {
+	if conf.Playback == nil {
+		misc.Log("error", "TX calibration needs a playback device or file")
+		os.Exit(1)
+	}
+
+	// rigctld is started in the background, wait until it answers
+	for i := 0; ; i++ {
+		if _, err := conf.Rig.GetFrequency(); err == nil {
+			break
+		} else if i == 20 {
+			misc.Log("error", fmt.Sprintf("rigctld is not answering: %s", err))
+			os.Exit(1)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	misc.Log("info", "Calibrating TX level, the rig will be keyed with a steady tone. Use a dummy load or a clear frequency")
+	result, err := calibrate.Run(context.Background(), calibrate.Params{}, txRig{conf}, audio.MeterSink(conf.Playback, conf.PlaybackMeter))
+	if err != nil {
+		misc.Log("error", fmt.Sprintf("TX calibration failed: %s", err))
+		os.Exit(1)
+	}
+	if err := calibrate.Save(conf.TXLevelFile, result); err != nil {
+		misc.Log("error", fmt.Sprintf("Error saving TX calibration: %s", err))
+		os.Exit(1)
+	}
+	misc.Log("info", fmt.Sprintf("TX level %.2f stored in %s", result.Level, conf.TXLevelFile))
 }
 
 // Start rigctld
-// TODO: This is temp, will be replaced with a proper rigctld wrapper
 func (conf *Config) startRigController() {
-	txControl.Init()
-	args := txControl.ParamsToArgs(txControl.Params{SerialPort: conf.RigCtldSerialPort, ModelId: conf.RigCtldModelId, ListenAddr: conf.RigCtldListenAddr, ListenPort: conf.RigCtldListenPort, BaudRate: conf.RigCtldBaudRate})
-	// Print args
-	fmt.Println(args)
+	var err error
+	conf.Rig, err = txControl.New(txControl.Params{SerialPort: conf.RigCtldSerialPort, ModelId: conf.RigCtldModelId, ListenAddr: conf.RigCtldListenAddr, ListenPort: conf.RigCtldListenPort, BaudRate: conf.RigCtldBaudRate})
+	if err != nil {
+		misc.Log("error", fmt.Sprintf("Error setting up rigctld: %s", err))
+		os.Exit(1)
+	}
+
 	go func() {
-		err := txControl.StartRigCtld(args)
+		err := conf.Rig.Start()
 		if err != nil {
 			misc.Log("error", fmt.Sprintf("Error starting rigctld: %s", err))
 			os.Exit(1)
@@ -540,21 +797,253 @@ func (conf *Config) startRigController() {
 	}()
 }
 
+// Start the periodic beacon if a callsign is configured
+func (conf *Config) startBeacon() {
+	if conf.Beacon.Callsign == "" {
+		return
+	}
+
+	b, err := beacon.New(beacon.Params{
+		Beacon:       pack.Beacon{Callsign: conf.Beacon.Callsign, Grid: conf.Beacon.Grid, Power: conf.Beacon.Power},
+		Interval:     conf.Beacon.Interval,
+		SlotLength:   time.Duration(pack.BeaconBits*conf.WindowSize) * time.Millisecond,
+		RandomSlot:   conf.Beacon.RandomSlot,
+		MaxDutyCycle: conf.Beacon.MaxDutyCycle,
+		DutyWindow:   time.Hour,
+	}, txRig{conf}, func(bits []int) error {
+		return conf.txData(Tone{SampleRate: int(conf.SampleRate), BitDurationMS: conf.WindowSize, ToneFreq: toneFreq, Bits: bits})
+	})
+	if err != nil {
+		misc.Log("error", fmt.Sprintf("Error setting up beacon: %s", err))
+		os.Exit(1)
+	}
+
+	b.Start()
+	conf.Beacon.Service = b
+}
+
+// Start the file transfer service and mount its API under /api/files/ if a directory is configured
+func (conf *Config) startFileTransfer() {
+	if conf.FileTransfer.Dir == "" {
+		return
+	}
+
+	var err error
+	conf.FileTransfer.Service, err = filetransfer.New(filetransfer.Params{
+		Callsign: conf.FileTransfer.Callsign,
+		Dir:      conf.FileTransfer.Dir,
+		Airtime: func(payload int) time.Duration {
+			bits := len(fskModem.Sync) + (frame.HeaderSize+payload)*8
+			return time.Duration(bits*conf.WindowSize) * time.Millisecond
+		},
+	}, conf.txFrames)
+	if err != nil {
+		misc.Log("error", fmt.Sprintf("Error setting up file transfer: %s", err))
+		os.Exit(1)
+	}
+
+	http.Handle("/api/files/", http.StripPrefix("/api/files", conf.FileTransfer.Service.Handler()))
+}
+
+// Open the message store and answer BBS requests if a directory is configured
+func (conf *Config) startBBS() {
+	if conf.BBS.Dir == "" {
+		return
+	}
+
+	store, err := bbs.Open(conf.BBS.Dir)
+	if err != nil {
+		misc.Log("error", fmt.Sprintf("Error opening BBS store: %s", err))
+		os.Exit(1)
+	}
+	conf.BBS.Service, err = bbs.New(conf.BBS.Callsign, store)
+	if err != nil {
+		misc.Log("error", fmt.Sprintf("Error setting up BBS: %s", err))
+		os.Exit(1)
+	}
+}
+
+// Answer authenticated remote control commands if operator keys are configured
+func (conf *Config) startRemote() {
+	if conf.Remote.Keys == "" {
+		return
+	}
+
+	params, err := conf.remoteParams()
+	if err != nil {
+		misc.Log("error", fmt.Sprintf("Error reading remote control settings: %s", err))
+		os.Exit(1)
+	}
+	conf.Remote.Service, err = remote.New(params)
+	if err != nil {
+		misc.Log("error", fmt.Sprintf("Error setting up remote control: %s", err))
+		os.Exit(1)
+	}
+}
+
+// Build the remote control params from the UDARP_REMOTE_* settings
+func (conf *Config) remoteParams() (remote.Params, error) {
+	a, err := auth.New(auth.Params{})
+	if err != nil {
+		return remote.Params{}, err
+	}
+	params := remote.Params{Callsign: conf.Remote.Callsign, Auth: a, Operators: make(map[remote.Command][]string), Rig: conf.Rig}
+	// Keep the interface nil when there is no beacon
+	if conf.Beacon.Service != nil {
+		params.Beacon = conf.Beacon.Service
+	}
+
+	for _, entry := range strings.Split(conf.Remote.Keys, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		call, hexKey, _ := strings.Cut(entry, "=")
+		key, err := hex.DecodeString(hexKey)
+		if err != nil {
+			return remote.Params{}, fmt.Errorf("key for %s is not hex: %w", call, err)
+		}
+		if err := a.SetKey(call, key); err != nil {
+			return remote.Params{}, fmt.Errorf("key for %s: %w", call, err)
+		}
+	}
+
+	for _, entry := range strings.Split(conf.Remote.Operators, ",") {
+		call, commands, _ := strings.Cut(strings.TrimSpace(entry), "=")
+		for _, name := range strings.Fields(commands) {
+			cmd, err := remote.ParseCommand(name)
+			if err != nil {
+				return remote.Params{}, fmt.Errorf("operator %s: %w", call, err)
+			}
+			params.Operators[cmd] = append(params.Operators[cmd], call)
+		}
+	}
+
+	for _, entry := range strings.Split(conf.Remote.Bands, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		lo, hi, _ := strings.Cut(entry, "-")
+		var band remote.Band
+		if band.Lo, err = strconv.ParseUint(lo, 10, 64); err != nil {
+			return remote.Params{}, fmt.Errorf("invalid band %q", entry)
+		}
+		if band.Hi, err = strconv.ParseUint(hi, 10, 64); err != nil {
+			return remote.Params{}, fmt.Errorf("invalid band %q", entry)
+		}
+		params.Bands = append(params.Bands, band)
+	}
+	return params, nil
+}
+
+// Set up the decoder for frames in the capture
+func (conf *Config) startFrameDecoder() {
+	var err error
+	conf.Frames, err = fskModem.New(fskModem.Params{SampleRate: int(conf.Capture.SampleRate()), BitDurationMS: conf.WindowSize, ToneFreq: toneFreq})
+	if err != nil {
+		misc.Log("error", fmt.Sprintf("Error setting up frame decoder: %s", err))
+		os.Exit(1)
+	}
+}
+
+// Hand a received frame to the service it is for and transmit the reply
+func (conf *Config) handleFrame(f frame.Frame) {
+	misc.Log("info", fmt.Sprintf("Received frame %d from %s to %s", f.Type, f.Source, f.Destination))
+
+	var reply frame.Frame
+	ok := false
+	switch {
+	case f.Type == frame.TypeFile && conf.FileTransfer.Service != nil:
+		reply, ok = conf.FileTransfer.Service.Handle(f)
+	case f.Type == frame.TypeBBS && conf.BBS.Service != nil:
+		reply, ok = conf.BBS.Service.Handle(f)
+	case f.Type == frame.TypeControl && conf.Remote.Service != nil:
+		reply, ok = conf.Remote.Service.Handle(f)
+	}
+	if !ok {
+		return
+	}
+	// The decoder has to keep up with the capture, transmit from elsewhere
+	go func() {
+		if err := conf.txFrames(reply); err != nil {
+			misc.Log("error", fmt.Sprintf("Failed to transmit reply to %s: %s", reply.Destination, err))
+		}
+	}()
+}
+
+// txRig keys the rig under TXLock so beacon slots and calibration never overlap frame batches.
+// Users must follow every TX with RX, even a failed one, RX releases the lock.
+type txRig struct {
+	conf *Config
+}
+
+func (r txRig) TX() error {
+	r.conf.TXLock.Lock()
+	return r.conf.Rig.TX()
+}
+
+func (r txRig) RX() error {
+	defer r.conf.TXLock.Unlock()
+	return r.conf.Rig.RX()
+}
+
+func (r txRig) GetLevel(name string) (float64, error) {
+	return r.conf.Rig.GetLevel(name)
+}
+
+// Transmit frames back to back, each behind the sync, keying the rig once around all of them
+func (conf *Config) txFrames(frames ...frame.Frame) error {
+	var bits []int
+	for _, f := range frames {
+		b, err := fskModem.Encode(f)
+		if err != nil {
+			return err
+		}
+		bits = append(bits, b...)
+	}
+
+	conf.TXLock.Lock()
+	defer conf.TXLock.Unlock()
+	if err := conf.Rig.TX(); err != nil {
+		// Make sure we are not left keyed up half way
+		conf.Rig.RX()
+		return err
+	}
+	err := conf.txData(Tone{SampleRate: int(conf.SampleRate), BitDurationMS: conf.WindowSize, ToneFreq: toneFreq, Bits: bits})
+	if rxErr := conf.Rig.RX(); err == nil {
+		err = rxErr
+	}
+	return err
+}
+
 func (conf *Config) txData(tone Tone) error {
+	if conf.Playback == nil {
+		return errors.New("no playback device or file configured")
+	}
+
 	wave := fskGenerator.FlexFsk(tone.SampleRate, tone.BitDurationMS, tone.ToneFreq, tone.Bits)
+	if conf.TXLevel < 1 {
+		audio.Scale(wave, conf.TXLevel)
+	}
+	conf.PlaybackMeter.Write(wave)
 
-	deviceConfig := malgo.DefaultDeviceConfig(malgo.Playback)
-	deviceConfig.Playback.DeviceID = conf.PlaybackDevice.ID.Pointer()
-	deviceConfig.Playback.Format = malgo.FormatS16
-	deviceConfig.Playback.Channels = 1
-	deviceConfig.SampleRate = 44100
-	deviceConfig.Alsa.NoMMap = 1
+	if conf.Audio == nil {
+		_, err := conf.Playback.Write(wave)
+		return err
+	}
 
-	err := audio.PlayWave(deviceConfig, wave)
+	t, err := conf.Audio.Play(wave)
 	if err != nil {
 		return err
 	}
+	if err := t.Wait(context.Background()); err != nil {
+		return err
+	}
+	misc.Log("debug", fmt.Sprintf("TX audio from %s to %s", t.Start().Format("15:04:05.000"), t.Stop().Format("15:04:05.000")))
 
+	// The tail is still in the sound card when the last sample is handed over, keep PTT until it is heard
+	time.Sleep(time.Until(t.Stop()))
 	return nil
 }
 
@@ -563,22 +1052,33 @@ func main() {
 	config.TimeSlotChannel = make(chan map[float64][]float64)
 	config.parseFlags()
 	config.parseEnv()
+	config.openAudio()
+	config.Samples = buffer.New(int(config.Capture.SampleRate()) * 10) // 10 seconds of capture
+	config.startMeters()
+	config.startFrameDecoder()
 	go config.fetchWindow()
 
+	// Start rigCtld
+	config.startRigController()
+
+	// Calibrate before anything else can key the rig
+	if config.CalibrateTX {
+		config.calibrateTX()
+		os.Exit(0)
+	}
+
+	// Start file transfer, before the HTTP server so its API is mounted
+	config.startFileTransfer()
+	config.startBBS()
+
 	// Start HTTP server
 	go config.serveHTTP()
 
-	// Start rigCtld
-	config.startRigController()
-	go func() {
-		// Wait for 5 seconds and transmit for 15 seconds, then stop transmitting
-		time.Sleep(5 * time.Second)
-		txControl.RigControlTcp(config.RigCtldListenAddr, config.RigCtldListenPort, "T 1")
-		misc.Log("debug", "Transmitting")
-		config.txData(Tone{SampleRate: 44100, BitDurationMS: config.WindowSize, ToneFreq: 1500.00, Bits: []int{1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0}})
-		time.Sleep(15 * time.Second)
-		txControl.RigControlTcp(config.RigCtldListenAddr, config.RigCtldListenPort, "T 0")
-	}()
+	// Start beacon
+	config.startBeacon()
+
+	// Start remote control, after the beacon so it can be switched on and off
+	config.startRemote()
 
 	// Start tone decoder
 	err := config.toneDecoder()
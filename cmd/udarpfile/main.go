@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/8ff/udarp/pkg/filetransfer"
+)
+
+// Command line client for the file transfer API of a running udarp station
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [-addr http://127.0.0.1:3000] <command>\n\n", filepath.Base(os.Args[0]))
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	fmt.Fprintf(os.Stderr, "  send <callsign> <file>  send a file, waits until it is delivered unless -no-wait is set\n")
+	fmt.Fprintf(os.Stderr, "  list                    show all transfers\n")
+	fmt.Fprintf(os.Stderr, "  get <name> [output]     download a received file\n\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	addr := flag.String("addr", "http://127.0.0.1:3000", "Address of the udarp HTTP server")
+	noWait := flag.Bool("no-wait", false, "Return as soon as the transfer is queued")
+	flag.Usage = usage
+	flag.Parse()
+
+	api := *addr + "/api/files"
+	var err error
+	switch flag.Arg(0) {
+	case "send":
+		if flag.NArg() != 3 {
+			usage()
+			os.Exit(2)
+		}
+		err = send(api, flag.Arg(1), flag.Arg(2), !*noWait)
+	case "list":
+		err = list(api)
+	case "get":
+		if flag.NArg() < 2 {
+			usage()
+			os.Exit(2)
+		}
+		output := filepath.Base(flag.Arg(1))
+		if flag.NArg() > 2 {
+			output = flag.Arg(2)
+		}
+		err = get(api, flag.Arg(1), output)
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func decode(resp *http.Response, v interface{}) error {
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		return fmt.Errorf("%s: %s", resp.Status, apiErr.Error)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func fetchTransfers(api string) ([]filetransfer.Progress, error) {
+	resp, err := http.Get(api + "/transfers")
+	if err != nil {
+		return nil, err
+	}
+	var transfers []filetransfer.Progress
+	return transfers, decode(resp, &transfers)
+}
+
+func printProgress(p filetransfer.Progress) {
+	percent := 0
+	if p.Chunks > 0 {
+		percent = p.Done * 100 / p.Chunks
+	}
+	fmt.Printf("%08x  %-7s  %-10s  %-30s  %8d bytes  %3d%%  %s", p.ID, p.Direction, p.Peer, p.Name, p.Size, percent, p.State)
+	if p.Err != "" {
+		fmt.Printf("  (%s)", p.Err)
+	}
+	fmt.Println()
+}
+
+func send(api, peer, path string, wait bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	resp, err := http.Post(fmt.Sprintf("%s/transfers?peer=%s&name=%s", api, url.QueryEscape(peer), url.QueryEscape(filepath.Base(path))), "application/octet-stream", file)
+	if err != nil {
+		return err
+	}
+	var started filetransfer.Progress
+	if err := decode(resp, &started); err != nil {
+		return err
+	}
+	printProgress(started)
+	if !wait {
+		return nil
+	}
+
+	last := -1
+	for {
+		time.Sleep(2 * time.Second)
+		transfers, err := fetchTransfers(api)
+		if err != nil {
+			return err
+		}
+		for _, p := range transfers {
+			if p.ID != started.ID || p.Direction != filetransfer.Sending || p.Peer != started.Peer {
+				continue
+			}
+			if p.Done != last || p.State != filetransfer.StateActive {
+				printProgress(p)
+				last = p.Done
+			}
+			switch p.State {
+			case filetransfer.StateComplete:
+				return nil
+			case filetransfer.StateFailed:
+				return fmt.Errorf("transfer failed")
+			}
+		}
+	}
+}
+
+func list(api string) error {
+	transfers, err := fetchTransfers(api)
+	if err != nil {
+		return err
+	}
+	for _, p := range transfers {
+		printProgress(p)
+	}
+	return nil
+}
+
+func get(api, name, output string) error {
+	resp, err := http.Get(api + "/files/" + url.PathEscape(name))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return decode(resp, nil)
+	}
+	defer resp.Body.Close()
+
+	out, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,294 @@
+package arq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/8ff/udarp/pkg/frame"
+	"github.com/8ff/udarp/pkg/misc"
+	"github.com/8ff/udarp/pkg/pack"
+)
+
+/*
+Connected mode ARQ sessions over frame.TypeSession frames.
+
+One station dials, the other accepts, after that both sides can stream data. Data is cut into
+sequence numbered frames (the frame header Sequence) and sent in bursts of up to Window frames.
+The last frame of every transmission has the poll flag set, which hands the turn to the peer, so
+the link is used strictly half-duplex. The peer answers with a selective ack: the next sequence it
+expects and a bitmap of frames it already holds after that, holes in the bitmap are NACKs and get
+resent in the next burst. Ack and data can be sent in the same transmission.
+
+If a transmission goes unanswered for RetransmitTimeout it is repeated, after MaxRetries the session
+fails. If the poll frame itself is lost the peer takes the turn once the channel has been quiet for
+a frame time plus turnaround.
+
+Session payload:
+	0      Kind
+	1      Flags
+	Connect, Accept, Disconnect, DisconnectAck: nothing
+	Data   data
+	Ack    2..3 next expected sequence, 4..7 bitmap, bit i is sequence next+1+i
+*/
+
+type kind uint8
+
+const (
+	kindConnect kind = iota + 1
+	kindAccept
+	kindData
+	kindAck
+	kindDisconnect
+	kindDisconnectAck
+)
+
+const flagPoll = 0x01
+
+// Largest window the ack bitmap can describe
+const MaxWindow = 32
+
+var (
+	ErrClosed    = errors.New("session closed")
+	ErrTimeout   = errors.New("peer stopped answering")
+	ErrMalformed = errors.New("malformed session payload")
+)
+
+// Rig switches the radio between TX and RX, txControl.TxControl implements it
+type Rig interface {
+	TX() error
+	RX() error
+}
+
+// Transmitter sends a single frame over the air and returns once it has been sent
+type Transmitter func(f frame.Frame) error
+
+type Params struct {
+	Callsign   string
+	Window     int           // Data frames per transmission, default 8, at most MaxWindow
+	MaxData    int           // Data bytes per frame, default 64
+	FrameTime  time.Duration // Airtime of one frame, default 2s
+	PTTDelay   time.Duration // Delay between keying the rig and it transmitting, default 100ms
+	Turnaround time.Duration // Time a station needs to switch between RX and TX, default 300ms
+	MaxRetries int           // Unanswered transmissions before the session fails, default 8
+	Backlog    int           // Incoming sessions waiting for Accept, default 4
+}
+
+// RetransmitTimeout is how long to wait for an answer after our transmission ended: the peer
+// turning around and keying up, its ack, and our own switch back to RX, with a frame of slack
+func (p Params) RetransmitTimeout() time.Duration {
+	return 2*(p.Turnaround+p.PTTDelay) + 2*p.FrameTime
+}
+
+// quietTime is how long the channel has to be quiet before we take the turn without a poll
+func (p Params) quietTime() time.Duration {
+	return p.FrameTime + p.Turnaround
+}
+
+type Manager struct {
+	params Params
+	rig    Rig
+	tx     Transmitter
+
+	m        sync.Mutex
+	sessions map[string]*Session
+	accept   chan *Session
+	closed   bool
+
+	txM sync.Mutex // One transmission at a time, there is only one radio
+}
+
+// New returns a session manager, rig can be nil when PTT is handled elsewhere (VOX)
+func New(params Params, rig Rig, tx Transmitter) (*Manager, error) {
+	params.Callsign = strings.ToUpper(params.Callsign)
+	if _, err := pack.PackCallsign(params.Callsign); err != nil {
+		return nil, fmt.Errorf("invalid callsign: %w", err)
+	}
+	if tx == nil {
+		return nil, fmt.Errorf("transmitter is required")
+	}
+	if params.Window == 0 {
+		params.Window = 8
+	}
+	if params.Window < 1 || params.Window > MaxWindow {
+		return nil, fmt.Errorf("window must be between 1 and %d", MaxWindow)
+	}
+	if params.MaxData == 0 {
+		params.MaxData = 64
+	}
+	if params.MaxData < 1 || params.MaxData > frame.MaxPayload-2 {
+		return nil, fmt.Errorf("max data must be between 1 and %d", frame.MaxPayload-2)
+	}
+	if params.FrameTime == 0 {
+		params.FrameTime = 2 * time.Second
+	}
+	if params.PTTDelay == 0 {
+		params.PTTDelay = 100 * time.Millisecond
+	}
+	if params.Turnaround == 0 {
+		params.Turnaround = 300 * time.Millisecond
+	}
+	if params.MaxRetries == 0 {
+		params.MaxRetries = 8
+	}
+	if params.Backlog == 0 {
+		params.Backlog = 4
+	}
+
+	return &Manager{
+		params:   params,
+		rig:      rig,
+		tx:       tx,
+		sessions: make(map[string]*Session),
+		accept:   make(chan *Session, params.Backlog),
+	}, nil
+}
+
+// Dial connects to peer and returns once the peer accepted
+func (m *Manager) Dial(ctx context.Context, peer string) (*Session, error) {
+	peer = strings.ToUpper(peer)
+	if _, err := pack.PackCallsign(peer); err != nil {
+		return nil, fmt.Errorf("invalid peer: %w", err)
+	}
+
+	m.m.Lock()
+	if m.closed {
+		m.m.Unlock()
+		return nil, ErrClosed
+	}
+	if _, ok := m.sessions[peer]; ok {
+		m.m.Unlock()
+		return nil, fmt.Errorf("already have a session with %s", peer)
+	}
+	s := newSession(m, peer, stateConnecting)
+	m.sessions[peer] = s
+	m.m.Unlock()
+
+	go s.run()
+
+	select {
+	case <-s.connected:
+		return s, nil
+	case <-s.done:
+		return nil, s.Err()
+	case <-ctx.Done():
+		s.fail(ctx.Err())
+		return nil, ctx.Err()
+	}
+}
+
+// Accept returns the next session a peer dialed in
+func (m *Manager) Accept(ctx context.Context) (*Session, error) {
+	select {
+	case s := <-m.accept:
+		return s, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Handle passes a received frame to its session.
+// Frames that are not session frames for this station return false.
+func (m *Manager) Handle(f frame.Frame) bool {
+	if f.Type != frame.TypeSession || !strings.EqualFold(f.Destination, m.params.Callsign) {
+		return false
+	}
+	if len(f.Payload) < 2 {
+		misc.Log("warning", fmt.Sprintf("Session frame from %s: %v", f.Source, ErrMalformed))
+		return true
+	}
+	peer := strings.ToUpper(f.Source)
+
+	m.m.Lock()
+	if m.closed {
+		m.m.Unlock()
+		return true
+	}
+	s, ok := m.sessions[peer]
+	if !ok {
+		switch kind(f.Payload[0]) {
+		case kindConnect:
+			s = newSession(m, peer, stateConnected)
+			select {
+			case m.accept <- s:
+			default:
+				m.m.Unlock()
+				misc.Log("warning", fmt.Sprintf("Refusing session from %s, backlog full", peer))
+				return true
+			}
+			m.sessions[peer] = s
+			go s.run()
+		case kindDisconnect:
+			// Our DisconnectAck got lost and the session is already gone, answer again
+			m.m.Unlock()
+			go m.transmit([]frame.Frame{m.control(peer, kindDisconnectAck, 0)})
+			return true
+		default:
+			m.m.Unlock()
+			return true
+		}
+	}
+	m.m.Unlock()
+
+	s.deliver(f)
+	return true
+}
+
+// Close fails every open session
+func (m *Manager) Close() error {
+	m.m.Lock()
+	m.closed = true
+	sessions := make([]*Session, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		sessions = append(sessions, s)
+	}
+	m.m.Unlock()
+
+	for _, s := range sessions {
+		s.fail(ErrClosed)
+	}
+	return nil
+}
+
+func (m *Manager) remove(s *Session) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	if m.sessions[s.peer] == s {
+		delete(m.sessions, s.peer)
+	}
+}
+
+// transmit keys the rig, sends frames back to back and returns to RX
+func (m *Manager) transmit(frames []frame.Frame) error {
+	m.txM.Lock()
+	defer m.txM.Unlock()
+
+	if m.rig != nil {
+		if err := m.rig.TX(); err != nil {
+			return fmt.Errorf("failed to switch to TX: %w", err)
+		}
+		time.Sleep(m.params.PTTDelay)
+	}
+
+	var err error
+	for _, f := range frames {
+		if err = m.tx(f); err != nil {
+			err = fmt.Errorf("failed to transmit: %w", err)
+			break
+		}
+	}
+
+	if m.rig != nil {
+		if rxErr := m.rig.RX(); rxErr != nil && err == nil {
+			err = fmt.Errorf("failed to switch to RX: %w", rxErr)
+		}
+	}
+	return err
+}
+
+func (m *Manager) control(peer string, k kind, flags uint8) frame.Frame {
+	return frame.New(frame.TypeSession, m.params.Callsign, peer, 0, []byte{byte(k), flags})
+}
@@ -0,0 +1,208 @@
+package arq_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/8ff/udarp/pkg/arq"
+	"github.com/8ff/udarp/pkg/frame"
+)
+
+type fakeRig struct {
+	m       sync.Mutex
+	tx, rx  int
+	keyedUp bool
+}
+
+func (r *fakeRig) TX() error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.tx++
+	r.keyedUp = true
+	return nil
+}
+
+func (r *fakeRig) RX() error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.rx++
+	r.keyedUp = false
+	return nil
+}
+
+var testParams = arq.Params{
+	Window:     8,
+	MaxData:    32,
+	FrameTime:  5 * time.Millisecond,
+	PTTDelay:   time.Millisecond,
+	Turnaround: 2 * time.Millisecond,
+	MaxRetries: 20,
+}
+
+// link connects two managers over a channel that drops frames with probability loss
+func link(t *testing.T, loss float64) (*arq.Manager, *arq.Manager, *fakeRig) {
+	r := rand.New(rand.NewSource(1))
+	var rm sync.Mutex
+	var a, b *arq.Manager
+
+	air := func(to **arq.Manager) arq.Transmitter {
+		return func(f frame.Frame) error {
+			data, err := f.MarshalBinary()
+			if err != nil {
+				t.Errorf("MarshalBinary failed with error: %v", err)
+				return err
+			}
+			rm.Lock()
+			lost := r.Float64() < loss
+			rm.Unlock()
+			if lost {
+				return nil
+			}
+			var received frame.Frame
+			if err := received.UnmarshalBinary(data); err != nil {
+				t.Errorf("UnmarshalBinary failed with error: %v", err)
+				return err
+			}
+			(*to).Handle(received)
+			return nil
+		}
+	}
+
+	rig := &fakeRig{}
+	pa, pb := testParams, testParams
+	pa.Callsign, pb.Callsign = "K1ABC", "KA1XYZ"
+	var err error
+	if a, err = arq.New(pa, rig, air(&b)); err != nil {
+		t.Fatalf("New failed with error: %v", err)
+	}
+	if b, err = arq.New(pb, nil, air(&a)); err != nil {
+		t.Fatalf("New failed with error: %v", err)
+	}
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return a, b, rig
+}
+
+func connect(t *testing.T, a, b *arq.Manager) (*arq.Session, *arq.Session) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	accepted := make(chan *arq.Session, 1)
+	go func() {
+		s, err := b.Accept(ctx)
+		if err != nil {
+			t.Errorf("Accept failed with error: %v", err)
+		}
+		accepted <- s
+	}()
+
+	dialed, err := a.Dial(ctx, "ka1xyz")
+	if err != nil {
+		t.Fatalf("Dial failed with error: %v", err)
+	}
+	s := <-accepted
+	if s == nil {
+		t.FailNow()
+	}
+	if dialed.Peer() != "KA1XYZ" || s.Peer() != "K1ABC" {
+		t.Fatalf("Unexpected peers %s and %s", dialed.Peer(), s.Peer())
+	}
+	return dialed, s
+}
+
+func transfer(t *testing.T, loss float64) {
+	a, b, rig := link(t, loss)
+	client, server := connect(t, a, b)
+
+	sent := make([]byte, 5000)
+	rand.New(rand.NewSource(2)).Read(sent)
+	reply := []byte("73 de KA1XYZ")
+
+	errs := make(chan error, 2)
+	go func() {
+		if _, err := client.Write(sent); err != nil {
+			errs <- err
+			return
+		}
+		got, err := io.ReadAll(io.LimitReader(client, int64(len(reply))))
+		if err == nil && !bytes.Equal(got, reply) {
+			t.Errorf("Client got %q, expected %q", got, reply)
+		}
+		if err == nil {
+			err = client.Close()
+		}
+		errs <- err
+	}()
+	go func() {
+		got, err := io.ReadAll(io.LimitReader(server, int64(len(sent))))
+		if err == nil && !bytes.Equal(got, sent) {
+			t.Errorf("Server data does not match what was sent")
+		}
+		if err == nil {
+			_, err = server.Write(reply)
+		}
+		if err == nil {
+			// Client disconnects once the reply is acked
+			var rest []byte
+			rest, err = io.ReadAll(server)
+			if len(rest) != 0 {
+				t.Errorf("Unexpected trailing data %q", rest)
+			}
+		}
+		errs <- err
+	}()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			if err != nil {
+				t.Fatalf("Transfer failed with error: %v", err)
+			}
+		case <-time.After(30 * time.Second):
+			t.Fatalf("Transfer timed out")
+		}
+	}
+
+	rig.m.Lock()
+	defer rig.m.Unlock()
+	if rig.tx == 0 || rig.tx != rig.rx || rig.keyedUp {
+		t.Fatalf("Every TX should be followed by RX, got %d TX and %d RX", rig.tx, rig.rx)
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	transfer(t, 0)
+}
+
+func TestTransferWithLoss(t *testing.T) {
+	transfer(t, 0.25)
+}
+
+func TestDialTimeout(t *testing.T) {
+	params := testParams
+	params.Callsign = "K1ABC"
+	params.MaxRetries = 2
+	m, err := arq.New(params, nil, func(frame.Frame) error { return nil })
+	if err != nil {
+		t.Fatalf("New failed with error: %v", err)
+	}
+	defer m.Close()
+
+	if _, err := m.Dial(context.Background(), "KA1XYZ"); err != arq.ErrTimeout {
+		t.Fatalf("Expected ErrTimeout dialing a silent peer, got %v", err)
+	}
+}
+
+func TestRetransmitTimeout(t *testing.T) {
+	p := arq.Params{FrameTime: 2 * time.Second, PTTDelay: 100 * time.Millisecond, Turnaround: 300 * time.Millisecond}
+	if got := p.RetransmitTimeout(); got != 4800*time.Millisecond {
+		t.Fatalf("RetransmitTimeout is %s, expected 4.8s", got)
+	}
+}
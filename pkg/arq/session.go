@@ -0,0 +1,481 @@
+package arq
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/8ff/udarp/pkg/frame"
+	"github.com/8ff/udarp/pkg/misc"
+)
+
+type state int
+
+const (
+	stateConnecting state = iota
+	stateConnected
+	stateClosing
+	stateClosed
+)
+
+// Session is one end of a connection, it implements io.ReadWriteCloser
+type Session struct {
+	m    *Manager
+	peer string
+
+	in        chan frame.Frame
+	wake      chan struct{}
+	connected chan struct{}
+	done      chan struct{}
+
+	mu    sync.Mutex
+	cond  *sync.Cond
+	state state
+	err   error
+
+	// Turn taking
+	turn      bool      // Peer polled us, we may transmit right away
+	busyUntil time.Time // Peer may still be transmitting until then
+	awaiting  bool      // Waiting for an answer to our last transmission
+	deadline  time.Time // When the answer is overdue
+	retries   int
+
+	// Sending
+	pending  []byte            // Written but not cut into frames yet
+	nextSeq  uint16            // Sequence of the next new frame
+	unacked  map[uint16][]byte // Sent but not acked
+	localEOF bool              // Close was called
+
+	// Receiving
+	recvNext     uint16
+	ooo          map[uint16][]byte // Received ahead of recvNext
+	readBuf      []byte
+	ackDue       bool
+	acceptDue    bool
+	discAckDue   bool
+	remoteClosed bool
+}
+
+func newSession(m *Manager, peer string, st state) *Session {
+	s := &Session{
+		m:         m,
+		peer:      peer,
+		in:        make(chan frame.Frame, 4*MaxWindow),
+		wake:      make(chan struct{}, 1),
+		connected: make(chan struct{}),
+		done:      make(chan struct{}),
+		state:     st,
+		unacked:   make(map[uint16][]byte),
+		ooo:       make(map[uint16][]byte),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	if st == stateConnected {
+		s.acceptDue = true
+		close(s.connected)
+	}
+	return s
+}
+
+// Peer returns the callsign of the other end
+func (s *Session) Peer() string {
+	return s.peer
+}
+
+// Err returns why the session closed, nil while it is open or after a clean disconnect
+func (s *Session) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Read reads received data, it returns io.EOF once the peer disconnected and everything was read
+func (s *Session) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.readBuf) == 0 {
+		if s.remoteClosed || (s.state == stateClosed && s.err == nil) {
+			return 0, io.EOF
+		}
+		if s.state == stateClosed {
+			return 0, s.err
+		}
+		s.cond.Wait()
+	}
+
+	n := copy(p, s.readBuf)
+	s.readBuf = s.readBuf[n:]
+	return n, nil
+}
+
+// Write queues data for sending, it blocks while too much data is waiting to be sent
+func (s *Session) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	limit := 4 * s.m.params.Window * s.m.params.MaxData
+	written := 0
+	for written < len(p) {
+		if err := s.writeErr(); err != nil {
+			return written, err
+		}
+		if len(s.pending) >= limit {
+			s.cond.Wait()
+			continue
+		}
+		n := len(p) - written
+		if n > limit-len(s.pending) {
+			n = limit - len(s.pending)
+		}
+		s.pending = append(s.pending, p[written:written+n]...)
+		written += n
+		s.poke()
+	}
+	return written, nil
+}
+
+func (s *Session) writeErr() error {
+	switch {
+	case s.state == stateClosed && s.err != nil:
+		return s.err
+	case s.state == stateClosed || s.remoteClosed:
+		return fmt.Errorf("%w by peer", ErrClosed)
+	case s.localEOF:
+		return ErrClosed
+	}
+	return nil
+}
+
+// Close waits until the peer acked everything written and disconnects
+func (s *Session) Close() error {
+	s.mu.Lock()
+	s.localEOF = true
+	s.poke()
+	for s.state != stateClosed {
+		s.cond.Wait()
+	}
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// poke wakes up the run loop, called with mu held
+func (s *Session) poke() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Session) deliver(f frame.Frame) {
+	select {
+	case s.in <- f:
+	default:
+		misc.Log("warning", fmt.Sprintf("Session with %s is not keeping up, dropping frame", s.peer))
+	}
+}
+
+// fail closes the session with err
+func (s *Session) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.finish(err)
+}
+
+// finish closes the session, called with mu held
+func (s *Session) finish(err error) {
+	if s.state == stateClosed {
+		return
+	}
+	s.state = stateClosed
+	s.err = err
+	close(s.done)
+	s.cond.Broadcast()
+	s.m.remove(s)
+	if err != nil && err != ErrClosed {
+		misc.Log("warning", fmt.Sprintf("Session with %s failed: %v", s.peer, err))
+	}
+}
+
+func (s *Session) run() {
+	for {
+		frames, expectReply, wait := s.next()
+		if frames == nil && wait < 0 {
+			return
+		}
+
+		if len(frames) > 0 {
+			err := s.m.transmit(frames)
+
+			s.mu.Lock()
+			if err != nil {
+				s.finish(err)
+				s.mu.Unlock()
+				return
+			}
+			// The turn is the peer's until it polls us or stays quiet
+			s.busyUntil = time.Now().Add(s.m.params.RetransmitTimeout())
+			if expectReply {
+				s.awaiting = true
+				s.deadline = time.Now().Add(s.m.params.RetransmitTimeout())
+			}
+			if s.state == stateClosing && s.discAckDue {
+				// We answered the peer's disconnect, nothing more to say
+				s.finish(nil)
+			}
+			s.mu.Unlock()
+			continue
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case f := <-s.in:
+			s.receive(f)
+		case <-s.wake:
+		case <-timer.C:
+		case <-s.done:
+		}
+		timer.Stop()
+	}
+}
+
+// next decides what to transmit, or how long to wait when it is not our turn or there is nothing to say.
+// A negative wait means the session is over.
+func (s *Session) next() ([]frame.Frame, bool, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state == stateClosed {
+		return nil, false, -1
+	}
+
+	now := time.Now()
+	if !s.turn && now.Before(s.busyUntil) {
+		return nil, false, s.busyUntil.Sub(now)
+	}
+
+	timedOut := s.awaiting && !now.Before(s.deadline)
+	if timedOut {
+		s.awaiting = false
+		s.retries++
+		if s.retries > s.m.params.MaxRetries {
+			s.finish(ErrTimeout)
+			return nil, false, -1
+		}
+	}
+
+	var frames []frame.Frame
+	expectReply := false
+
+	switch s.state {
+	case stateConnecting:
+		if !s.awaiting {
+			frames = append(frames, s.m.control(s.peer, kindConnect, 0))
+			expectReply = true
+		}
+
+	case stateConnected:
+		if s.acceptDue {
+			frames = append(frames, s.m.control(s.peer, kindAccept, 0))
+			s.acceptDue = false
+		}
+		if s.ackDue {
+			frames = append(frames, s.ack())
+			s.ackDue = false
+		}
+		if !s.awaiting {
+			data := s.dataFrames()
+			frames = append(frames, data...)
+			expectReply = len(data) > 0
+		}
+		if !s.awaiting && !expectReply && s.localEOF && len(s.pending) == 0 && len(s.unacked) == 0 {
+			s.state = stateClosing
+			s.retries = 0
+			frames = append(frames, s.m.control(s.peer, kindDisconnect, 0))
+			expectReply = true
+		}
+
+	case stateClosing:
+		if s.discAckDue {
+			frames = append(frames, s.m.control(s.peer, kindDisconnectAck, 0))
+		} else if !s.awaiting {
+			frames = append(frames, s.m.control(s.peer, kindDisconnect, 0))
+			expectReply = true
+		}
+	}
+
+	if len(frames) > 0 {
+		frames[len(frames)-1].Payload[1] |= flagPoll
+		s.turn = false
+		return frames, expectReply, 0
+	}
+
+	if s.awaiting {
+		return nil, false, s.deadline.Sub(now)
+	}
+	return nil, false, time.Hour
+}
+
+// dataFrames returns unacked frames to resend followed by new frames, up to Window, called with mu held
+func (s *Session) dataFrames() []frame.Frame {
+	seqs := make([]uint16, 0, len(s.unacked))
+	for seq := range s.unacked {
+		seqs = append(seqs, seq)
+	}
+	// Oldest first, relative to the oldest unacked frame so wraparound sorts correctly
+	base := s.nextSeq - uint16(len(s.unacked))
+	for seq := range s.unacked {
+		if int16(seq-base) < 0 {
+			base = seq
+		}
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i]-base < seqs[j]-base })
+
+	window := s.m.params.Window
+	var frames []frame.Frame
+	for _, seq := range seqs {
+		if len(frames) == window {
+			return frames
+		}
+		frames = append(frames, s.dataFrame(seq, s.unacked[seq]))
+	}
+
+	// New frames may not run further ahead of the oldest unacked frame than the ack bitmap reaches
+	for len(frames) < window && len(s.pending) > 0 && (len(seqs) == 0 || int(s.nextSeq-base) < MaxWindow) {
+		n := s.m.params.MaxData
+		if n > len(s.pending) {
+			n = len(s.pending)
+		}
+		data := append([]byte{}, s.pending[:n]...)
+		s.pending = s.pending[n:]
+		s.unacked[s.nextSeq] = data
+		frames = append(frames, s.dataFrame(s.nextSeq, data))
+		s.nextSeq++
+	}
+	if len(frames) > 0 {
+		// Room freed up for blocked writers
+		s.cond.Broadcast()
+	}
+	return frames
+}
+
+func (s *Session) dataFrame(seq uint16, data []byte) frame.Frame {
+	payload := append([]byte{byte(kindData), 0}, data...)
+	return frame.New(frame.TypeSession, s.m.params.Callsign, s.peer, seq, payload)
+}
+
+// ack returns a selective ack for what we received so far, called with mu held
+func (s *Session) ack() frame.Frame {
+	var bitmap uint32
+	for seq := range s.ooo {
+		if d := seq - s.recvNext - 1; d < 32 {
+			bitmap |= 1 << d
+		}
+	}
+	payload := []byte{byte(kindAck), 0}
+	payload = binary.BigEndian.AppendUint16(payload, s.recvNext)
+	payload = binary.BigEndian.AppendUint32(payload, bitmap)
+	return frame.New(frame.TypeSession, s.m.params.Callsign, s.peer, 0, payload)
+}
+
+func (s *Session) receive(f frame.Frame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state == stateClosed || len(f.Payload) < 2 {
+		return
+	}
+
+	if f.Payload[1]&flagPoll != 0 {
+		s.turn = true
+		s.busyUntil = time.Time{}
+	} else {
+		s.turn = false
+		s.busyUntil = time.Now().Add(s.m.params.quietTime())
+	}
+
+	switch kind(f.Payload[0]) {
+	case kindConnect:
+		// Our accept got lost
+		if s.state == stateConnected {
+			s.acceptDue = true
+		}
+
+	case kindAccept:
+		s.connect()
+
+	case kindData:
+		// Data can only come after the peer accepted, the accept itself may have been lost
+		s.connect()
+		if s.state != stateConnected && s.state != stateClosing {
+			return
+		}
+		s.ackDue = true
+		d := int16(f.Sequence - s.recvNext)
+		if d < 0 || d >= 2*MaxWindow {
+			return
+		}
+		s.ooo[f.Sequence] = append([]byte{}, f.Payload[2:]...)
+		for {
+			data, ok := s.ooo[s.recvNext]
+			if !ok {
+				break
+			}
+			delete(s.ooo, s.recvNext)
+			s.readBuf = append(s.readBuf, data...)
+			s.recvNext++
+		}
+		s.cond.Broadcast()
+
+	case kindAck:
+		if len(f.Payload) != 8 {
+			return
+		}
+		next := binary.BigEndian.Uint16(f.Payload[2:])
+		bitmap := binary.BigEndian.Uint32(f.Payload[4:])
+		before := len(s.unacked)
+		for seq := range s.unacked {
+			d := seq - next
+			if int16(d) < 0 || (d > 0 && d <= 32 && bitmap&(1<<(d-1)) != 0) {
+				delete(s.unacked, seq)
+			}
+		}
+		// Anything still unacked was lost, it goes out again in our next burst
+		s.awaiting = false
+		if len(s.unacked) < before {
+			s.retries = 0
+		} else {
+			s.retries++
+			if s.retries > s.m.params.MaxRetries {
+				s.finish(ErrTimeout)
+				return
+			}
+		}
+		s.cond.Broadcast()
+
+	case kindDisconnect:
+		s.remoteClosed = true
+		s.discAckDue = true
+		s.awaiting = false
+		s.state = stateClosing
+		s.cond.Broadcast()
+
+	case kindDisconnectAck:
+		if s.state == stateClosing {
+			s.finish(nil)
+		}
+	}
+}
+
+// connect moves a dialing session to connected, called with mu held
+func (s *Session) connect() {
+	if s.state != stateConnecting {
+		return
+	}
+	s.state = stateConnected
+	s.awaiting = false
+	s.retries = 0
+	close(s.connected)
+}
@@ -1,7 +1,6 @@
 package audio
 
 import (
-	"bytes"
 	"fmt"
 	"os"
 
@@ -20,7 +19,7 @@ func GetDefaultPlaybackDevice() (*malgo.DeviceInfo, error) {
 		return nil, fmt.Errorf("no playback devices found")
 	}
 
-	return &playbackDevices[0], nil
+	return defaultDevice(playbackDevices), nil
 }
 
 func GetDefaultCaptureDevice() (*malgo.DeviceInfo, error) {
@@ -34,7 +33,17 @@ func GetDefaultCaptureDevice() (*malgo.DeviceInfo, error) {
 		return nil, fmt.Errorf("no capture devices found")
 	}
 
-	return &captureDevices[0], nil
+	return defaultDevice(captureDevices), nil
+}
+
+// The device the system marks as default, or the first one if none is
+func defaultDevice(devices []malgo.DeviceInfo) *malgo.DeviceInfo {
+	for i := range devices {
+		if devices[i].IsDefault != 0 {
+			return &devices[i]
+		}
+	}
+	return &devices[0]
 }
 
 // GetAudioDevices returns a list of all playback and capture devices.
@@ -76,8 +85,7 @@ func DeviceFromHash(deviceHash string) (*malgo.DeviceInfo, error) {
 	// Lookup device IDs in listDevices
 	playbackDevices, captureDevices, err := GetAudioDevices()
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return nil, err
 	}
 
 	// Go over all playback devices and find the one with the given ID
@@ -107,60 +115,19 @@ func PrettyPrintDevices() {
 	// Print playback devices
 	fmt.Println("\x1b[32m**** Playback devices ****\x1b[0m")
 	for _, device := range playbackDevices {
-		fmt.Printf("ID: \x1b[32m%s\x1b[0m - Name: \x1b[32m%s\x1b[0m\n", misc.Md5HashString(device.ID.String()), device.Name())
+		fmt.Printf("ID: \x1b[32m%s\x1b[0m - Name: \x1b[32m%s\x1b[0m%s\n", misc.Md5HashString(device.ID.String()), device.Name(), defaultMark(device))
 	}
 
 	// Print capture devices
 	fmt.Println("\n\x1b[31m**** Capture devices ****\x1b[0m")
 	for _, device := range captureDevices {
-		fmt.Printf("ID: \x1b[31m%s\x1b[0m - Name: \x1b[31m%s\x1b[0m\n", misc.Md5HashString(device.ID.String()), device.Name())
+		fmt.Printf("ID: \x1b[31m%s\x1b[0m - Name: \x1b[31m%s\x1b[0m%s\n", misc.Md5HashString(device.ID.String()), device.Name(), defaultMark(device))
 	}
 }
 
-func PlayWave(deviceConfig malgo.DeviceConfig, buffer []byte) error {
-	toneBuffer := new(bytes.Buffer)
-	toneBuffer.Write(buffer)
-
-	ctx, err := malgo.InitContext(nil, malgo.ContextConfig{}, func(message string) {})
-	if err != nil {
-		return err
-	}
-
-	defer func() {
-		_ = ctx.Uninit()
-		ctx.Free()
-	}()
-
-	samplesConsumed := int64(0)
-	playbackDone := make(chan bool)
-
-	// This is the function that's used for sending more data to the device for playback.
-	onSamples := func(pOutputSample, pInputSamples []byte, framecount uint32) {
-		copy(pOutputSample, toneBuffer.Next(len(pOutputSample)))
-		samplesConsumed += int64(len(pOutputSample))
-		if samplesConsumed > int64(len(buffer)) {
-			playbackDone <- true
-		}
-	}
-
-	deviceCallbacks := malgo.DeviceCallbacks{
-		Data: onSamples,
-	}
-
-	device, err := malgo.InitDevice(ctx.Context, deviceConfig, deviceCallbacks)
-	if err != nil {
-		return err
-	}
-	defer device.Uninit()
-
-	err = device.Start()
-	if err != nil {
-		return err
-	}
-
-	done := <-playbackDone
-	if done {
-		fmt.Fprintf(os.Stderr, "SAMPLES_CONSUMED: %d PLAYBACK_DONE\n", samplesConsumed)
+func defaultMark(device malgo.DeviceInfo) string {
+	if device.IsDefault != 0 {
+		return " (default)"
 	}
-	return nil
+	return ""
 }
@@ -0,0 +1,413 @@
+package audio_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/8ff/udarp/pkg/audio"
+	"github.com/8ff/udarp/pkg/fskGenerator"
+	"github.com/gen2brain/malgo"
+)
+
+const (
+	testRate    = 8000
+	testBitMS   = 50
+	testTone    = 1500.0
+	bitSamples  = testRate / 1000 * testBitMS
+	toneEnergy  = 0.01
+	receiveSize = 333 // Odd on purpose, reads must not depend on sample alignment
+)
+
+// Power of the test tone in every bit slot, a plain Goertzel filter
+func demodulate(pcm []byte) []int {
+	var bits []int
+	k := 2 * math.Cos(2*math.Pi*testTone/testRate)
+	for start := 0; start+bitSamples*2 <= len(pcm); start += bitSamples * 2 {
+		var s1, s2 float64
+		for i := 0; i < bitSamples; i++ {
+			x := float64(int16(binary.LittleEndian.Uint16(pcm[start+i*2:]))) / 32768
+			s1, s2 = x+k*s1-s2, s1
+		}
+		power := (s1*s1 + s2*s2 - k*s1*s2) / bitSamples / bitSamples
+		bit := 0
+		if power > toneEnergy {
+			bit = 1
+		}
+		bits = append(bits, bit)
+	}
+	return bits
+}
+
+func readAll(t *testing.T, source audio.Source) []byte {
+	var received []byte
+	chunk := make([]byte, receiveSize)
+	for {
+		n, err := source.Read(chunk)
+		received = append(received, chunk[:n]...)
+		if err == io.EOF {
+			return received
+		}
+		if err != nil {
+			t.Fatalf("Read failed with error: %v", err)
+		}
+	}
+}
+
+func TestLoopbackTXtoRX(t *testing.T) {
+	bits := []int{0, 1, 1, 0, 1, 0, 0, 1, 1, 1, 0, 1}
+	sink, source := audio.Loopback(testRate)
+	if source.SampleRate() != testRate || sink.SampleRate() != testRate {
+		t.Fatalf("Expected both ends at %d Hz", testRate)
+	}
+
+	go func() {
+		// Transmit bit by bit like a slow sound card would
+		for _, bit := range bits {
+			sink.Write(fskGenerator.FlexFsk(testRate, testBitMS, testTone, []int{bit}))
+		}
+		sink.Close()
+	}()
+
+	received := readAll(t, source)
+	if len(received) != len(bits)*bitSamples*2 {
+		t.Fatalf("Expected %d bytes, got %d", len(bits)*bitSamples*2, len(received))
+	}
+	decoded := demodulate(received)
+	for i := range bits {
+		if decoded[i] != bits[i] {
+			t.Fatalf("Expected bits %v, got %v", bits, decoded)
+		}
+	}
+
+	if _, err := sink.Write([]byte{0, 0}); !errors.Is(err, audio.ErrClosed) {
+		t.Fatalf("Expected ErrClosed writing to a closed loopback, got %v", err)
+	}
+}
+
+func TestLoopbackCloseSource(t *testing.T) {
+	sink, source := audio.Loopback(testRate)
+	done := make(chan error)
+	go func() {
+		_, err := source.Read(make([]byte, 2))
+		done <- err
+	}()
+	source.Close()
+	if err := <-done; err != io.EOF {
+		t.Fatalf("Expected a blocked Read to return io.EOF after Close, got %v", err)
+	}
+	if _, err := sink.Write([]byte{0, 0}); !errors.Is(err, audio.ErrClosed) {
+		t.Fatalf("Expected ErrClosed, got %v", err)
+	}
+}
+
+func TestWAVRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tx.WAV")
+	wave := fskGenerator.FlexFsk(testRate, testBitMS, testTone, []int{1, 0, 1})
+
+	sink, err := audio.CreateFile(path, testRate)
+	if err != nil {
+		t.Fatalf("CreateFile failed with error: %v", err)
+	}
+	sink.Write(wave[:101])
+	sink.Write(wave[101:])
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close failed with error: %v", err)
+	}
+
+	header := make([]byte, 12)
+	f, _ := os.Open(path)
+	f.Read(header)
+	f.Close()
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		t.Fatalf("Expected a WAV header for a .WAV path, got %q", header)
+	}
+
+	source, err := audio.OpenFile(path, 44100)
+	if err != nil {
+		t.Fatalf("OpenFile failed with error: %v", err)
+	}
+	defer source.Close()
+	if source.SampleRate() != testRate {
+		t.Fatalf("Expected the sample rate from the file, %d, got %d", testRate, source.SampleRate())
+	}
+	if got := readAll(t, source); !bytes.Equal(got, wave) {
+		t.Fatalf("Expected %d bytes back, got %d", len(wave), len(got))
+	}
+}
+
+func TestWAVRejectsStereo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stereo.wav")
+	h := []byte("RIFF\x00\x00\x00\x00WAVEfmt \x10\x00\x00\x00")
+	h = binary.LittleEndian.AppendUint16(h, 1)
+	h = binary.LittleEndian.AppendUint16(h, 2)
+	h = binary.LittleEndian.AppendUint32(h, testRate)
+	h = binary.LittleEndian.AppendUint32(h, testRate*4)
+	h = binary.LittleEndian.AppendUint16(h, 4)
+	h = binary.LittleEndian.AppendUint16(h, 16)
+	h = append(h, "data\x00\x00\x00\x00"...)
+	os.WriteFile(path, h, 0o644)
+
+	if _, err := audio.OpenWAV(path); !errors.Is(err, audio.ErrWAVFormat) {
+		t.Fatalf("Expected ErrWAVFormat, got %v", err)
+	}
+}
+
+func TestWAVRejectsOversizedFmt(t *testing.T) {
+	for _, size := range []string{"\xFF\xFF\xFF\xFF", "\xFF\xFF\xFF\x7F", "\x42\x00\x00\x00"} {
+		path := filepath.Join(t.TempDir(), "bad.wav")
+		h := []byte("RIFF\x00\x00\x00\x00WAVEfmt " + size)
+		h = append(h, make([]byte, 64)...)
+		os.WriteFile(path, h, 0o644)
+
+		if _, err := audio.OpenWAV(path); !errors.Is(err, audio.ErrWAVFormat) {
+			t.Fatalf("Expected ErrWAVFormat for fmt size %q, got %v", size, err)
+		}
+	}
+}
+
+func TestRawFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rx.raw")
+	wave := fskGenerator.FlexFsk(testRate, testBitMS, testTone, []int{0, 1})
+
+	sink, _ := audio.CreateFile(path, testRate)
+	sink.Write(wave)
+	sink.Close()
+
+	if info, _ := os.Stat(path); info.Size() != int64(len(wave)) {
+		t.Fatalf("Expected a headerless file of %d bytes, got %d", len(wave), info.Size())
+	}
+
+	source, err := audio.OpenFile(path, testRate)
+	if err != nil {
+		t.Fatalf("OpenFile failed with error: %v", err)
+	}
+	defer source.Close()
+	if got := demodulate(readAll(t, source)); got[0] != 0 || got[1] != 1 {
+		t.Fatalf("Expected bits [0 1], got %v", got)
+	}
+}
+
+// The null backend runs the callbacks on a timer, no sound card needed.
+// malgo's Backend enum lacks miniaudio's custom backend, so its BackendNull is one short.
+const backendNull = malgo.BackendNull + 1
+
+func nullEngine(t *testing.T) *audio.Engine {
+	e, err := audio.NewEngine(audio.EngineParams{SampleRate: testRate, Latency: 20 * time.Millisecond, Backends: []malgo.Backend{backendNull}})
+	if err != nil {
+		t.Skipf("Null audio backend not available: %v", err)
+	}
+	t.Cleanup(func() { e.Close() })
+	return e
+}
+
+func TestEngineQueuesBackToBack(t *testing.T) {
+	e := nullEngine(t)
+	wave := fskGenerator.FlexFsk(testRate, 100, testTone, []int{1, 0})
+
+	first, err := e.Play(wave)
+	if err != nil {
+		t.Fatalf("Play failed with error: %v", err)
+	}
+	second, _ := e.Play(wave)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := second.Wait(ctx); err != nil {
+		t.Fatalf("Wait failed with error: %v", err)
+	}
+
+	// 200ms each, the sample clock says so regardless of how the callbacks were scheduled
+	if d := first.Stop().Sub(first.Start()); d < 190*time.Millisecond || d > 260*time.Millisecond {
+		t.Fatalf("Expected about 200ms of audio, took %s", d)
+	}
+	if gap := second.Start().Sub(first.Stop()); gap < -5*time.Millisecond || gap > 60*time.Millisecond {
+		t.Fatalf("Expected the second transmission to follow the first, gap %s", gap)
+	}
+}
+
+func TestEnginePlayAt(t *testing.T) {
+	e := nullEngine(t)
+	at := time.Now().Add(300 * time.Millisecond)
+	tx, _ := e.PlayAt(at, fskGenerator.FlexFsk(testRate, testBitMS, testTone, []int{1}))
+
+	select {
+	case <-tx.Started():
+		t.Fatalf("Transmission started before its time")
+	case <-time.After(150 * time.Millisecond):
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tx.Wait(ctx); err != nil {
+		t.Fatalf("Wait failed with error: %v", err)
+	}
+	if early := at.Sub(tx.Start()); early > 5*time.Millisecond {
+		t.Fatalf("Expected the start at or after %s, got %s", at.Format("15:04:05.000"), tx.Start().Format("15:04:05.000"))
+	}
+}
+
+func TestEngineClose(t *testing.T) {
+	e := nullEngine(t)
+	tx, _ := e.PlayAt(time.Now().Add(time.Hour), []byte{0, 0})
+
+	read := make(chan error)
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			if _, err := e.Read(buf); err != nil {
+				read <- err
+				return
+			}
+		}
+	}()
+
+	e.Close()
+	if err := tx.Wait(context.Background()); !errors.Is(err, audio.ErrClosed) {
+		t.Fatalf("Expected a pending transmission to fail with ErrClosed, got %v", err)
+	}
+	if err := <-read; err != io.EOF {
+		t.Fatalf("Expected Read to return io.EOF after Close, got %v", err)
+	}
+	if _, err := e.Play([]byte{0, 0}); !errors.Is(err, audio.ErrClosed) {
+		t.Fatalf("Expected ErrClosed, got %v", err)
+	}
+}
+
+func TestSelectDevice(t *testing.T) {
+	devices := []audio.Device{
+		{Name: "Built-in Audio Analog Stereo", ID: "616c7361", Hash: "2f1e3c00aa"},
+		{Name: "USB Audio CODEC", ID: "757362", Hash: "9b0d7e11bb", Default: true},
+		{Name: "USB Audio CODEC #2", ID: "75736232", Hash: "4c4a2a22cc"},
+	}
+
+	for _, c := range []struct {
+		selector string
+		want     string
+	}{
+		{"default", "USB Audio CODEC"},
+		{"4c4a2a22cc", "USB Audio CODEC #2"},
+		{"616c7361", "Built-in Audio Analog Stereo"},
+		{"USB Audio CODEC", "USB Audio CODEC"}, // Exact name wins over the substring matching both
+		{"built-in", "Built-in Audio Analog Stereo"},
+		{"re:#\\d$", "USB Audio CODEC #2"},
+		{"/^Built/", "Built-in Audio Analog Stereo"},
+	} {
+		d, err := audio.Select(devices, c.selector)
+		if err != nil {
+			t.Fatalf("Select(%q) failed with error: %v", c.selector, err)
+		}
+		if d.Name != c.want {
+			t.Fatalf("Select(%q) picked %q, expected %q", c.selector, d.Name, c.want)
+		}
+	}
+
+	_, err := audio.Select(devices, "usb")
+	if !errors.Is(err, audio.ErrAmbiguous) {
+		t.Fatalf("Expected ErrAmbiguous, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "9b0d7e11bb") || !strings.Contains(err.Error(), "4c4a2a22cc") || strings.Contains(err.Error(), "2f1e3c00aa") {
+		t.Fatalf("Expected the error to list both USB devices and only them, got %v", err)
+	}
+
+	if _, err := audio.Select(devices, "re:^Headset"); !errors.Is(err, audio.ErrNoDevice) {
+		t.Fatalf("Expected ErrNoDevice, got %v", err)
+	}
+	if _, err := audio.Select(devices, "re:("); err == nil {
+		t.Fatalf("Expected an invalid regular expression to fail")
+	}
+	if _, err := audio.Select(nil, "default"); !errors.Is(err, audio.ErrNoDevice) {
+		t.Fatalf("Expected ErrNoDevice without devices, got %v", err)
+	}
+}
+
+func TestDeviceJSON(t *testing.T) {
+	data, _ := json.Marshal(audio.Device{Name: "USB Audio CODEC", Type: "capture", SampleRates: []uint32{44100, 48000}, Channels: []uint32{1, 2}})
+	var fields map[string]interface{}
+	json.Unmarshal(data, &fields)
+	for _, key := range []string{"name", "id", "hash", "type", "default", "sample_rates", "channels"} {
+		if _, ok := fields[key]; !ok {
+			t.Fatalf("Expected %q in %s", key, data)
+		}
+	}
+}
+
+func sine(amplitude float64, samples int) []byte {
+	pcm := make([]byte, 0, samples*2)
+	for i := 0; i < samples; i++ {
+		x := amplitude * math.Sin(2*math.Pi*testTone*float64(i)/testRate)
+		x = math.Max(math.Min(math.Round(x*32768), math.MaxInt16), math.MinInt16)
+		pcm = binary.LittleEndian.AppendUint16(pcm, uint16(int16(x)))
+	}
+	return pcm
+}
+
+func TestMeter(t *testing.T) {
+	m := audio.NewMeter(audio.MeterParams{Window: 100 * time.Millisecond}, testRate)
+	if l := m.Level(); !l.Updated.IsZero() || l.RMS != audio.SilenceDBFS {
+		t.Fatalf("Expected silence before the first window, got %+v", l)
+	}
+
+	// Half scale sine, fed in odd sized pieces
+	pcm := sine(0.5, testRate/10)
+	for len(pcm) > 0 {
+		n := 333
+		if n > len(pcm) {
+			n = len(pcm)
+		}
+		m.Write(pcm[:n])
+		pcm = pcm[n:]
+	}
+	l := m.Level()
+	if math.Abs(l.Peak-audio.DBFS(0.5)) > 0.1 || math.Abs(l.RMS-audio.DBFS(0.5/math.Sqrt2)) > 0.1 {
+		t.Fatalf("Expected peak -6 and RMS -9 dBFS, got %+v", l)
+	}
+	if l.Clipping || l.TooQuiet || l.Clipped != 0 {
+		t.Fatalf("Expected a clean level, got %+v", l)
+	}
+
+	m.Write(sine(2, testRate/10))
+	if l := m.Level(); !l.Clipping || l.Clipped == 0 {
+		t.Fatalf("Expected clipping to be detected, got %+v", l)
+	}
+
+	m.Write(sine(0.001, testRate/10))
+	if l := m.Level(); !l.TooQuiet || l.Clipping || l.Clipped == 0 {
+		t.Fatalf("Expected a quiet window, with clipped samples still counted, got %+v", l)
+	}
+}
+
+func TestMeterSourceAndScale(t *testing.T) {
+	sink, source := audio.Loopback(testRate)
+	m := audio.NewMeter(audio.MeterParams{Window: 50 * time.Millisecond}, testRate)
+	source = audio.MeterSource(source, m)
+
+	pcm := sine(0.8, testRate/20)
+	audio.Scale(pcm, 0.25)
+	sink.Write(pcm)
+	sink.Close()
+	readAll(t, source)
+
+	if l := m.Level(); math.Abs(l.Peak-audio.DBFS(0.2)) > 0.1 {
+		t.Fatalf("Expected a scaled peak of %.1f dBFS, got %+v", audio.DBFS(0.2), l)
+	}
+
+	// Metering the sink sees the same level on the way out
+	sink, source = audio.Loopback(testRate)
+	out := audio.NewMeter(audio.MeterParams{Window: 50 * time.Millisecond}, testRate)
+	audio.MeterSink(sink, out).Write(pcm)
+	sink.Close()
+	readAll(t, source)
+	if l := out.Level(); math.Abs(l.Peak-audio.DBFS(0.2)) > 0.1 {
+		t.Fatalf("Expected a sink peak of %.1f dBFS, got %+v", audio.DBFS(0.2), l)
+	}
+}
@@ -0,0 +1,31 @@
+package audio
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// S16ToFloat32 converts S16_LE pcm to samples in -1..1, it returns the number of samples written to dst
+func S16ToFloat32(dst []float32, pcm []byte) int {
+	n := len(pcm) / 2
+	if n > len(dst) {
+		n = len(dst)
+	}
+	for i := 0; i < n; i++ {
+		dst[i] = float32(int16(binary.LittleEndian.Uint16(pcm[i*2:]))) / 32768
+	}
+	return n
+}
+
+// Float32ToS16 converts samples in -1..1 to S16_LE, clamping at full scale. It returns the number of bytes written to dst.
+func Float32ToS16(dst []byte, samples []float32) int {
+	n := len(dst) / 2
+	if n > len(samples) {
+		n = len(samples)
+	}
+	for i := 0; i < n; i++ {
+		x := math.Max(math.Min(math.Round(float64(samples[i])*32768), math.MaxInt16), math.MinInt16)
+		binary.LittleEndian.PutUint16(dst[i*2:], uint16(int16(x)))
+	}
+	return n * 2
+}
@@ -0,0 +1,301 @@
+package audio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/8ff/udarp/pkg/buffer"
+	"github.com/gen2brain/malgo"
+)
+
+/*
+Engine keeps one malgo context and device open for the life of the process.
+Capture is pushed into a ring buffer, dropping and counting what does not
+fit, playback is pulled from a queue of transmissions, so the audio callback
+never waits on anything but a short lock.
+
+Start and stop times are taken from the callback clock, the time a buffer is
+requested plus the position of the sample in it plus Latency, so they move
+with the sound card rather than with the goroutine that queued the audio.
+*/
+
+type EngineParams struct {
+	Capture    *malgo.DeviceInfo // nil for the default capture device
+	Playback   *malgo.DeviceInfo // nil for the default playback device
+	Mode       malgo.DeviceType  // Duplex when unset, Capture or Playback to open one direction only
+	SampleRate uint32
+	Latency    time.Duration   // Output latency of the sound card, added to reported times
+	Buffer     time.Duration   // Capture that can be held before the reader falls behind, 10 seconds by default
+	Backends   []malgo.Backend // Backends to try, empty for the platform default
+}
+
+type Engine struct {
+	params  EngineParams
+	ctx     *malgo.AllocatedContext
+	device  *malgo.Device
+	capture *buffer.Ring
+	input   []float32 // Conversion scratch of the callback
+	output  []float32 // Conversion scratch of Read
+
+	m       sync.Mutex
+	queue   []*Transmission
+	current *Transmission
+	closed  bool
+}
+
+// Transmission is a waveform queued on an Engine
+type Transmission struct {
+	At      time.Time // Requested start, zero to start as soon as the queue allows
+	wave    []byte
+	offset  int
+	start   time.Time
+	stop    time.Time
+	err     error
+	started chan struct{}
+	done    chan struct{}
+}
+
+// Started is closed when the first sample went to the sound card
+func (t *Transmission) Started() <-chan struct{} { return t.started }
+
+// Done is closed when the last sample went to the sound card or the engine was closed
+func (t *Transmission) Done() <-chan struct{} { return t.done }
+
+// Start is when the first sample is heard, valid once Started is closed
+func (t *Transmission) Start() time.Time {
+	<-t.started
+	return t.start
+}
+
+// Stop is when the last sample has been heard, valid once Done is closed
+func (t *Transmission) Stop() time.Time {
+	<-t.done
+	return t.stop
+}
+
+// Wait blocks until the transmission is done, it keeps playing if ctx is cancelled
+func (t *Transmission) Wait(ctx context.Context) error {
+	select {
+	case <-t.done:
+		return t.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// NewEngine opens and starts the device
+func NewEngine(params EngineParams) (*Engine, error) {
+	if params.Mode == 0 {
+		params.Mode = malgo.Duplex
+	}
+	if params.SampleRate == 0 {
+		params.SampleRate = 44100
+	}
+	if params.Buffer <= 0 {
+		params.Buffer = 10 * time.Second
+	}
+
+	ctx, err := malgo.InitContext(params.Backends, malgo.ContextConfig{}, func(message string) {})
+	if err != nil {
+		return nil, err
+	}
+	e := &Engine{params: params, ctx: ctx, capture: buffer.New(int(params.Buffer.Seconds() * float64(params.SampleRate)))}
+
+	deviceConfig := malgo.DefaultDeviceConfig(params.Mode)
+	if params.Capture != nil {
+		deviceConfig.Capture.DeviceID = params.Capture.ID.Pointer()
+	}
+	deviceConfig.Capture.Format = malgo.FormatS16
+	deviceConfig.Capture.Channels = 1
+	if params.Playback != nil {
+		deviceConfig.Playback.DeviceID = params.Playback.ID.Pointer()
+	}
+	deviceConfig.Playback.Format = malgo.FormatS16
+	deviceConfig.Playback.Channels = 1
+	deviceConfig.SampleRate = params.SampleRate
+	deviceConfig.Alsa.NoMMap = 1
+
+	e.device, err = malgo.InitDevice(ctx.Context, deviceConfig, malgo.DeviceCallbacks{Data: e.onData})
+	if err != nil {
+		e.freeContext()
+		return nil, fmt.Errorf("initializing audio device: %w", err)
+	}
+	if err := e.device.Start(); err != nil {
+		e.device.Uninit()
+		e.freeContext()
+		return nil, fmt.Errorf("starting audio device: %w", err)
+	}
+	return e, nil
+}
+
+// NewCaptureSource opens device for capture only, a nil device is the default capture device
+func NewCaptureSource(device *malgo.DeviceInfo, sampleRate uint32) (Source, error) {
+	return NewEngine(EngineParams{Capture: device, Mode: malgo.Capture, SampleRate: sampleRate})
+}
+
+// NewPlaybackSink opens device for playback only, a nil device is the default playback device
+func NewPlaybackSink(device *malgo.DeviceInfo, sampleRate uint32) (Sink, error) {
+	return NewEngine(EngineParams{Playback: device, Mode: malgo.Playback, SampleRate: sampleRate})
+}
+
+func (e *Engine) SampleRate() uint32 { return e.params.SampleRate }
+
+// Play queues wave, S16_LE mono, to start right after whatever is queued before it
+func (e *Engine) Play(wave []byte) (*Transmission, error) {
+	return e.PlayAt(time.Time{}, wave)
+}
+
+// PlayAt queues wave to start at at, or as soon after it as the transmissions queued before it allow.
+// Silence is played until then, which is how PTT delay should be taken into account.
+func (e *Engine) PlayAt(at time.Time, wave []byte) (*Transmission, error) {
+	t := &Transmission{
+		At:      at,
+		wave:    wave[:len(wave)&^1],
+		started: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	e.m.Lock()
+	defer e.m.Unlock()
+	if e.closed {
+		return nil, ErrClosed
+	}
+	e.queue = append(e.queue, t)
+	return t, nil
+}
+
+// Write plays p and returns once it has been played, making the Engine a Sink
+func (e *Engine) Write(p []byte) (int, error) {
+	t, err := e.Play(p)
+	if err != nil {
+		return 0, err
+	}
+	<-t.done
+	if t.err != nil {
+		return 0, t.err
+	}
+	return len(p), nil
+}
+
+// Read returns captured samples, making the Engine a Source. Only one goroutine may read.
+func (e *Engine) Read(p []byte) (int, error) {
+	if len(p) < 2 {
+		return 0, io.ErrShortBuffer
+	}
+	if cap(e.output) < len(p)/2 {
+		e.output = make([]float32, len(p)/2)
+	}
+	n, err := e.capture.ReadWait(context.Background(), e.output[:len(p)/2])
+	return Float32ToS16(p, e.output[:n]), err
+}
+
+// CaptureOverruns is the number of captured samples dropped because Read fell behind
+func (e *Engine) CaptureOverruns() uint64 {
+	return e.capture.Overruns()
+}
+
+// Close stops the device, queued transmissions fail with ErrClosed and Read returns io.EOF once drained
+func (e *Engine) Close() error {
+	e.m.Lock()
+	if e.closed {
+		e.m.Unlock()
+		return nil
+	}
+	e.closed = true
+	e.m.Unlock()
+
+	// Uninit waits for a running callback, so it has to happen without the lock
+	e.device.Uninit()
+	e.freeContext()
+
+	e.m.Lock()
+	pending := e.queue
+	if e.current != nil {
+		pending = append([]*Transmission{e.current}, pending...)
+	}
+	e.queue, e.current = nil, nil
+	e.m.Unlock()
+
+	for _, t := range pending {
+		t.err = ErrClosed
+		select {
+		case <-t.started:
+		default:
+			close(t.started)
+		}
+		close(t.done)
+	}
+	e.capture.Close()
+	return nil
+}
+
+func (e *Engine) freeContext() {
+	_ = e.ctx.Uninit()
+	e.ctx.Free()
+}
+
+func (e *Engine) onData(output, input []byte, _ uint32) {
+	now := time.Now()
+	if len(input) > 0 {
+		if cap(e.input) < len(input)/2 {
+			e.input = make([]float32, len(input)/2)
+		}
+		n := S16ToFloat32(e.input[:len(input)/2], input)
+		e.capture.Write(e.input[:n])
+	}
+	if len(output) > 0 {
+		e.fill(output, now)
+	}
+}
+
+// Time at which byte pos of a buffer requested at now is heard
+func (e *Engine) timeAt(now time.Time, pos int) time.Time {
+	return now.Add(e.params.Latency + time.Duration(pos/2)*time.Second/time.Duration(e.params.SampleRate))
+}
+
+// fill copies queued transmissions into output and silences the rest
+func (e *Engine) fill(output []byte, now time.Time) {
+	for i := range output {
+		output[i] = 0
+	}
+
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	pos := 0
+	for pos < len(output) {
+		t := e.current
+		if t == nil {
+			if len(e.queue) == 0 {
+				return
+			}
+			t = e.queue[0]
+			if !t.At.IsZero() {
+				// Bytes of silence until the requested start
+				wait := int(t.At.Sub(e.timeAt(now, 0)).Seconds()*float64(e.params.SampleRate)) * 2
+				if wait >= len(output) {
+					return
+				}
+				if wait > pos {
+					pos = wait
+				}
+			}
+			e.queue = e.queue[1:]
+			e.current = t
+			t.start = e.timeAt(now, pos)
+			close(t.started)
+		}
+
+		n := copy(output[pos:], t.wave[t.offset:])
+		t.offset += n
+		pos += n
+		if t.offset == len(t.wave) {
+			t.stop = e.timeAt(now, pos)
+			e.current = nil
+			close(t.done)
+		}
+	}
+}
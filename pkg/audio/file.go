@@ -0,0 +1,209 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+File backed sources and sinks, raw S16_LE mono PCM or WAV.
+Only 16 bit mono PCM WAV files are accepted, which is what every tool used
+with UDARP (sox, arecord, audacity exports) can be told to produce.
+*/
+
+const wavHeaderSize = 44
+
+// PCM fmt chunks are 16 to 40 bytes, anything much larger is not a file we can read
+const maxFormatSize = 64
+
+var ErrWAVFormat = errors.New("unsupported WAV file")
+
+// OpenFile opens path as a Source, "-" is stdin, a .wav suffix is read as WAV, anything else as raw PCM at sampleRate
+func OpenFile(path string, sampleRate uint32) (Source, error) {
+	switch {
+	case path == "-":
+		return Stdin(sampleRate), nil
+	case isWAV(path):
+		return OpenWAV(path)
+	default:
+		return OpenRaw(path, sampleRate)
+	}
+}
+
+// CreateFile creates path as a Sink, "-" is stdout, a .wav suffix is written as WAV, anything else as raw PCM
+func CreateFile(path string, sampleRate uint32) (Sink, error) {
+	switch {
+	case path == "-":
+		return Stdout(sampleRate), nil
+	case isWAV(path):
+		return CreateWAV(path, sampleRate)
+	default:
+		return CreateRaw(path, sampleRate)
+	}
+}
+
+func isWAV(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".wav")
+}
+
+// OpenRaw reads headerless S16_LE mono PCM from path
+func OpenRaw(path string, sampleRate uint32) (Source, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewReaderSource(f, sampleRate), nil
+}
+
+// CreateRaw writes headerless S16_LE mono PCM to path
+func CreateRaw(path string, sampleRate uint32) (Sink, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriterSink(f, sampleRate), nil
+}
+
+type wavSource struct {
+	io.Reader
+	file *os.File
+	rate uint32
+}
+
+func (s *wavSource) SampleRate() uint32 { return s.rate }
+func (s *wavSource) Close() error       { return s.file.Close() }
+
+// OpenWAV reads a 16 bit mono PCM WAV file, the sample rate comes from the file
+func OpenWAV(path string) (Source, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := readWAVHeader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	s.file = f
+	return s, nil
+}
+
+// readWAVHeader walks the RIFF chunks up to the data chunk
+func readWAVHeader(r io.Reader) (*wavSource, error) {
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrWAVFormat, err)
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("%w: not a RIFF/WAVE file", ErrWAVFormat)
+	}
+
+	var rate uint32
+	seenFormat := false
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return nil, fmt.Errorf("%w: no data chunk", ErrWAVFormat)
+		}
+		id := string(chunk[0:4])
+		size := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+		// Chunks are padded to an even size
+		padded := size + size%2
+
+		switch id {
+		case "fmt ":
+			if size < 16 || size > maxFormatSize {
+				return nil, fmt.Errorf("%w: fmt chunk of %d bytes", ErrWAVFormat, size)
+			}
+			format := make([]byte, padded)
+			if _, err := io.ReadFull(r, format); err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrWAVFormat, err)
+			}
+			audioFormat := binary.LittleEndian.Uint16(format[0:2])
+			channels := binary.LittleEndian.Uint16(format[2:4])
+			bits := binary.LittleEndian.Uint16(format[14:16])
+			if audioFormat != 1 || channels != 1 || bits != 16 {
+				return nil, fmt.Errorf("%w: format %d, %d channels, %d bits, want PCM, 1 channel, 16 bits", ErrWAVFormat, audioFormat, channels, bits)
+			}
+			rate = binary.LittleEndian.Uint32(format[4:8])
+			seenFormat = true
+		case "data":
+			if !seenFormat {
+				return nil, fmt.Errorf("%w: data before fmt chunk", ErrWAVFormat)
+			}
+			// Streaming writers leave the size at 0 or all ones, read to the end then
+			data := r
+			if size != 0 && size != 0xFFFFFFFF {
+				data = io.LimitReader(r, size)
+			}
+			return &wavSource{Reader: data, rate: rate}, nil
+		default:
+			if _, err := io.CopyN(io.Discard, r, padded); err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrWAVFormat, err)
+			}
+		}
+	}
+}
+
+type wavSink struct {
+	file    *os.File
+	rate    uint32
+	written int64
+}
+
+// CreateWAV writes a 16 bit mono PCM WAV file, the sizes in the header are filled in by Close
+func CreateWAV(path string, sampleRate uint32) (Sink, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &wavSink{file: f, rate: sampleRate}
+	if _, err := f.Write(s.header()); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *wavSink) SampleRate() uint32 { return s.rate }
+
+func (s *wavSink) Write(p []byte) (int, error) {
+	n, err := s.file.Write(p)
+	s.written += int64(n)
+	return n, err
+}
+
+func (s *wavSink) Close() error {
+	if s.written%2 != 0 {
+		// Keep the data chunk word aligned
+		s.file.Write([]byte{0})
+	}
+	_, err := s.file.WriteAt(s.header(), 0)
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (s *wavSink) header() []byte {
+	data := uint32(s.written)
+	h := make([]byte, 0, wavHeaderSize)
+	h = append(h, "RIFF"...)
+	h = binary.LittleEndian.AppendUint32(h, wavHeaderSize-8+data+data%2)
+	h = append(h, "WAVEfmt "...)
+	h = binary.LittleEndian.AppendUint32(h, 16)
+	h = binary.LittleEndian.AppendUint16(h, 1) // PCM
+	h = binary.LittleEndian.AppendUint16(h, 1) // Mono
+	h = binary.LittleEndian.AppendUint32(h, s.rate)
+	h = binary.LittleEndian.AppendUint32(h, s.rate*2) // Bytes per second
+	h = binary.LittleEndian.AppendUint16(h, 2)        // Bytes per frame
+	h = binary.LittleEndian.AppendUint16(h, 16)
+	h = append(h, "data"...)
+	h = binary.LittleEndian.AppendUint32(h, data)
+	return h
+}
@@ -0,0 +1,172 @@
+package audio
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+	"time"
+)
+
+/*
+Level metering of S16_LE audio. A Meter is fed as an io.Writer and publishes
+RMS and peak, in dBFS, once per window so the numbers are stable enough to
+read and cheap enough to compute from an audio path.
+*/
+
+// Silence, the floor reported instead of -Inf so levels survive JSON
+const SilenceDBFS = -120.0
+
+type MeterParams struct {
+	Window    time.Duration // Averaging window, one second by default
+	ClipDBFS  float64       // Peaks at or above this are counted as clipped, -0.1 dBFS by default
+	QuietDBFS float64       // RMS below this is too quiet to decode, -50 dBFS by default
+}
+
+// Level is the state of a Meter at the end of its last window
+type Level struct {
+	RMS      float64   `json:"rms_dbfs"`
+	Peak     float64   `json:"peak_dbfs"`
+	Clipped  uint64    `json:"clipped"`   // Clipped samples since the meter was created
+	Clipping bool      `json:"clipping"`  // The last window had clipped samples
+	TooQuiet bool      `json:"too_quiet"` // The last window was below QuietDBFS
+	Updated  time.Time `json:"updated"`   // End of the last window, zero before the first one
+}
+
+type Meter struct {
+	params        MeterParams
+	windowSamples int
+	clip          float64 // Linear ClipDBFS
+
+	m       sync.Mutex
+	odd     []byte // Half a sample left over from the last Write
+	samples int
+	sumSq   float64
+	peak    float64
+	clipped uint64
+	window  uint64 // Clipped samples in the current window
+	level   Level
+}
+
+func NewMeter(params MeterParams, sampleRate uint32) *Meter {
+	if params.Window <= 0 {
+		params.Window = time.Second
+	}
+	if params.ClipDBFS == 0 {
+		params.ClipDBFS = -0.1
+	}
+	if params.QuietDBFS == 0 {
+		params.QuietDBFS = -50
+	}
+	windowSamples := int(params.Window.Seconds() * float64(sampleRate))
+	if windowSamples < 1 {
+		windowSamples = 1
+	}
+	return &Meter{
+		params:        params,
+		windowSamples: windowSamples,
+		clip:          math.Pow(10, params.ClipDBFS/20),
+		level:         Level{RMS: SilenceDBFS, Peak: SilenceDBFS},
+	}
+}
+
+// Write meters pcm, it never fails and never blocks for long
+func (m *Meter) Write(pcm []byte) (int, error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	n := len(pcm)
+	if len(m.odd) == 1 && len(pcm) > 0 {
+		m.add(append(m.odd, pcm[0]))
+		m.odd = m.odd[:0]
+		pcm = pcm[1:]
+	}
+	for ; len(pcm) >= 2; pcm = pcm[2:] {
+		m.add(pcm)
+	}
+	if len(pcm) == 1 {
+		m.odd = append(m.odd[:0], pcm[0])
+	}
+	return n, nil
+}
+
+func (m *Meter) add(sample []byte) {
+	x := math.Abs(float64(int16(binary.LittleEndian.Uint16(sample))) / 32768)
+	m.sumSq += x * x
+	if x > m.peak {
+		m.peak = x
+	}
+	if x >= m.clip {
+		m.clipped++
+		m.window++
+	}
+	m.samples++
+
+	if m.samples == m.windowSamples {
+		rms := DBFS(math.Sqrt(m.sumSq / float64(m.samples)))
+		m.level = Level{
+			RMS:      rms,
+			Peak:     DBFS(m.peak),
+			Clipped:  m.clipped,
+			Clipping: m.window > 0,
+			TooQuiet: rms < m.params.QuietDBFS,
+			Updated:  time.Now(),
+		}
+		m.samples, m.sumSq, m.peak, m.window = 0, 0, 0, 0
+	}
+}
+
+// Level returns the level of the last complete window
+func (m *Meter) Level() Level {
+	m.m.Lock()
+	defer m.m.Unlock()
+	return m.level
+}
+
+// DBFS converts a linear amplitude, 1 being full scale, to dBFS
+func DBFS(amplitude float64) float64 {
+	if amplitude <= 0 {
+		return SilenceDBFS
+	}
+	return math.Max(20*math.Log10(amplitude), SilenceDBFS)
+}
+
+// Scale multiplies S16_LE pcm by gain in place, clamping at full scale
+func Scale(pcm []byte, gain float64) {
+	for i := 0; i+1 < len(pcm); i += 2 {
+		x := float64(int16(binary.LittleEndian.Uint16(pcm[i:]))) * gain
+		x = math.Max(math.Min(math.Round(x), math.MaxInt16), math.MinInt16)
+		binary.LittleEndian.PutUint16(pcm[i:], uint16(int16(x)))
+	}
+}
+
+type meteredSource struct {
+	Source
+	meter *Meter
+}
+
+func (s meteredSource) Read(p []byte) (int, error) {
+	n, err := s.Source.Read(p)
+	s.meter.Write(p[:n])
+	return n, err
+}
+
+// MeterSource feeds everything read from source to meter
+func MeterSource(source Source, meter *Meter) Source {
+	return meteredSource{Source: source, meter: meter}
+}
+
+type meteredSink struct {
+	Sink
+	meter *Meter
+}
+
+func (s meteredSink) Write(p []byte) (int, error) {
+	n, err := s.Sink.Write(p)
+	s.meter.Write(p[:n])
+	return n, err
+}
+
+// MeterSink feeds everything written to sink to meter
+func MeterSink(sink Sink, meter *Meter) Sink {
+	return meteredSink{Sink: sink, meter: meter}
+}
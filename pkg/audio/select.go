@@ -0,0 +1,228 @@
+package audio
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/8ff/udarp/pkg/misc"
+	"github.com/gen2brain/malgo"
+)
+
+/*
+Device selection for config files and the command line.
+
+A selector is tried as, in order:
+	default           the device the system marks as default, else the first one
+	hash or ID        the md5 shown by -l, or the backend ID itself
+	exact name        case sensitive
+	re:EXPR or /EXPR/ regular expression on the name
+	anything else     case insensitive substring of the name
+The first rule that matches anything decides, more than one match is an error
+listing the candidates rather than a guess.
+*/
+
+var (
+	ErrNoDevice  = errors.New("no matching audio device")
+	ErrAmbiguous = errors.New("audio device selector is ambiguous")
+)
+
+// Device describes a playback or capture device, JSON is what --list --json prints
+type Device struct {
+	Name        string   `json:"name"`
+	ID          string   `json:"id"`
+	Hash        string   `json:"hash"`
+	Type        string   `json:"type"` // playback or capture
+	Default     bool     `json:"default"`
+	SampleRates []uint32 `json:"sample_rates"` // Native rates, empty when the device converts any rate
+	Channels    []uint32 `json:"channels"`     // Native channel counts, empty when any count works
+	info        malgo.DeviceInfo
+}
+
+// Info returns what malgo needs to open the device
+func (d Device) Info() *malgo.DeviceInfo {
+	info := d.info
+	return &info
+}
+
+func (d Device) String() string {
+	return fmt.Sprintf("%q (%s)", d.Name, d.Hash)
+}
+
+func kindName(kind malgo.DeviceType) string {
+	if kind == malgo.Capture {
+		return "capture"
+	}
+	return "playback"
+}
+
+// ListDevices returns every playback and capture device with its native formats
+func ListDevices() (playback, capture []Device, err error) {
+	ctx, err := malgo.InitContext(nil, malgo.ContextConfig{}, func(message string) {})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		_ = ctx.Uninit()
+		ctx.Free()
+	}()
+
+	list := func(kind malgo.DeviceType) ([]Device, error) {
+		infos, err := ctx.Devices(kind)
+		if err != nil {
+			return nil, err
+		}
+		devices := make([]Device, 0, len(infos))
+		for _, info := range infos {
+			// Enumeration leaves the formats out on most backends, ask for them
+			if full, err := ctx.DeviceInfo(kind, info.ID, malgo.Shared); err == nil {
+				full.IsDefault = info.IsDefault
+				info = full
+			}
+			devices = append(devices, newDevice(kind, info))
+		}
+		return devices, nil
+	}
+
+	if playback, err = list(malgo.Playback); err != nil {
+		return nil, nil, err
+	}
+	if capture, err = list(malgo.Capture); err != nil {
+		return nil, nil, err
+	}
+	return playback, capture, nil
+}
+
+func newDevice(kind malgo.DeviceType, info malgo.DeviceInfo) Device {
+	d := Device{
+		Name:        info.Name(),
+		ID:          info.ID.String(),
+		Hash:        misc.Md5HashString(info.ID.String()),
+		Type:        kindName(kind),
+		Default:     info.IsDefault != 0,
+		SampleRates: []uint32{},
+		Channels:    []uint32{},
+		info:        info,
+	}
+
+	rates := make(map[uint32]bool)
+	channels := make(map[uint32]bool)
+	for _, format := range info.Formats {
+		// Zero means the device takes anything
+		if format.SampleRate != 0 && !rates[format.SampleRate] {
+			rates[format.SampleRate] = true
+			d.SampleRates = append(d.SampleRates, format.SampleRate)
+		}
+		if format.Channels != 0 && !channels[format.Channels] {
+			channels[format.Channels] = true
+			d.Channels = append(d.Channels, format.Channels)
+		}
+	}
+	sort.Slice(d.SampleRates, func(i, j int) bool { return d.SampleRates[i] < d.SampleRates[j] })
+	sort.Slice(d.Channels, func(i, j int) bool { return d.Channels[i] < d.Channels[j] })
+	return d
+}
+
+// Select picks one of devices by selector, see the rules at the top of this file
+func Select(devices []Device, selector string) (Device, error) {
+	if len(devices) == 0 {
+		return Device{}, fmt.Errorf("%w: no devices found", ErrNoDevice)
+	}
+
+	if selector == "default" {
+		for _, d := range devices {
+			if d.Default {
+				return d, nil
+			}
+		}
+		return devices[0], nil
+	}
+
+	rules := []func(Device) bool{
+		func(d Device) bool { return d.Hash == selector || d.ID == selector },
+		func(d Device) bool { return d.Name == selector },
+	}
+
+	if expr, ok := regexpSelector(selector); ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return Device{}, fmt.Errorf("device selector %q: %w", selector, err)
+		}
+		rules = append(rules, func(d Device) bool { return re.MatchString(d.Name) })
+	} else {
+		lower := strings.ToLower(selector)
+		rules = append(rules, func(d Device) bool { return strings.Contains(strings.ToLower(d.Name), lower) })
+	}
+
+	for _, rule := range rules {
+		var matches []Device
+		for _, d := range devices {
+			if rule(d) {
+				matches = append(matches, d)
+			}
+		}
+		switch len(matches) {
+		case 0:
+			continue
+		case 1:
+			return matches[0], nil
+		default:
+			return Device{}, fmt.Errorf("%w: %q matches %s", ErrAmbiguous, selector, candidates(matches))
+		}
+	}
+	return Device{}, fmt.Errorf("%w: %q, available are %s", ErrNoDevice, selector, candidates(devices))
+}
+
+func regexpSelector(selector string) (string, bool) {
+	if strings.HasPrefix(selector, "re:") {
+		return strings.TrimPrefix(selector, "re:"), true
+	}
+	if len(selector) > 2 && strings.HasPrefix(selector, "/") && strings.HasSuffix(selector, "/") {
+		return selector[1 : len(selector)-1], true
+	}
+	return "", false
+}
+
+func candidates(devices []Device) string {
+	names := make([]string, len(devices))
+	for i, d := range devices {
+		names[i] = d.String()
+	}
+	return strings.Join(names, ", ")
+}
+
+// SelectDevice looks up a playback or capture device by selector
+func SelectDevice(kind malgo.DeviceType, selector string) (*malgo.DeviceInfo, error) {
+	playback, capture, err := ListDevices()
+	if err != nil {
+		return nil, err
+	}
+	devices := playback
+	if kind == malgo.Capture {
+		devices = capture
+	}
+
+	d, err := Select(devices, selector)
+	if err != nil {
+		return nil, fmt.Errorf("%s device: %w", kindName(kind), err)
+	}
+	return d.Info(), nil
+}
+
+// PrintDevicesJSON writes all devices as {"playback": [...], "capture": [...]}
+func PrintDevicesJSON(w io.Writer) error {
+	playback, capture, err := ListDevices()
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(struct {
+		Playback []Device `json:"playback"`
+		Capture  []Device `json:"capture"`
+	}{playback, capture})
+}
@@ -0,0 +1,148 @@
+package audio
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+/*
+Sources and sinks move S16_LE mono PCM, the format the decoder and
+fskGenerator work in. They hide where the audio comes from or goes to,
+a sound card, a file, stdin/stdout or another part of the same process.
+*/
+
+var ErrClosed = errors.New("audio stream is closed")
+
+// Source delivers captured PCM, Read blocks until samples are available and returns io.EOF once the source is exhausted
+type Source interface {
+	io.ReadCloser
+	SampleRate() uint32
+}
+
+// Sink plays PCM, Write returns once the samples have been handed to the output
+type Sink interface {
+	io.WriteCloser
+	SampleRate() uint32
+}
+
+// pipe is an unbounded byte queue, writes never block so it is safe to feed from an audio callback
+type pipe struct {
+	m      sync.Mutex
+	cond   sync.Cond
+	buf    bytes.Buffer
+	closed bool
+}
+
+func newPipe() *pipe {
+	p := &pipe{}
+	p.cond.L = &p.m
+	return p
+}
+
+func (p *pipe) Write(b []byte) (int, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	if p.closed {
+		return 0, ErrClosed
+	}
+	n, _ := p.buf.Write(b)
+	p.cond.Broadcast()
+	return n, nil
+}
+
+func (p *pipe) Read(b []byte) (int, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	for p.buf.Len() == 0 {
+		if p.closed {
+			return 0, io.EOF
+		}
+		p.cond.Wait()
+	}
+	return p.buf.Read(b)
+}
+
+// Close lets readers drain what is queued, then they get io.EOF
+func (p *pipe) Close() error {
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.closed = true
+	p.cond.Broadcast()
+	return nil
+}
+
+type pipeSource struct {
+	*pipe
+	rate uint32
+}
+
+func (s pipeSource) SampleRate() uint32 { return s.rate }
+
+type pipeSink struct {
+	*pipe
+	rate uint32
+}
+
+func (s pipeSink) SampleRate() uint32 { return s.rate }
+
+// Loopback returns a connected pair, everything written to the sink can be read from the source.
+// Closing either end ends the stream, the source still returns what was written before.
+func Loopback(sampleRate uint32) (Sink, Source) {
+	p := newPipe()
+	return pipeSink{pipe: p, rate: sampleRate}, pipeSource{pipe: p, rate: sampleRate}
+}
+
+type readerSource struct {
+	io.Reader
+	closer io.Closer
+	rate   uint32
+}
+
+func (s readerSource) SampleRate() uint32 { return s.rate }
+
+func (s readerSource) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+// NewReaderSource reads raw S16_LE mono PCM from r, Close closes r if it is an io.Closer
+func NewReaderSource(r io.Reader, sampleRate uint32) Source {
+	closer, _ := r.(io.Closer)
+	return readerSource{Reader: r, closer: closer, rate: sampleRate}
+}
+
+type writerSink struct {
+	io.Writer
+	closer io.Closer
+	rate   uint32
+}
+
+func (s writerSink) SampleRate() uint32 { return s.rate }
+
+func (s writerSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+// NewWriterSink writes raw S16_LE mono PCM to w, Close closes w if it is an io.Closer
+func NewWriterSink(w io.Writer, sampleRate uint32) Sink {
+	closer, _ := w.(io.Closer)
+	return writerSink{Writer: w, closer: closer, rate: sampleRate}
+}
+
+// Stdin reads raw PCM from standard input, closing it leaves os.Stdin open
+func Stdin(sampleRate uint32) Source {
+	return readerSource{Reader: os.Stdin, rate: sampleRate}
+}
+
+// Stdout writes raw PCM to standard output, closing it leaves os.Stdout open
+func Stdout(sampleRate uint32) Sink {
+	return writerSink{Writer: os.Stdout, rate: sampleRate}
+}
@@ -0,0 +1,262 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/8ff/udarp/pkg/frame"
+)
+
+/*
+Authentication of frames with pre-shared keys.
+
+Encryption is not allowed on the amateur bands so payloads stay readable, we only append a
+truncated HMAC-SHA256 tag that proves the frame came from someone holding the key. Authenticated
+frames have frame.FlagAuth set and end with a trailer:
+	0..3   Unix time the frame was signed
+	4..5   Random nonce
+	6..    Tag, TagBits long, unused bits of the last byte are zero
+	last   TagBits
+The tag covers the header fields that don't change on the way (the route is left out so relays can
+forward the frame), the payload and the first 6 bytes of the trailer.
+
+Keys are per peer callsign, a frame is signed with the key of its destination and verified with the
+key of its source, both ends store the shared key under the other's callsign. Broadcast frames use
+the key stored under frame.Broadcast, a net key shared by a group of stations.
+
+Replay protection: the timestamp must be within MaxSkew of our clock and a timestamp/nonce pair
+is only accepted once.
+*/
+
+const (
+	MinTagBits = 16
+	MaxTagBits = 128
+
+	trailerFixed = 7
+)
+
+var (
+	ErrUnauthenticated = errors.New("frame is not authenticated")
+	ErrNoKey           = errors.New("no key for peer")
+	ErrBadTag          = errors.New("authentication tag mismatch")
+	ErrReplay          = errors.New("frame was replayed")
+	ErrStale           = errors.New("frame timestamp out of range")
+)
+
+type Params struct {
+	TagBits int           // Tag length in bits, MinTagBits to MaxTagBits, default 64
+	MaxSkew time.Duration // Accepted clock difference, default 5 minutes
+	// Frame types that must be authenticated, default frame.TypeControl
+	Types []frame.Type
+	// Returns the current time, default time.Now
+	Clock func() time.Time
+}
+
+type Authenticator struct {
+	params   Params
+	required map[frame.Type]bool
+
+	m    sync.Mutex
+	keys map[string][]byte
+	seen map[string]time.Time // source/timestamp/nonce
+}
+
+func New(params Params) (*Authenticator, error) {
+	if params.TagBits == 0 {
+		params.TagBits = 64
+	}
+	if params.TagBits < MinTagBits || params.TagBits > MaxTagBits {
+		return nil, fmt.Errorf("tag bits must be between %d and %d", MinTagBits, MaxTagBits)
+	}
+	if params.MaxSkew == 0 {
+		params.MaxSkew = 5 * time.Minute
+	}
+	if params.Types == nil {
+		params.Types = []frame.Type{frame.TypeControl}
+	}
+	if params.Clock == nil {
+		params.Clock = time.Now
+	}
+
+	a := &Authenticator{
+		params:   params,
+		required: make(map[frame.Type]bool),
+		keys:     make(map[string][]byte),
+		seen:     make(map[string]time.Time),
+	}
+	for _, t := range params.Types {
+		a.required[t] = true
+	}
+	return a, nil
+}
+
+// SetKey stores the key shared with peer
+func (a *Authenticator) SetKey(peer string, key []byte) error {
+	if len(key) < 16 {
+		return fmt.Errorf("key must be at least 16 bytes")
+	}
+	a.m.Lock()
+	defer a.m.Unlock()
+	a.keys[strings.ToUpper(peer)] = append([]byte{}, key...)
+	return nil
+}
+
+// RemoveKey forgets the key shared with peer
+func (a *Authenticator) RemoveKey(peer string) {
+	a.m.Lock()
+	defer a.m.Unlock()
+	delete(a.keys, strings.ToUpper(peer))
+}
+
+// Required reports whether frames of type t must be authenticated
+func (a *Authenticator) Required(t frame.Type) bool {
+	return a.required[t]
+}
+
+func (a *Authenticator) key(peer string) ([]byte, error) {
+	a.m.Lock()
+	defer a.m.Unlock()
+	key, ok := a.keys[strings.ToUpper(peer)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNoKey, peer)
+	}
+	return key, nil
+}
+
+// Sign appends an authentication trailer to f
+func (a *Authenticator) Sign(f *frame.Frame) error {
+	if f.Flags&frame.FlagAuth != 0 {
+		return fmt.Errorf("frame is already signed")
+	}
+	key, err := a.key(f.Destination)
+	if err != nil {
+		return err
+	}
+
+	tagBytes := (a.params.TagBits + 7) / 8
+	if len(f.Payload)+trailerFixed+tagBytes > frame.MaxPayload {
+		return fmt.Errorf("%w: no room for the trailer", frame.ErrTooLarge)
+	}
+
+	nonce := make([]byte, 2)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	signed := *f
+	signed.Flags |= frame.FlagAuth
+	stamp := binary.BigEndian.AppendUint32(nil, uint32(a.params.Clock().Unix()))
+	stamp = append(stamp, nonce...)
+
+	tag, err := computeTag(key, signed.Header, f.Payload, stamp, a.params.TagBits)
+	if err != nil {
+		return err
+	}
+
+	payload := make([]byte, 0, len(f.Payload)+trailerFixed+tagBytes)
+	payload = append(payload, f.Payload...)
+	payload = append(payload, stamp...)
+	payload = append(payload, tag...)
+	signed.Payload = append(payload, byte(a.params.TagBits))
+
+	*f = signed
+	return nil
+}
+
+// Verify checks the trailer of f and returns the frame without it.
+// Frames of a required type without a trailer are rejected, others pass through unchanged.
+func (a *Authenticator) Verify(f frame.Frame) (frame.Frame, error) {
+	if f.Flags&frame.FlagAuth == 0 {
+		if a.required[f.Type] {
+			return frame.Frame{}, fmt.Errorf("%w: %s frame from %s", ErrUnauthenticated, f.Type, f.Source)
+		}
+		return f, nil
+	}
+
+	n := len(f.Payload)
+	if n < trailerFixed+2 {
+		return frame.Frame{}, fmt.Errorf("%w: trailer too short", ErrBadTag)
+	}
+	tagBits := int(f.Payload[n-1])
+	tagBytes := (tagBits + 7) / 8
+	// Never accept tags shorter than we would send ourselves
+	if tagBits < a.params.TagBits || tagBits > MaxTagBits || n < trailerFixed+tagBytes {
+		return frame.Frame{}, fmt.Errorf("%w: %d bit tag", ErrBadTag, tagBits)
+	}
+
+	peer := f.Source
+	if f.Destination == frame.Broadcast {
+		peer = frame.Broadcast
+	}
+	key, err := a.key(peer)
+	if err != nil {
+		return frame.Frame{}, err
+	}
+
+	data := f.Payload[:n-trailerFixed-tagBytes]
+	stamp := f.Payload[len(data) : len(data)+trailerFixed-1]
+	tag := f.Payload[n-1-tagBytes : n-1]
+
+	expected, err := computeTag(key, f.Header, data, stamp, tagBits)
+	if err != nil {
+		return frame.Frame{}, err
+	}
+	if !hmac.Equal(tag, expected) {
+		return frame.Frame{}, fmt.Errorf("%w: from %s", ErrBadTag, f.Source)
+	}
+
+	now := a.params.Clock()
+	signedAt := time.Unix(int64(binary.BigEndian.Uint32(stamp)), 0)
+	if d := now.Sub(signedAt); d > a.params.MaxSkew || d < -a.params.MaxSkew {
+		return frame.Frame{}, fmt.Errorf("%w: signed at %s", ErrStale, signedAt.UTC().Format(time.RFC3339))
+	}
+
+	a.m.Lock()
+	for k, t := range a.seen {
+		if now.Sub(t) > 2*a.params.MaxSkew {
+			delete(a.seen, k)
+		}
+	}
+	seenKey := fmt.Sprintf("%s/%x", strings.ToUpper(f.Source), stamp)
+	_, replayed := a.seen[seenKey]
+	if !replayed {
+		a.seen[seenKey] = now
+	}
+	a.m.Unlock()
+	if replayed {
+		return frame.Frame{}, fmt.Errorf("%w: from %s", ErrReplay, f.Source)
+	}
+
+	verified := f
+	verified.Flags &^= frame.FlagAuth
+	verified.Payload = append([]byte{}, data...)
+	return verified, nil
+}
+
+// computeTag returns the truncated tag over the fixed header fields, payload and stamp
+func computeTag(key []byte, h frame.Header, payload, stamp []byte, bits int) ([]byte, error) {
+	h.Route = nil
+	h.Flags &^= frame.FlagRouted
+	h.Length = uint16(len(payload))
+	header, err := h.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(header)
+	mac.Write(payload)
+	mac.Write(stamp)
+	tag := mac.Sum(nil)[:(bits+7)/8]
+	if rem := bits % 8; rem != 0 {
+		tag[len(tag)-1] &= 0xFF << (8 - rem)
+	}
+	return tag, nil
+}
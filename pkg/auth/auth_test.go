@@ -0,0 +1,169 @@
+package auth_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/8ff/udarp/pkg/auth"
+	"github.com/8ff/udarp/pkg/frame"
+)
+
+var key = []byte("0123456789abcdef0123456789abcdef")
+
+// pair returns authenticators for K1ABC and KA1XYZ sharing a key
+func pair(t *testing.T, params auth.Params) (*auth.Authenticator, *auth.Authenticator) {
+	a, err := auth.New(params)
+	if err != nil {
+		t.Fatalf("New failed with error: %v", err)
+	}
+	b, err := auth.New(params)
+	if err != nil {
+		t.Fatalf("New failed with error: %v", err)
+	}
+	if err := a.SetKey("KA1XYZ", key); err != nil {
+		t.Fatalf("SetKey failed with error: %v", err)
+	}
+	if err := b.SetKey("k1abc", key); err != nil {
+		t.Fatalf("SetKey failed with error: %v", err)
+	}
+	return a, b
+}
+
+// overAir marshals and unmarshals f, optionally corrupting the bytes in between
+func overAir(t *testing.T, f frame.Frame, corrupt func([]byte)) frame.Frame {
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed with error: %v", err)
+	}
+	if corrupt != nil {
+		corrupt(data)
+	}
+	var received frame.Frame
+	if err := received.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary failed with error: %v", err)
+	}
+	return received
+}
+
+func TestSignVerify(t *testing.T) {
+	for _, bits := range []int{auth.MinTagBits, 20, 64, auth.MaxTagBits} {
+		a, b := pair(t, auth.Params{TagBits: bits})
+
+		f := frame.New(frame.TypeControl, "K1ABC", "KA1XYZ", 9, []byte("status"))
+		if err := a.Sign(&f); err != nil {
+			t.Fatalf("Sign failed with error: %v", err)
+		}
+		if f.Flags&frame.FlagAuth == 0 || len(f.Payload) != 6+7+(bits+7)/8 {
+			t.Fatalf("%d bits: unexpected signed frame %+v", bits, f)
+		}
+
+		verified, err := b.Verify(overAir(t, f, nil))
+		if err != nil {
+			t.Fatalf("%d bits: Verify failed with error: %v", bits, err)
+		}
+		if !bytes.Equal(verified.Payload, []byte("status")) || verified.Flags&frame.FlagAuth != 0 {
+			t.Fatalf("%d bits: trailer was not stripped: %+v", bits, verified)
+		}
+
+		// Flip a payload bit
+		tampered := overAir(t, f, func(d []byte) { d[frame.HeaderSize] ^= 1 })
+		if _, err := b.Verify(tampered); !errors.Is(err, auth.ErrBadTag) {
+			t.Fatalf("%d bits: expected ErrBadTag for tampered payload, got %v", bits, err)
+		}
+		// Change the sequence
+		tampered = overAir(t, f, func(d []byte) { d[11] ^= 1 })
+		if _, err := b.Verify(tampered); !errors.Is(err, auth.ErrBadTag) {
+			t.Fatalf("%d bits: expected ErrBadTag for tampered header, got %v", bits, err)
+		}
+	}
+}
+
+func TestRelayedFrameVerifies(t *testing.T) {
+	a, b := pair(t, auth.Params{})
+	f := frame.New(frame.TypeControl, "K1ABC", "KA1XYZ", 1, []byte("status"))
+	f.Route = frame.NewRoute(1, "W1AW")
+	if err := a.Sign(&f); err != nil {
+		t.Fatalf("Sign failed with error: %v", err)
+	}
+	f.Route = f.Route.Forwarded()
+	if _, err := b.Verify(overAir(t, f, nil)); err != nil {
+		t.Fatalf("Relaying should not break the tag: %v", err)
+	}
+}
+
+func TestRejectsUnauthenticated(t *testing.T) {
+	_, b := pair(t, auth.Params{})
+
+	if _, err := b.Verify(frame.New(frame.TypeControl, "K1ABC", "KA1XYZ", 1, []byte("status"))); !errors.Is(err, auth.ErrUnauthenticated) {
+		t.Fatalf("Expected ErrUnauthenticated for unsigned control frame, got %v", err)
+	}
+	if _, err := b.Verify(frame.New(frame.TypeText, "K1ABC", "KA1XYZ", 1, []byte("hi"))); err != nil {
+		t.Fatalf("Unsigned text frames should pass, got %v", err)
+	}
+
+	// Signed by someone without our key
+	stranger, _ := auth.New(auth.Params{})
+	stranger.SetKey("KA1XYZ", []byte("not the right key at all"))
+	f := frame.New(frame.TypeControl, "K1ABC", "KA1XYZ", 1, []byte("status"))
+	stranger.Sign(&f)
+	if _, err := b.Verify(f); !errors.Is(err, auth.ErrBadTag) {
+		t.Fatalf("Expected ErrBadTag for wrong key, got %v", err)
+	}
+
+	f = frame.New(frame.TypeControl, "W9XYZ", "KA1XYZ", 1, []byte("status"))
+	stranger.SetKey("KA1XYZ", key)
+	stranger.Sign(&f)
+	if _, err := b.Verify(f); !errors.Is(err, auth.ErrNoKey) {
+		t.Fatalf("Expected ErrNoKey for unknown peer, got %v", err)
+	}
+
+	// Shorter tags than we use are downgrades
+	short, _ := auth.New(auth.Params{TagBits: 16})
+	short.SetKey("KA1XYZ", key)
+	f = frame.New(frame.TypeControl, "K1ABC", "KA1XYZ", 1, []byte("status"))
+	short.Sign(&f)
+	if _, err := b.Verify(f); !errors.Is(err, auth.ErrBadTag) {
+		t.Fatalf("Expected ErrBadTag for a shorter tag, got %v", err)
+	}
+}
+
+func TestReplayProtection(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	clock := func() time.Time { return now }
+	a, b := pair(t, auth.Params{Clock: clock})
+
+	f := frame.New(frame.TypeControl, "K1ABC", "KA1XYZ", 1, []byte("beacon stop"))
+	if err := a.Sign(&f); err != nil {
+		t.Fatalf("Sign failed with error: %v", err)
+	}
+	if _, err := b.Verify(f); err != nil {
+		t.Fatalf("Verify failed with error: %v", err)
+	}
+	if _, err := b.Verify(f); !errors.Is(err, auth.ErrReplay) {
+		t.Fatalf("Expected ErrReplay for the same frame twice, got %v", err)
+	}
+
+	f = frame.New(frame.TypeControl, "K1ABC", "KA1XYZ", 2, []byte("beacon stop"))
+	a.Sign(&f)
+	now = now.Add(10 * time.Minute)
+	if _, err := b.Verify(f); !errors.Is(err, auth.ErrStale) {
+		t.Fatalf("Expected ErrStale for an old frame, got %v", err)
+	}
+}
+
+func TestBroadcastNetKey(t *testing.T) {
+	a, _ := auth.New(auth.Params{})
+	b, _ := auth.New(auth.Params{})
+	a.SetKey(frame.Broadcast, key)
+	b.SetKey(frame.Broadcast, key)
+
+	f := frame.New(frame.TypeControl, "K1ABC", frame.Broadcast, 1, []byte("net"))
+	if err := a.Sign(&f); err != nil {
+		t.Fatalf("Sign failed with error: %v", err)
+	}
+	if _, err := b.Verify(f); err != nil {
+		t.Fatalf("Verify failed with error: %v", err)
+	}
+}
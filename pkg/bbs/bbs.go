@@ -0,0 +1,158 @@
+package bbs
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/8ff/udarp/pkg/frame"
+	"github.com/8ff/udarp/pkg/misc"
+)
+
+/*
+Store and forward bulletin board.
+
+Stations send TypeBBS frames addressed to the BBS callsign, the BBS answers every request with a
+response frame carrying the same sequence number. Everyone can list, read and post bulletins,
+mail can only be listed, read and deleted by the station it is addressed to. Bulletins can only be
+deleted by the station that posted them.
+*/
+
+type BBS struct {
+	callsign string
+	store    *Store
+}
+
+// New returns a BBS answering requests addressed to callsign
+func New(callsign string, store *Store) (*BBS, error) {
+	if store == nil {
+		return nil, fmt.Errorf("store is required")
+	}
+	callsign = strings.ToUpper(callsign)
+	if err := (frame.Header{Version: frame.Version, Type: frame.TypeBBS, Source: callsign, Destination: frame.Broadcast}).Validate(); err != nil {
+		return nil, fmt.Errorf("invalid bbs callsign: %w", err)
+	}
+	return &BBS{callsign: callsign, store: store}, nil
+}
+
+// Callsign returns the callsign the BBS answers to
+func (b *BBS) Callsign() string {
+	return b.callsign
+}
+
+// Handle processes a request frame and returns the response to transmit.
+// Frames that are not BBS requests for this station return false.
+func (b *BBS) Handle(f frame.Frame) (frame.Frame, bool) {
+	if f.Type != frame.TypeBBS || !strings.EqualFold(f.Destination, b.callsign) {
+		return frame.Frame{}, false
+	}
+
+	var req Request
+	if err := req.UnmarshalBinary(f.Payload); err != nil {
+		misc.Log("warning", fmt.Sprintf("Bad request from %s: %v", f.Source, err))
+		// Responses have the top bit set, never answer them or two BBSs could loop forever
+		if len(f.Payload) > 0 && f.Payload[0]&opResponse != 0 {
+			return frame.Frame{}, false
+		}
+		op := Op(0)
+		if len(f.Payload) > 0 {
+			op = Op(f.Payload[0])
+		}
+		return b.respond(f, Response{Op: op, Err: "malformed request"})
+	}
+
+	resp := b.process(strings.ToUpper(f.Source), req)
+	if resp.Err != "" {
+		misc.Log("info", fmt.Sprintf("Request %d from %s failed: %s", req.Op, f.Source, resp.Err))
+	}
+	return b.respond(f, resp)
+}
+
+func (b *BBS) process(source string, req Request) Response {
+	resp := Response{Op: req.Op}
+
+	switch req.Op {
+	case OpList:
+		messages, err := b.store.List(req.Area, source)
+		if err != nil {
+			resp.Err = err.Error()
+		}
+		resp.Messages, resp.More = page(messages, req.After)
+	case OpRead:
+		msg, err := b.store.Get(req.Area, source, req.ID)
+		if err != nil {
+			resp.Err = err.Error()
+			break
+		}
+		resp.Messages = []Message{msg}
+	case OpSend:
+		msg, err := b.store.Add(Message{Area: req.Area, From: source, To: req.To, Subject: req.Subject, Body: req.Body})
+		if err != nil {
+			resp.Err = err.Error()
+			break
+		}
+		resp.ID = msg.ID
+	case OpDelete:
+		if req.Area == AreaBulletin {
+			msg, err := b.store.Get(req.Area, source, req.ID)
+			if err != nil {
+				resp.Err = err.Error()
+				break
+			}
+			if msg.From != source {
+				resp.Err = "only the author can delete a bulletin"
+				break
+			}
+		}
+		if err := b.store.Delete(req.Area, source, req.ID); err != nil {
+			resp.Err = err.Error()
+		}
+	}
+
+	return resp
+}
+
+// page returns the messages after id that fit in one response, more is set when some were left out
+func page(messages []Message, after uint32) (listed []Message, more bool) {
+	size := listSize
+	for i, msg := range messages {
+		if msg.ID <= after {
+			continue
+		}
+		size += listedSize(msg)
+		if size > frame.MaxPayload || len(listed) == 0xFFFF {
+			return listed, true
+		}
+		listed = append(listed, messages[i])
+	}
+	return listed, false
+}
+
+func (b *BBS) respond(req frame.Frame, resp Response) (frame.Frame, bool) {
+	payload, err := resp.MarshalBinary()
+	if err != nil {
+		misc.Log("error", fmt.Sprintf("Failed to marshal response: %v", err))
+		payload, _ = Response{Op: resp.Op, Err: "internal error"}.MarshalBinary()
+	}
+	return frame.New(frame.TypeBBS, b.callsign, req.Source, req.Sequence, payload), true
+}
+
+// NewRequest returns a request frame from source to the BBS at bbs
+func NewRequest(source, bbs string, sequence uint16, req Request) (frame.Frame, error) {
+	payload, err := req.MarshalBinary()
+	if err != nil {
+		return frame.Frame{}, err
+	}
+	return frame.New(frame.TypeBBS, source, bbs, sequence, payload), nil
+}
+
+// ParseResponse returns the response carried in f
+func ParseResponse(f frame.Frame) (Response, error) {
+	if f.Type != frame.TypeBBS {
+		return Response{}, fmt.Errorf("not a bbs frame: %s", f.Type)
+	}
+	var resp Response
+	if err := resp.UnmarshalBinary(f.Payload); err != nil {
+		return Response{}, err
+	}
+	return resp, nil
+}
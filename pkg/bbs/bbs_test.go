@@ -0,0 +1,196 @@
+package bbs_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/8ff/udarp/pkg/bbs"
+	"github.com/8ff/udarp/pkg/frame"
+)
+
+func newBBS(t *testing.T) *bbs.BBS {
+	store, err := bbs.Open("")
+	if err != nil {
+		t.Fatalf("Open failed with error: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	b, err := bbs.New("W1BBS", store)
+	if err != nil {
+		t.Fatalf("New failed with error: %v", err)
+	}
+	return b
+}
+
+// request sends req over the air (marshalled and unmarshalled) and returns the parsed response
+func request(t *testing.T, b *bbs.BBS, source string, req bbs.Request) bbs.Response {
+	f, err := bbs.NewRequest(source, b.Callsign(), 7, req)
+	if err != nil {
+		t.Fatalf("NewRequest failed with error: %v", err)
+	}
+	f = roundTrip(t, f)
+
+	out, ok := b.Handle(f)
+	if !ok {
+		t.Fatalf("BBS did not answer request %+v", req)
+	}
+	out = roundTrip(t, out)
+	if out.Destination != source || out.Sequence != 7 {
+		t.Fatalf("Response should go back to %s with the request sequence, got %s %d", source, out.Destination, out.Sequence)
+	}
+
+	resp, err := bbs.ParseResponse(out)
+	if err != nil {
+		t.Fatalf("ParseResponse failed with error: %v", err)
+	}
+	if resp.Op != req.Op {
+		t.Fatalf("Response op %d doesn't match request op %d", resp.Op, req.Op)
+	}
+	return resp
+}
+
+func roundTrip(t *testing.T, f frame.Frame) frame.Frame {
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed with error: %v", err)
+	}
+	var parsed frame.Frame
+	if err := parsed.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary failed with error: %v", err)
+	}
+	return parsed
+}
+
+func TestMailbox(t *testing.T) {
+	b := newBBS(t)
+
+	sent := request(t, b, "K1ABC", bbs.Request{Op: bbs.OpSend, Area: bbs.AreaMail, To: "ka1xyz", Subject: "Sked", Body: "14.074 at 1800z"})
+	if sent.Err != "" || sent.ID == 0 {
+		t.Fatalf("Send failed: %+v", sent)
+	}
+
+	// Only the recipient sees the mail
+	if list := request(t, b, "K1ABC", bbs.Request{Op: bbs.OpList, Area: bbs.AreaMail}); len(list.Messages) != 0 {
+		t.Fatalf("Sender should not see mail in their own mailbox, got %+v", list.Messages)
+	}
+	list := request(t, b, "KA1XYZ", bbs.Request{Op: bbs.OpList, Area: bbs.AreaMail})
+	if len(list.Messages) != 1 || list.Messages[0].ID != sent.ID || list.Messages[0].From != "K1ABC" || list.Messages[0].Subject != "Sked" {
+		t.Fatalf("Unexpected list: %+v", list)
+	}
+	if list.Messages[0].Body != "" {
+		t.Fatalf("List should not carry bodies")
+	}
+
+	read := request(t, b, "KA1XYZ", bbs.Request{Op: bbs.OpRead, Area: bbs.AreaMail, ID: sent.ID})
+	if read.Err != "" || len(read.Messages) != 1 {
+		t.Fatalf("Read failed: %+v", read)
+	}
+	msg := read.Messages[0]
+	if msg.Body != "14.074 at 1800z" || msg.To != "KA1XYZ" || msg.Area != bbs.AreaMail || msg.Time.IsZero() {
+		t.Fatalf("Unexpected message: %+v", msg)
+	}
+
+	if resp := request(t, b, "K1ABC", bbs.Request{Op: bbs.OpRead, Area: bbs.AreaMail, ID: sent.ID}); resp.Err == "" {
+		t.Fatalf("Other stations should not be able to read mail")
+	}
+	if resp := request(t, b, "K1ABC", bbs.Request{Op: bbs.OpDelete, Area: bbs.AreaMail, ID: sent.ID}); resp.Err == "" {
+		t.Fatalf("Other stations should not be able to delete mail")
+	}
+	if resp := request(t, b, "KA1XYZ", bbs.Request{Op: bbs.OpDelete, Area: bbs.AreaMail, ID: sent.ID}); resp.Err != "" {
+		t.Fatalf("Delete failed: %s", resp.Err)
+	}
+	if list := request(t, b, "KA1XYZ", bbs.Request{Op: bbs.OpList, Area: bbs.AreaMail}); len(list.Messages) != 0 {
+		t.Fatalf("Mailbox should be empty after delete, got %+v", list.Messages)
+	}
+}
+
+func TestBulletins(t *testing.T) {
+	b := newBBS(t)
+
+	first := request(t, b, "K1ABC", bbs.Request{Op: bbs.OpSend, Area: bbs.AreaBulletin, Subject: "Net", Body: "Sunday 1900z"})
+	second := request(t, b, "KA1XYZ", bbs.Request{Op: bbs.OpSend, Area: bbs.AreaBulletin, Subject: "For sale", Body: "Dipole"})
+	if first.Err != "" || second.Err != "" || second.ID <= first.ID {
+		t.Fatalf("Send failed: %+v %+v", first, second)
+	}
+
+	list := request(t, b, "W9XYZ", bbs.Request{Op: bbs.OpList, Area: bbs.AreaBulletin})
+	if len(list.Messages) != 2 || list.Messages[0].ID != first.ID || list.Messages[1].Subject != "For sale" {
+		t.Fatalf("Unexpected bulletin list: %+v", list)
+	}
+
+	read := request(t, b, "W9XYZ", bbs.Request{Op: bbs.OpRead, Area: bbs.AreaBulletin, ID: first.ID})
+	if read.Err != "" || read.Messages[0].Body != "Sunday 1900z" || read.Messages[0].Area != bbs.AreaBulletin {
+		t.Fatalf("Unexpected read: %+v", read)
+	}
+
+	if resp := request(t, b, "W9XYZ", bbs.Request{Op: bbs.OpDelete, Area: bbs.AreaBulletin, ID: first.ID}); resp.Err == "" {
+		t.Fatalf("Only the author should be able to delete a bulletin")
+	}
+	if resp := request(t, b, "K1ABC", bbs.Request{Op: bbs.OpDelete, Area: bbs.AreaBulletin, ID: first.ID}); resp.Err != "" {
+		t.Fatalf("Delete failed: %s", resp.Err)
+	}
+	if list := request(t, b, "W9XYZ", bbs.Request{Op: bbs.OpList, Area: bbs.AreaBulletin}); len(list.Messages) != 1 {
+		t.Fatalf("Expected one bulletin after delete, got %+v", list.Messages)
+	}
+}
+
+func TestListPages(t *testing.T) {
+	b := newBBS(t)
+
+	// Long subjects so the area does not fit one response
+	subject := strings.Repeat("X", bbs.MaxSubject)
+	const posted = 300
+	for i := 0; i < posted; i++ {
+		if resp := request(t, b, "K1ABC", bbs.Request{Op: bbs.OpSend, Area: bbs.AreaBulletin, Subject: subject}); resp.Err != "" {
+			t.Fatalf("Send failed: %s", resp.Err)
+		}
+	}
+
+	var listed []bbs.Message
+	pages := 0
+	req := bbs.Request{Op: bbs.OpList, Area: bbs.AreaBulletin}
+	for {
+		list := request(t, b, "W9XYZ", req)
+		if list.Err != "" || len(list.Messages) == 0 {
+			t.Fatalf("List failed: %s, %d messages", list.Err, len(list.Messages))
+		}
+		listed = append(listed, list.Messages...)
+		pages++
+		if !list.More {
+			break
+		}
+		req.After = list.Messages[len(list.Messages)-1].ID
+	}
+
+	if pages < 2 || len(listed) != posted {
+		t.Fatalf("Expected %d bulletins over several pages, got %d in %d pages", posted, len(listed), pages)
+	}
+	for i := 1; i < len(listed); i++ {
+		if listed[i].ID <= listed[i-1].ID {
+			t.Fatalf("Pages overlap or are out of order at %d", i)
+		}
+	}
+}
+
+func TestHandleIgnoresOtherFrames(t *testing.T) {
+	b := newBBS(t)
+
+	if _, ok := b.Handle(frame.New(frame.TypeText, "K1ABC", "W1BBS", 1, []byte("hi"))); ok {
+		t.Fatalf("Non BBS frames should be ignored")
+	}
+	if _, ok := b.Handle(frame.New(frame.TypeBBS, "K1ABC", "W2BBS", 1, []byte{byte(bbs.OpList), 0})); ok {
+		t.Fatalf("Frames for other stations should be ignored")
+	}
+	resp, _ := bbs.Response{Op: bbs.OpList}.MarshalBinary()
+	if _, ok := b.Handle(frame.New(frame.TypeBBS, "W2BBS", "W1BBS", 1, resp)); ok {
+		t.Fatalf("Responses should never be answered")
+	}
+
+	out, ok := b.Handle(frame.New(frame.TypeBBS, "K1ABC", "W1BBS", 1, []byte{byte(bbs.OpRead), 0, 1}))
+	if !ok {
+		t.Fatalf("Malformed requests should get an error response")
+	}
+	if r, err := bbs.ParseResponse(out); err != nil || r.Err == "" {
+		t.Fatalf("Expected error response, got %+v %v", r, err)
+	}
+}
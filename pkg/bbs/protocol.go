@@ -0,0 +1,334 @@
+package bbs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/8ff/udarp/pkg/pack"
+)
+
+/*
+Over the air BBS protocol, carried in the payload of frame.TypeBBS frames.
+
+Request, big endian:
+	0      Op
+	1      Area
+	List   2..5 id to list after, 0 or left out for the first page
+	Read   2..5 message id
+	Delete 2..5 message id
+	Send   2..5 recipient, 28 bit packed, ignored for bulletins
+	       6 subject length, subject, body until the end of the payload
+
+Response:
+	0      Op | opResponse
+	1      Status, StatusOK or StatusError
+	Error  error text until the end of the payload
+	List   2 more, 1 when messages after the last listed one did not fit
+	       3..4 count, per message: id (4), from (4), unix time (4), subject length (1), subject
+	Read   id (4), from (4), to (4, 0 for bulletins), unix time (4), subject length (1), subject, body
+	Send   2..5 id of the stored message
+	Delete nothing
+*/
+
+type Op uint8
+
+const (
+	OpList Op = iota + 1
+	OpRead
+	OpSend
+	OpDelete
+)
+
+const opResponse = 0x80
+
+const (
+	StatusOK    = 0
+	StatusError = 1
+)
+
+const MaxSubject = 0xFF
+
+var ErrMalformed = errors.New("malformed bbs payload")
+
+type Request struct {
+	Op      Op
+	Area    Area
+	ID      uint32 // Read and Delete
+	After   uint32 // List messages with a higher id, the last id of the previous page
+	To      string // Send to mail area
+	Subject string // Send
+	Body    string // Send
+}
+
+type Response struct {
+	Op       Op
+	Err      string    // Set when the request failed
+	Messages []Message // List holds Body-less messages, Read holds one message
+	More     bool      // List did not fit, ask again with After set to the last id
+	ID       uint32    // Send
+}
+
+func (r Request) MarshalBinary() ([]byte, error) {
+	if r.Area != AreaMail && r.Area != AreaBulletin {
+		return nil, fmt.Errorf("unknown area %d", r.Area)
+	}
+	data := []byte{byte(r.Op), byte(r.Area)}
+
+	switch r.Op {
+	case OpList:
+		data = binary.BigEndian.AppendUint32(data, r.After)
+	case OpRead, OpDelete:
+		data = binary.BigEndian.AppendUint32(data, r.ID)
+	case OpSend:
+		var to uint32
+		if r.Area == AreaMail {
+			packed, err := pack.PackCallsign(r.To)
+			if err != nil {
+				return nil, fmt.Errorf("recipient: %w", err)
+			}
+			to = packed
+		}
+		data = binary.BigEndian.AppendUint32(data, to)
+		var err error
+		if data, err = appendString(data, r.Subject); err != nil {
+			return nil, err
+		}
+		data = append(data, r.Body...)
+	default:
+		return nil, fmt.Errorf("unknown op %d", r.Op)
+	}
+	return data, nil
+}
+
+func (r *Request) UnmarshalBinary(data []byte) error {
+	if len(data) < 2 {
+		return fmt.Errorf("%w: %d bytes", ErrMalformed, len(data))
+	}
+	parsed := Request{Op: Op(data[0]), Area: Area(data[1])}
+	if parsed.Area != AreaMail && parsed.Area != AreaBulletin {
+		return fmt.Errorf("%w: unknown area %d", ErrMalformed, parsed.Area)
+	}
+	data = data[2:]
+
+	switch parsed.Op {
+	case OpList:
+		switch len(data) {
+		case 0:
+		case 4:
+			parsed.After = binary.BigEndian.Uint32(data)
+		default:
+			return fmt.Errorf("%w: list start", ErrMalformed)
+		}
+	case OpRead, OpDelete:
+		if len(data) != 4 {
+			return fmt.Errorf("%w: message id", ErrMalformed)
+		}
+		parsed.ID = binary.BigEndian.Uint32(data)
+	case OpSend:
+		if len(data) < 4 {
+			return fmt.Errorf("%w: recipient", ErrMalformed)
+		}
+		if parsed.Area == AreaMail {
+			to, err := pack.UnpackCallsign(binary.BigEndian.Uint32(data))
+			if err != nil {
+				return fmt.Errorf("%w: recipient: %s", ErrMalformed, err)
+			}
+			parsed.To = to
+		}
+		var err error
+		if parsed.Subject, data, err = readString(data[4:]); err != nil {
+			return err
+		}
+		parsed.Body = string(data)
+	default:
+		return fmt.Errorf("%w: unknown op %d", ErrMalformed, parsed.Op)
+	}
+
+	*r = parsed
+	return nil
+}
+
+func (r Response) MarshalBinary() ([]byte, error) {
+	data := []byte{byte(r.Op) | opResponse, StatusOK}
+	if r.Err != "" {
+		data[1] = StatusError
+		return append(data, r.Err...), nil
+	}
+
+	var err error
+	switch r.Op {
+	case OpList:
+		if len(r.Messages) > 0xFFFF {
+			return nil, fmt.Errorf("too many messages to list")
+		}
+		more := byte(0)
+		if r.More {
+			more = 1
+		}
+		data = append(data, more)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(r.Messages)))
+		for _, msg := range r.Messages {
+			if data, err = appendMessage(data, msg, false); err != nil {
+				return nil, err
+			}
+		}
+	case OpRead:
+		if len(r.Messages) != 1 {
+			return nil, fmt.Errorf("read response needs exactly one message")
+		}
+		if data, err = appendMessage(data, r.Messages[0], true); err != nil {
+			return nil, err
+		}
+	case OpSend:
+		data = binary.BigEndian.AppendUint32(data, r.ID)
+	case OpDelete:
+	default:
+		return nil, fmt.Errorf("unknown op %d", r.Op)
+	}
+	return data, nil
+}
+
+func (r *Response) UnmarshalBinary(data []byte) error {
+	if len(data) < 2 || data[0]&opResponse == 0 {
+		return fmt.Errorf("%w: not a response", ErrMalformed)
+	}
+	parsed := Response{Op: Op(data[0] &^ opResponse)}
+	if data[1] != StatusOK {
+		parsed.Err = string(data[2:])
+		if parsed.Err == "" {
+			parsed.Err = "unknown error"
+		}
+		*r = parsed
+		return nil
+	}
+	data = data[2:]
+
+	var err error
+	switch parsed.Op {
+	case OpList:
+		if len(data) < 3 {
+			return fmt.Errorf("%w: message count", ErrMalformed)
+		}
+		parsed.More = data[0] != 0
+		count := int(binary.BigEndian.Uint16(data[1:]))
+		data = data[3:]
+		for i := 0; i < count; i++ {
+			var msg Message
+			if msg, data, err = readMessage(data, false); err != nil {
+				return err
+			}
+			parsed.Messages = append(parsed.Messages, msg)
+		}
+	case OpRead:
+		var msg Message
+		if msg, _, err = readMessage(data, true); err != nil {
+			return err
+		}
+		parsed.Messages = []Message{msg}
+	case OpSend:
+		if len(data) != 4 {
+			return fmt.Errorf("%w: message id", ErrMalformed)
+		}
+		parsed.ID = binary.BigEndian.Uint32(data)
+	case OpDelete:
+	default:
+		return fmt.Errorf("%w: unknown op %d", ErrMalformed, parsed.Op)
+	}
+
+	*r = parsed
+	return nil
+}
+
+// appendMessage appends a message, the body and recipient are only sent when full is set
+func appendMessage(data []byte, msg Message, full bool) ([]byte, error) {
+	from, err := pack.PackCallsign(msg.From)
+	if err != nil {
+		return nil, fmt.Errorf("sender: %w", err)
+	}
+	data = binary.BigEndian.AppendUint32(data, msg.ID)
+	data = binary.BigEndian.AppendUint32(data, from)
+
+	if full {
+		var to uint32
+		if msg.Area == AreaMail {
+			if to, err = pack.PackCallsign(msg.To); err != nil {
+				return nil, fmt.Errorf("recipient: %w", err)
+			}
+		}
+		data = binary.BigEndian.AppendUint32(data, to)
+	}
+
+	data = binary.BigEndian.AppendUint32(data, uint32(msg.Time.Unix()))
+	if data, err = appendString(data, msg.Subject); err != nil {
+		return nil, err
+	}
+	if full {
+		data = append(data, msg.Body...)
+	}
+	return data, nil
+}
+
+// readMessage is the reverse of appendMessage, a full message uses up the rest of data
+func readMessage(data []byte, full bool) (Message, []byte, error) {
+	size := 12
+	if full {
+		size = 16
+	}
+	if len(data) < size {
+		return Message{}, nil, fmt.Errorf("%w: message", ErrMalformed)
+	}
+
+	msg := Message{ID: binary.BigEndian.Uint32(data)}
+	from, err := pack.UnpackCallsign(binary.BigEndian.Uint32(data[4:]))
+	if err != nil {
+		return Message{}, nil, fmt.Errorf("%w: sender: %s", ErrMalformed, err)
+	}
+	msg.From = from
+	data = data[8:]
+
+	if full {
+		msg.Area = AreaBulletin
+		if to := binary.BigEndian.Uint32(data); to != 0 {
+			msg.Area = AreaMail
+			if msg.To, err = pack.UnpackCallsign(to); err != nil {
+				return Message{}, nil, fmt.Errorf("%w: recipient: %s", ErrMalformed, err)
+			}
+		}
+		data = data[4:]
+	}
+
+	msg.Time = time.Unix(int64(binary.BigEndian.Uint32(data)), 0).UTC()
+	if msg.Subject, data, err = readString(data[4:]); err != nil {
+		return Message{}, nil, err
+	}
+	if full {
+		msg.Body = string(data)
+		data = nil
+	}
+	return msg, data, nil
+}
+
+// listSize is the size of a list response without messages and listedSize the size each message adds
+const listSize = 5
+
+func listedSize(msg Message) int {
+	return 13 + len(msg.Subject)
+}
+
+func appendString(data []byte, s string) ([]byte, error) {
+	if len(s) > MaxSubject {
+		return nil, fmt.Errorf("subject is longer than %d bytes", MaxSubject)
+	}
+	data = append(data, byte(len(s)))
+	return append(data, s...), nil
+}
+
+func readString(data []byte) (string, []byte, error) {
+	if len(data) < 1 || len(data) < 1+int(data[0]) {
+		return "", nil, fmt.Errorf("%w: subject", ErrMalformed)
+	}
+	n := int(data[0])
+	return string(data[1 : 1+n]), data[1+n:], nil
+}
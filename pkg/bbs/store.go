@@ -0,0 +1,182 @@
+package bbs
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+/*
+Message storage for the BBS, kept in badger.
+
+Personal mail is stored under bbs_mail_<CALLSIGN>_<id> and bulletins under bbs_bulletin_<id>, ids
+come from a badger sequence so they are unique across both areas and survive restarts.
+*/
+
+type Area uint8
+
+const (
+	AreaMail Area = iota
+	AreaBulletin
+)
+
+type Message struct {
+	ID      uint32    `json:"id"`
+	Area    Area      `json:"area"`
+	From    string    `json:"from"`
+	To      string    `json:"to"` // Empty for bulletins
+	Subject string    `json:"subject"`
+	Body    string    `json:"body"`
+	Time    time.Time `json:"time"`
+}
+
+type Store struct {
+	db  *badger.DB
+	seq *badger.Sequence
+}
+
+// Open opens the store at path, an empty path keeps it in memory only
+func Open(path string) (*Store, error) {
+	opts := badger.DefaultOptions(path)
+	if path == "" {
+		opts = opts.WithInMemory(true)
+	}
+	opts.Logger = nil
+
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bbs store: %w", err)
+	}
+
+	seq, err := db.GetSequence([]byte("bbs_sequence"), 10)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to get bbs sequence: %w", err)
+	}
+
+	return &Store{db: db, seq: seq}, nil
+}
+
+func (s *Store) Close() error {
+	s.seq.Release()
+	return s.db.Close()
+}
+
+func key(area Area, owner string, id uint32) []byte {
+	if area == AreaBulletin {
+		return []byte(fmt.Sprintf("bbs_bulletin_%010d", id))
+	}
+	return []byte(fmt.Sprintf("bbs_mail_%s_%010d", owner, id))
+}
+
+func prefix(area Area, owner string) []byte {
+	if area == AreaBulletin {
+		return []byte("bbs_bulletin_")
+	}
+	return []byte(fmt.Sprintf("bbs_mail_%s_", owner))
+}
+
+// Add stores msg and returns it with ID and Time filled in
+func (s *Store) Add(msg Message) (Message, error) {
+	if msg.Area != AreaMail && msg.Area != AreaBulletin {
+		return Message{}, fmt.Errorf("unknown area %d", msg.Area)
+	}
+	msg.From = strings.ToUpper(msg.From)
+	msg.To = strings.ToUpper(msg.To)
+	if msg.Area == AreaMail && msg.To == "" {
+		return Message{}, fmt.Errorf("mail needs a recipient")
+	}
+	if msg.Area == AreaBulletin {
+		msg.To = ""
+	}
+
+	id, err := s.seq.Next()
+	if err != nil {
+		return Message{}, err
+	}
+	// Sequence starts at 0, ids start at 1 so 0 can mean none
+	msg.ID = uint32(id + 1)
+	msg.Time = time.Now().UTC().Truncate(time.Second)
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return Message{}, err
+	}
+
+	err = s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(key(msg.Area, msg.To, msg.ID), data)
+	})
+	if err != nil {
+		return Message{}, fmt.Errorf("failed to store message: %w", err)
+	}
+	return msg, nil
+}
+
+// List returns the messages in an area, owner selects the mailbox for AreaMail
+func (s *Store) List(area Area, owner string) ([]Message, error) {
+	var messages []Message
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = prefix(area, strings.ToUpper(owner))
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			value, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			var msg Message
+			if err := json.Unmarshal(value, &msg); err != nil {
+				return fmt.Errorf("failed to unmarshal message: %w", err)
+			}
+			messages = append(messages, msg)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(messages, func(i, j int) bool { return messages[i].ID < messages[j].ID })
+	return messages, nil
+}
+
+// Get returns a single message, owner selects the mailbox for AreaMail
+func (s *Store) Get(area Area, owner string, id uint32) (Message, error) {
+	var msg Message
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(key(area, strings.ToUpper(owner), id))
+		if err != nil {
+			return err
+		}
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(value, &msg)
+	})
+	if err == badger.ErrKeyNotFound {
+		return Message{}, fmt.Errorf("message %d not found", id)
+	}
+	if err != nil {
+		return Message{}, err
+	}
+	return msg, nil
+}
+
+// Delete removes a single message, owner selects the mailbox for AreaMail
+func (s *Store) Delete(area Area, owner string, id uint32) error {
+	if _, err := s.Get(area, owner, id); err != nil {
+		return err
+	}
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(key(area, strings.ToUpper(owner), id))
+	})
+}
@@ -0,0 +1,261 @@
+package beacon
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/8ff/udarp/pkg/misc"
+	"github.com/8ff/udarp/pkg/pack"
+)
+
+/*
+Periodic beacon transmissions.
+
+Every Interval a beacon with callsign, locator and power is sent in a slot picked at random inside
+the interval, so stations on the same schedule don't always collide. Before keying up the duty
+cycle over the last DutyWindow is checked and the beacon is skipped if sending it would go over
+MaxDutyCycle. The rig is always put back into RX, even if the transmission failed.
+*/
+
+// Rig switches the transmitter, *txControl.TxControl satisfies it
+type Rig interface {
+	TX() error
+	RX() error
+}
+
+// Transmitter sends bits over the air and returns once they have been played
+type Transmitter func(bits []int) error
+
+type Params struct {
+	Beacon       pack.Beacon
+	Interval     time.Duration // Time between beacons
+	SlotLength   time.Duration // Airtime of one beacon, slots are picked in multiples of this
+	RandomSlot   bool          // Pick a random slot inside the interval instead of the first one
+	MaxDutyCycle float64       // Max fraction of DutyWindow spent transmitting, 0 disables the limit
+	DutyWindow   time.Duration
+}
+
+type Record struct {
+	Time     time.Time
+	Duration time.Duration
+	Beacon   pack.Beacon
+	Skipped  bool // Skipped because of the duty cycle limit
+	Err      string
+}
+
+type Beacon struct {
+	params   Params
+	rig      Rig
+	tx       Transmitter
+	bits     []int
+	records  []Record
+	m        sync.Mutex
+	random   *rand.Rand
+	cancel   context.CancelFunc
+	done     chan struct{}
+	runM     sync.Mutex   // Guards cancel and done
+	OnRecord func(Record) // Called for every beacon sent or skipped
+}
+
+// Keep at most this many records in memory
+const maxRecords = 1000
+
+func New(params Params, rig Rig, tx Transmitter) (*Beacon, error) {
+	if params.Interval <= 0 {
+		return nil, fmt.Errorf("interval must be greater than 0")
+	}
+	if params.SlotLength <= 0 || params.SlotLength > params.Interval {
+		return nil, fmt.Errorf("slot length must be between 0 and the interval")
+	}
+	if params.MaxDutyCycle < 0 || params.MaxDutyCycle > 1 {
+		return nil, fmt.Errorf("max duty cycle must be between 0 and 1")
+	}
+	if params.MaxDutyCycle > 0 && params.DutyWindow <= 0 {
+		return nil, fmt.Errorf("duty window must be set when limiting the duty cycle")
+	}
+	if rig == nil || tx == nil {
+		return nil, fmt.Errorf("rig and transmitter must be set")
+	}
+
+	bits, err := Encode(params.Beacon)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Beacon{
+		params: params,
+		rig:    rig,
+		tx:     tx,
+		bits:   bits,
+		random: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}, nil
+}
+
+// Encode packs b into BeaconBits 1/0s, MSB first
+func Encode(b pack.Beacon) ([]int, error) {
+	packed, err := b.Pack()
+	if err != nil {
+		return nil, err
+	}
+	bits := make([]int, pack.BeaconBits)
+	for i := range bits {
+		bits[i] = int(packed>>uint(pack.BeaconBits-1-i)) & 1
+	}
+	return bits, nil
+}
+
+// Decode is the inverse of Encode
+func Decode(bits []int) (pack.Beacon, error) {
+	if len(bits) != pack.BeaconBits {
+		return pack.Beacon{}, fmt.Errorf("beacon needs %d bits, got %d", pack.BeaconBits, len(bits))
+	}
+	var packed uint64
+	for _, bit := range bits {
+		packed = packed<<1 | uint64(bit&1)
+	}
+	return pack.UnpackBeacon(packed)
+}
+
+// Start runs the schedule in the background until Stop is called
+func (b *Beacon) Start() {
+	b.runM.Lock()
+	defer b.runM.Unlock()
+	if b.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b.cancel = cancel
+	b.done = make(chan struct{})
+
+	go func() {
+		defer close(b.done)
+		b.run(ctx)
+	}()
+}
+
+// Stop ends the schedule and waits for a beacon in progress to finish
+func (b *Beacon) Stop() {
+	b.runM.Lock()
+	defer b.runM.Unlock()
+	if b.cancel == nil {
+		return
+	}
+	b.cancel()
+	<-b.done
+	b.cancel = nil
+}
+
+// Running reports if the schedule is active
+func (b *Beacon) Running() bool {
+	b.runM.Lock()
+	defer b.runM.Unlock()
+	return b.cancel != nil
+}
+
+func (b *Beacon) run(ctx context.Context) {
+	for {
+		// Intervals are aligned to the clock so every station agrees where they start
+		now := time.Now()
+		start := now.Truncate(b.params.Interval)
+		if start.Before(now) {
+			start = start.Add(b.params.Interval)
+		}
+
+		slots := int(b.params.Interval / b.params.SlotLength)
+		slot := 0
+		if b.params.RandomSlot && slots > 1 {
+			slot = b.random.Intn(slots)
+		}
+
+		timer := time.NewTimer(time.Until(start.Add(time.Duration(slot) * b.params.SlotLength)))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		b.Send()
+	}
+}
+
+// Send transmits one beacon now, unless it would go over the duty cycle limit
+func (b *Beacon) Send() Record {
+	record := Record{Time: time.Now(), Beacon: b.params.Beacon}
+
+	if !b.dutyCycleAllows(record.Time) {
+		record.Skipped = true
+		misc.Log("warning", "Beacon skipped, duty cycle limit reached")
+		b.record(record)
+		return record
+	}
+
+	err := b.transmit()
+	record.Duration = time.Since(record.Time)
+	if err != nil {
+		record.Err = err.Error()
+		misc.Log("error", fmt.Sprintf("Beacon failed: %s", err))
+	} else {
+		misc.Log("info", fmt.Sprintf("Beacon sent: %s %s %ddBm", b.params.Beacon.Callsign, b.params.Beacon.Grid, b.params.Beacon.Power))
+	}
+
+	b.record(record)
+	return record
+}
+
+func (b *Beacon) transmit() error {
+	if err := b.rig.TX(); err != nil {
+		// Make sure we are not left keyed up half way
+		b.rig.RX()
+		return err
+	}
+
+	txErr := b.tx(b.bits)
+	rxErr := b.rig.RX()
+	if txErr != nil {
+		return txErr
+	}
+	return rxErr
+}
+
+// Airtime already used in the window plus one more slot must stay under the limit
+func (b *Beacon) dutyCycleAllows(now time.Time) bool {
+	if b.params.MaxDutyCycle == 0 {
+		return true
+	}
+
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	used := b.params.SlotLength
+	for _, r := range b.records {
+		if !r.Skipped && now.Sub(r.Time) < b.params.DutyWindow {
+			used += r.Duration
+		}
+	}
+	return float64(used) <= b.params.MaxDutyCycle*float64(b.params.DutyWindow)
+}
+
+func (b *Beacon) record(r Record) {
+	b.m.Lock()
+	b.records = append(b.records, r)
+	if len(b.records) > maxRecords {
+		b.records = b.records[len(b.records)-maxRecords:]
+	}
+	b.m.Unlock()
+
+	if b.OnRecord != nil {
+		b.OnRecord(r)
+	}
+}
+
+// Records returns a copy of the beacons sent or skipped so far, oldest first
+func (b *Beacon) Records() []Record {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return append([]Record{}, b.records...)
+}
@@ -0,0 +1,115 @@
+package beacon_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/8ff/udarp/pkg/beacon"
+	"github.com/8ff/udarp/pkg/pack"
+)
+
+type fakeRig struct {
+	keyed  bool
+	events []string
+	failTX bool
+}
+
+func (r *fakeRig) TX() error {
+	if r.failTX {
+		return fmt.Errorf("rig not responding")
+	}
+	r.keyed = true
+	r.events = append(r.events, "tx")
+	return nil
+}
+
+func (r *fakeRig) RX() error {
+	r.keyed = false
+	r.events = append(r.events, "rx")
+	return nil
+}
+
+var testBeacon = pack.Beacon{Callsign: "K1ABC", Grid: "FN42", Power: 37}
+
+func TestSendKeysRigAroundTransmission(t *testing.T) {
+	rig := &fakeRig{}
+	var sent []int
+	b, err := beacon.New(beacon.Params{Beacon: testBeacon, Interval: time.Minute, SlotLength: time.Second}, rig, func(bits []int) error {
+		if !rig.keyed {
+			t.Fatalf("Rig should be in TX while transmitting")
+		}
+		sent = bits
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("New failed with error: %v", err)
+	}
+
+	record := b.Send()
+	if record.Err != "" || record.Skipped {
+		t.Fatalf("Beacon should have been sent: %+v", record)
+	}
+	if rig.keyed || len(rig.events) != 2 {
+		t.Fatalf("Rig should be back in RX, events: %v", rig.events)
+	}
+
+	decoded, err := beacon.Decode(sent)
+	if err != nil || decoded != testBeacon {
+		t.Fatalf("Sent beacon %+v doesn't match %+v (err: %v)", decoded, testBeacon, err)
+	}
+	if len(b.Records()) != 1 {
+		t.Fatalf("Expected 1 record, got %d", len(b.Records()))
+	}
+
+	rig.failTX = true
+	if record := b.Send(); record.Err == "" {
+		t.Fatalf("Expected error when the rig fails to key up")
+	}
+}
+
+func TestDutyCycleLimit(t *testing.T) {
+	rig := &fakeRig{}
+	b, _ := beacon.New(beacon.Params{
+		Beacon:       testBeacon,
+		Interval:     time.Minute,
+		SlotLength:   20 * time.Millisecond,
+		MaxDutyCycle: 0.5,
+		DutyWindow:   100 * time.Millisecond,
+	}, rig, func(bits []int) error {
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	})
+
+	skipped := 0
+	for i := 0; i < 4; i++ {
+		if b.Send().Skipped {
+			skipped++
+		}
+	}
+	if skipped == 0 {
+		t.Fatalf("Expected beacons to be skipped once the duty cycle limit is reached")
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	rig := &fakeRig{}
+	sent := make(chan struct{}, 10)
+	b, _ := beacon.New(beacon.Params{Beacon: testBeacon, Interval: 50 * time.Millisecond, SlotLength: 10 * time.Millisecond, RandomSlot: true}, rig, func(bits []int) error {
+		sent <- struct{}{}
+		return nil
+	})
+
+	b.Start()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-sent:
+		case <-time.After(time.Second):
+			t.Fatalf("Beacon was not sent on schedule")
+		}
+	}
+	b.Stop()
+	if b.Running() {
+		t.Fatalf("Beacon should be stopped")
+	}
+}
@@ -1,126 +1,194 @@
 package buffer
 
 import (
-	"bytes"
+	"context"
 	"io"
-	"sync"
+	"sync/atomic"
 )
 
-type Buffer struct {
-	b bytes.Buffer
-	m sync.Mutex
-}
-
-func (b *Buffer) Bytes() []byte {
-	b.m.Lock()
-	defer b.m.Unlock()
-	return b.b.Bytes()
-}
-
-func (b *Buffer) Cap() int {
-	b.m.Lock()
-	defer b.m.Unlock()
-	return b.b.Cap()
-}
-
-func (b *Buffer) Grow(n int) {
-	b.m.Lock()
-	defer b.m.Unlock()
-	b.b.Grow(n)
-}
-
-func (b *Buffer) Len() int {
-	b.m.Lock()
-	defer b.m.Unlock()
-	return b.b.Len()
-}
-
-func (b *Buffer) Next(n int) []byte {
-	b.m.Lock()
-	defer b.m.Unlock()
-	return b.b.Next(n)
-}
-
-func (b *Buffer) ReadByte() (c byte, err error) {
-	b.m.Lock()
-	defer b.m.Unlock()
-	return b.b.ReadByte()
-}
-
-func (b *Buffer) ReadBytes(delim byte) (line []byte, err error) {
-	b.m.Lock()
-	defer b.m.Unlock()
-	return b.b.ReadBytes(delim)
-}
-
-func (b *Buffer) ReadFrom(r io.Reader) (n int64, err error) {
-	b.m.Lock()
-	defer b.m.Unlock()
-	return b.b.ReadFrom(r)
-}
-
-func (b *Buffer) ReadRune() (r rune, size int, err error) {
-	b.m.Lock()
-	defer b.m.Unlock()
-	return b.b.ReadRune()
-}
-
-func (b *Buffer) ReadString(delim byte) (line string, err error) {
-	b.m.Lock()
-	defer b.m.Unlock()
-	return b.b.ReadString(delim)
-}
-
-func (b *Buffer) Reset() {
-	b.m.Lock()
-	defer b.m.Unlock()
-	b.b.Reset()
-}
-
-func (b *Buffer) Truncate(n int) {
-	b.m.Lock()
-	defer b.m.Unlock()
-	b.b.Truncate(n)
-}
-
-func (b *Buffer) UnreadByte() error {
-	b.m.Lock()
-	defer b.m.Unlock()
-	return b.b.UnreadByte()
-}
-
-func (b *Buffer) UnreadRune() error {
-	b.m.Lock()
-	defer b.m.Unlock()
-	return b.b.UnreadRune()
-}
-
-func (b *Buffer) WriteByte(c byte) error {
-	b.m.Lock()
-	defer b.m.Unlock()
-	return b.b.WriteByte(c)
-}
-
-func (b *Buffer) WriteRune(r rune) (n int, err error) {
-	b.m.Lock()
-	defer b.m.Unlock()
-	return b.b.WriteRune(r)
-}
-
-func (b *Buffer) WriteString(s string) (n int, err error) {
-	b.m.Lock()
-	defer b.m.Unlock()
-	return b.b.WriteString(s)
-}
-
-func (b *Buffer) WriteTo(w io.Writer) (n int64, err error) {
-	b.m.Lock()
-	defer b.m.Unlock()
-	return b.b.WriteTo(w)
-}
-
-func (b *Buffer) Write(p []byte) (n int, err error) {
-	b.m.Lock()
-	defer b.m.Unlock()
-	return b.b.Write(p)
+/*
+Ring is a fixed capacity single producer, single consumer queue of float32
+samples. Positions are free running counters published with atomics, so
+neither side takes a lock; the producer can be an audio callback.
+
+Write drops what does not fit and counts it as overrun, it never waits.
+Read returns what is there and counts a short read as underrun.
+The Wait variants block until there is room or data, or ctx is done.
+
+Exactly one goroutine may write and one may read at a time.
+*/
+
+type Ring struct {
+	buf  []float32
+	mask uint64
+
+	head atomic.Uint64 // Samples written, only the producer stores it
+	tail atomic.Uint64 // Samples read, only the consumer stores it
+
+	overruns  atomic.Uint64
+	underruns atomic.Uint64
+
+	closed   atomic.Bool
+	done     chan struct{}
+	readable chan struct{} // Poked by the producer after writing
+	writable chan struct{} // Poked by the consumer after reading
+}
+
+// New returns a ring holding at least capacity samples, rounded up to a power of two
+func New(capacity int) *Ring {
+	size := 1
+	for size < capacity {
+		size <<= 1
+	}
+	return &Ring{
+		buf:      make([]float32, size),
+		mask:     uint64(size - 1),
+		done:     make(chan struct{}),
+		readable: make(chan struct{}, 1),
+		writable: make(chan struct{}, 1),
+	}
+}
+
+func poke(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+// Cap is the number of samples the ring holds
+func (r *Ring) Cap() int { return len(r.buf) }
+
+// Len is the number of samples waiting to be read
+func (r *Ring) Len() int { return int(r.head.Load() - r.tail.Load()) }
+
+// Overruns is the number of samples Write dropped because the ring was full
+func (r *Ring) Overruns() uint64 { return r.overruns.Load() }
+
+// Underruns is the number of Reads that got fewer samples than asked for
+func (r *Ring) Underruns() uint64 { return r.underruns.Load() }
+
+// write copies as much of src as fits, producer side
+func (r *Ring) write(src []float32) int {
+	head := r.head.Load()
+	free := len(r.buf) - int(head-r.tail.Load())
+	if len(src) > free {
+		src = src[:free]
+	}
+	if len(src) == 0 {
+		return 0
+	}
+
+	start := int(head & r.mask)
+	n := copy(r.buf[start:], src)
+	copy(r.buf, src[n:])
+	r.head.Store(head + uint64(len(src)))
+	poke(r.readable)
+	return len(src)
+}
+
+// read copies as many samples as are available into dst, consumer side
+func (r *Ring) read(dst []float32) int {
+	tail := r.tail.Load()
+	available := int(r.head.Load() - tail)
+	if len(dst) > available {
+		dst = dst[:available]
+	}
+	if len(dst) == 0 {
+		return 0
+	}
+
+	start := int(tail & r.mask)
+	n := copy(dst, r.buf[start:])
+	copy(dst[n:], r.buf)
+	r.tail.Store(tail + uint64(len(dst)))
+	poke(r.writable)
+	return len(dst)
+}
+
+// Write stores src without waiting, samples that do not fit are dropped and counted as overruns
+func (r *Ring) Write(src []float32) int {
+	if r.closed.Load() {
+		return 0
+	}
+	n := r.write(src)
+	if n < len(src) {
+		r.overruns.Add(uint64(len(src) - n))
+	}
+	return n
+}
+
+// WriteWait stores all of src, waiting for room as needed
+func (r *Ring) WriteWait(ctx context.Context, src []float32) error {
+	for {
+		if r.closed.Load() {
+			return io.ErrClosedPipe
+		}
+		src = src[r.write(src):]
+		if len(src) == 0 {
+			return nil
+		}
+		select {
+		case <-r.writable:
+		case <-r.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Read copies what is available into dst without waiting, a short read counts as an underrun
+func (r *Ring) Read(dst []float32) int {
+	n := r.read(dst)
+	if n < len(dst) && !r.closed.Load() {
+		r.underruns.Add(1)
+	}
+	return n
+}
+
+// ReadWait waits until there is at least one sample and copies what is available into dst.
+// It returns io.EOF once the ring is closed and drained.
+func (r *Ring) ReadWait(ctx context.Context, dst []float32) (int, error) {
+	for {
+		if n := r.read(dst); n > 0 || len(dst) == 0 {
+			return n, nil
+		}
+		// Check closed after an empty read, a final write always lands before Close
+		if r.closed.Load() {
+			if n := r.read(dst); n > 0 {
+				return n, nil
+			}
+			return 0, io.EOF
+		}
+		select {
+		case <-r.readable:
+		case <-r.done:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// ReadFull fills dst, waiting for samples as needed.
+// It returns io.ErrUnexpectedEOF if the ring is closed part way, io.EOF if it was closed and empty.
+func (r *Ring) ReadFull(ctx context.Context, dst []float32) (int, error) {
+	read := 0
+	for read < len(dst) {
+		n, err := r.ReadWait(ctx, dst[read:])
+		read += n
+		if err == io.EOF && read > 0 {
+			return read, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return read, err
+		}
+	}
+	return read, nil
+}
+
+// Close ends the stream, readers drain what is left and then get io.EOF. Only the producer may call it.
+func (r *Ring) Close() {
+	if r.closed.CompareAndSwap(false, true) {
+		close(r.done)
+	}
 }
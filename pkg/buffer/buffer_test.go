@@ -0,0 +1,121 @@
+package buffer_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/8ff/udarp/pkg/buffer"
+)
+
+func sequence(from, n int) []float32 {
+	s := make([]float32, n)
+	for i := range s {
+		s[i] = float32(from + i)
+	}
+	return s
+}
+
+func TestRingWrapAndOverrun(t *testing.T) {
+	r := buffer.New(6)
+	if r.Cap() != 8 {
+		t.Fatalf("Expected capacity to round up to 8, got %d", r.Cap())
+	}
+
+	dst := make([]float32, 5)
+	next := 0
+	// Enough rounds for the positions to wrap several times
+	for round := 0; round < 10; round++ {
+		if n := r.Write(sequence(next, 5)); n != 5 {
+			t.Fatalf("Expected 5 samples written, got %d", n)
+		}
+		if n := r.Read(dst); n != 5 {
+			t.Fatalf("Expected 5 samples read, got %d", n)
+		}
+		for i, v := range dst {
+			if v != float32(next+i) {
+				t.Fatalf("Round %d: expected %v, got %v", round, sequence(next, 5), dst)
+			}
+		}
+		next += 5
+	}
+
+	if n := r.Write(sequence(0, 11)); n != 8 || r.Overruns() != 3 || r.Len() != 8 {
+		t.Fatalf("Expected 8 written and 3 overruns, got %d written, %d overruns, %d queued", n, r.Overruns(), r.Len())
+	}
+	if n := r.Read(make([]float32, 10)); n != 8 || r.Underruns() != 1 {
+		t.Fatalf("Expected a short read of 8 counted as underrun, got %d and %d underruns", n, r.Underruns())
+	}
+}
+
+func TestRingConcurrent(t *testing.T) {
+	r := buffer.New(64)
+	const total = 100000
+
+	go func() {
+		defer r.Close()
+		for i := 0; i < total; i += 37 {
+			n := 37
+			if i+n > total {
+				n = total - i
+			}
+			if err := r.WriteWait(context.Background(), sequence(i, n)); err != nil {
+				t.Errorf("WriteWait failed with error: %v", err)
+				return
+			}
+		}
+	}()
+
+	dst := make([]float32, 48) // Not a divisor of total, the last read is short
+	expected := 0
+	for {
+		n, err := r.ReadFull(context.Background(), dst)
+		for _, v := range dst[:n] {
+			if v != float32(expected) {
+				t.Fatalf("Expected sample %d, got %v", expected, v)
+			}
+			expected++
+		}
+		if err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("ReadFull failed with error: %v", err)
+		}
+	}
+	if expected != total || r.Overruns() != 0 {
+		t.Fatalf("Expected %d samples without overruns, got %d and %d overruns", total, expected, r.Overruns())
+	}
+	if _, err := r.ReadWait(context.Background(), dst); err != io.EOF {
+		t.Fatalf("Expected io.EOF from a closed, drained ring, got %v", err)
+	}
+}
+
+func TestRingCancel(t *testing.T) {
+	r := buffer.New(4)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := r.ReadFull(ctx, make([]float32, 1)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected a deadline error waiting for samples, got %v", err)
+	}
+
+	r.Write(sequence(0, 4))
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := r.WriteWait(ctx, sequence(0, 1)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected a deadline error waiting for room, got %v", err)
+	}
+	if r.Underruns() != 0 || r.Overruns() != 0 {
+		t.Fatalf("Expected waits not to count as underruns or overruns, got %d and %d", r.Underruns(), r.Overruns())
+	}
+
+	r.Close()
+	if n := r.Write(sequence(0, 1)); n != 0 {
+		t.Fatalf("Expected Write to a closed ring to store nothing, stored %d", n)
+	}
+	if n, err := r.ReadWait(context.Background(), make([]float32, 8)); n != 4 || err != nil {
+		t.Fatalf("Expected the 4 queued samples after Close, got %d, %v", n, err)
+	}
+}
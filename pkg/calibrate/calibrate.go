@@ -0,0 +1,246 @@
+package calibrate
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/8ff/udarp/pkg/audio"
+	"github.com/8ff/udarp/pkg/misc"
+)
+
+/*
+TX drive calibration.
+
+A steady tone is sent at increasing output levels while the rig's ALC and
+power meters are read through rigctld. Drive is clean while ALC stays
+quiet and more drive still buys more power; the level kept is the highest
+step that meets both. ALC action or flat power means the rig is
+compressing and the signal splatters over the neighbours.
+*/
+
+var (
+	ErrOverdriven = errors.New("ALC is active at the lowest level, reduce the rig's audio input gain")
+	ErrNoALC      = errors.New("rig does not report ALC")
+)
+
+// Rig is what calibration needs from the radio, txControl.TxControl fits
+type Rig interface {
+	TX() error
+	RX() error
+	GetLevel(name string) (float64, error)
+}
+
+type Params struct {
+	Start      float64       // First output level, 0..1
+	Stop       float64       // Last output level
+	Step       float64       // Increment between levels
+	ToneFreq   float64       // Hz
+	Tone       time.Duration // Length of the tone at every level
+	Settle     time.Duration // Wait after keying before reading the meters
+	Rest       time.Duration // Unkeyed time between levels
+	Samples    int           // Meter readings per level, highest ALC and average power are kept
+	MaxALC     float64       // ALC reading, 0..1, above which drive counts as too much
+	Saturation float64       // Relative power increase below which more drive is not worth it
+}
+
+// Step is the meter readings for one output level, Power is NaN when the rig has no power meter
+type Step struct {
+	Level float64 `json:"level"`
+	ALC   float64 `json:"alc"`
+	Power float64 `json:"power"`
+}
+
+type Result struct {
+	Level float64   `json:"level"`
+	Time  time.Time `json:"time"`
+	Steps []Step    `json:"steps"`
+}
+
+func (p *Params) setDefaults() {
+	if p.Start <= 0 {
+		p.Start = 0.05
+	}
+	if p.Stop <= 0 || p.Stop > 1 {
+		p.Stop = 1
+	}
+	if p.Step <= 0 {
+		p.Step = 0.05
+	}
+	if p.ToneFreq == 0 {
+		p.ToneFreq = 1500
+	}
+	if p.Tone == 0 {
+		p.Tone = 2 * time.Second
+	}
+	if p.Settle == 0 {
+		p.Settle = 500 * time.Millisecond
+	}
+	if p.Rest == 0 {
+		p.Rest = time.Second
+	}
+	if p.Samples <= 0 {
+		p.Samples = 3
+	}
+	if p.MaxALC == 0 {
+		p.MaxALC = 0.1
+	}
+	if p.Saturation == 0 {
+		p.Saturation = 0.03
+	}
+}
+
+// Run steps through the output levels and returns the highest clean one
+func Run(ctx context.Context, params Params, rig Rig, sink audio.Sink) (Result, error) {
+	params.setDefaults()
+	result := Result{Time: time.Now()}
+
+	best := -1 // Index of the highest clean step
+	for level := params.Start; level <= params.Stop+1e-9; level += params.Step {
+		step, err := measure(ctx, params, rig, sink, math.Min(level, 1))
+		if err != nil {
+			return result, err
+		}
+		result.Steps = append(result.Steps, step)
+		misc.Log("info", fmt.Sprintf("TX level %.2f: ALC %.3f power %.3f", step.Level, step.ALC, step.Power))
+
+		if step.ALC > params.MaxALC {
+			break
+		}
+		if best >= 0 {
+			previous := result.Steps[best].Power
+			if !math.IsNaN(previous) && !math.IsNaN(step.Power) && step.Power-previous < params.Saturation*previous {
+				break
+			}
+		}
+		best = len(result.Steps) - 1
+
+		select {
+		case <-ctx.Done():
+			return result, ctx.Err()
+		case <-time.After(params.Rest):
+		}
+	}
+
+	if best < 0 {
+		return result, ErrOverdriven
+	}
+	result.Level = result.Steps[best].Level
+	return result, nil
+}
+
+// measure keys the rig, plays the tone at level and reads the meters while it plays
+func measure(ctx context.Context, params Params, rig Rig, sink audio.Sink, level float64) (step Step, err error) {
+	step = Step{Level: level, Power: math.NaN()}
+
+	if err := rig.TX(); err != nil {
+		// Make sure we are not left keyed up half way
+		rig.RX()
+		return step, err
+	}
+	defer func() {
+		if rxErr := rig.RX(); err == nil {
+			err = rxErr
+		}
+	}()
+
+	played := make(chan error, 1)
+	go func() {
+		_, err := sink.Write(Tone(sink.SampleRate(), params.ToneFreq, params.Tone, level))
+		played <- err
+	}()
+
+	// Spread the readings over the middle of the tone
+	interval := (params.Tone - 2*params.Settle) / time.Duration(params.Samples)
+	if interval < 0 {
+		interval = 0
+	}
+	wait := params.Settle
+	var power float64
+	powerReadings := 0
+	for i := 0; i < params.Samples; i++ {
+		select {
+		case <-ctx.Done():
+			<-played
+			return step, ctx.Err()
+		case <-time.After(wait):
+		}
+		wait = interval
+
+		alc, err := rig.GetLevel("ALC")
+		if err != nil {
+			<-played
+			return step, fmt.Errorf("%w: %s", ErrNoALC, err)
+		}
+		step.ALC = math.Max(step.ALC, alc)
+
+		if p, err := rig.GetLevel("RFPOWER_METER"); err == nil {
+			power += p
+			powerReadings++
+		}
+	}
+	if powerReadings > 0 {
+		step.Power = power / float64(powerReadings)
+	}
+
+	if err := <-played; err != nil {
+		return step, err
+	}
+	return step, nil
+}
+
+// Tone returns a sine at level, 0..1 of full scale, as S16_LE with short ramps so keying does not click
+func Tone(sampleRate uint32, freq float64, length time.Duration, level float64) []byte {
+	samples := int(length.Seconds() * float64(sampleRate))
+	ramp := int(0.01 * float64(sampleRate))
+	wave := make([]byte, 0, samples*2)
+	for i := 0; i < samples; i++ {
+		gain := level
+		if edge := math.Min(float64(i), float64(samples-1-i)); edge < float64(ramp) {
+			gain *= 0.5 - 0.5*math.Cos(math.Pi*edge/float64(ramp))
+		}
+		x := gain * math.Sin(2*math.Pi*freq*float64(i)/float64(sampleRate))
+		wave = binary.LittleEndian.AppendUint16(wave, uint16(int16(math.Round(x*math.MaxInt16))))
+	}
+	return wave
+}
+
+// Save stores the result as JSON
+func Save(path string, result Result) error {
+	// NaN power does not survive JSON, store it as -1
+	steps := make([]Step, len(result.Steps))
+	for i, step := range result.Steps {
+		if math.IsNaN(step.Power) {
+			step.Power = -1
+		}
+		steps[i] = step
+	}
+	result.Steps = steps
+
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// Load returns the level stored by Save
+func Load(path string) (float64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	var result Result
+	if err := json.Unmarshal(data, &result); err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+	if result.Level <= 0 || result.Level > 1 {
+		return 0, fmt.Errorf("%s: level %f out of range", path, result.Level)
+	}
+	return result.Level, nil
+}
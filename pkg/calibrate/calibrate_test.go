@@ -0,0 +1,171 @@
+package calibrate_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/8ff/udarp/pkg/calibrate"
+)
+
+// A rig whose ALC starts at alcFrom drive and whose power saturates at powerFlat
+type fakeRig struct {
+	m         sync.Mutex
+	keyed     bool
+	drive     float64
+	alcFrom   float64
+	powerFlat float64
+	noPower   bool
+	keyings   int
+	txErr     error // Returned by TX, the rig may still have keyed
+	unkeys    int
+}
+
+func (r *fakeRig) TX() error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.keyed = true
+	r.keyings++
+	return r.txErr
+}
+
+func (r *fakeRig) RX() error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.keyed = false
+	r.unkeys++
+	return nil
+}
+
+func (r *fakeRig) GetLevel(name string) (float64, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if !r.keyed {
+		return 0, nil
+	}
+	switch name {
+	case "ALC":
+		return math.Max(0, r.drive-r.alcFrom), nil
+	case "RFPOWER_METER":
+		if r.noPower {
+			return 0, errors.New("RPRT -11")
+		}
+		return math.Min(r.drive, r.powerFlat) / r.powerFlat, nil
+	}
+	return 0, errors.New("RPRT -1")
+}
+
+// Sink that sets the rig drive from the peak of what it is asked to play
+type fakeSink struct{ rig *fakeRig }
+
+func (s fakeSink) SampleRate() uint32 { return 8000 }
+func (s fakeSink) Close() error       { return nil }
+
+func (s fakeSink) Write(p []byte) (int, error) {
+	peak := 0.0
+	for i := 0; i+1 < len(p); i += 2 {
+		peak = math.Max(peak, math.Abs(float64(int16(binary.LittleEndian.Uint16(p[i:])))/32767))
+	}
+	s.rig.m.Lock()
+	s.rig.drive = peak
+	s.rig.m.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	return len(p), nil
+}
+
+var fast = calibrate.Params{Tone: 40 * time.Millisecond, Settle: 5 * time.Millisecond, Rest: time.Millisecond, Step: 0.1, Start: 0.1, Samples: 2}
+
+func TestCalibrateStopsAtALC(t *testing.T) {
+	rig := &fakeRig{alcFrom: 0.55, powerFlat: 2}
+	result, err := calibrate.Run(context.Background(), fast, rig, fakeSink{rig})
+	if err != nil {
+		t.Fatalf("Run failed with error: %v", err)
+	}
+	if math.Abs(result.Level-0.6) > 1e-6 {
+		t.Fatalf("Expected level 0.6, ALC 0.05 is still under the limit, got %v", result.Level)
+	}
+	if last := result.Steps[len(result.Steps)-1]; last.ALC <= 0.1 {
+		t.Fatalf("Expected the last step to be the overdriven one, got %+v", last)
+	}
+	if rig.keyed {
+		t.Fatalf("Expected the rig to be unkeyed after calibration")
+	}
+}
+
+func TestCalibrateStopsAtSaturation(t *testing.T) {
+	rig := &fakeRig{alcFrom: 2, powerFlat: 0.4}
+	result, err := calibrate.Run(context.Background(), fast, rig, fakeSink{rig})
+	if err != nil {
+		t.Fatalf("Run failed with error: %v", err)
+	}
+	if math.Abs(result.Level-0.4) > 1e-6 {
+		t.Fatalf("Expected level 0.4 where power goes flat, got %v", result.Level)
+	}
+}
+
+func TestCalibrateWithoutPowerMeter(t *testing.T) {
+	rig := &fakeRig{alcFrom: 0.75, noPower: true}
+	result, err := calibrate.Run(context.Background(), fast, rig, fakeSink{rig})
+	if err != nil {
+		t.Fatalf("Run failed with error: %v", err)
+	}
+	if math.Abs(result.Level-0.8) > 1e-6 || !math.IsNaN(result.Steps[0].Power) {
+		t.Fatalf("Expected level 0.8 from ALC alone, got %v %+v", result.Level, result.Steps[0])
+	}
+
+	path := filepath.Join(t.TempDir(), "tx_level.json")
+	if err := calibrate.Save(path, result); err != nil {
+		t.Fatalf("Save failed with error: %v", err)
+	}
+	level, err := calibrate.Load(path)
+	if err != nil {
+		t.Fatalf("Load failed with error: %v", err)
+	}
+	if level != result.Level {
+		t.Fatalf("Expected %v back, got %v", result.Level, level)
+	}
+}
+
+func TestCalibrateOverdriven(t *testing.T) {
+	rig := &fakeRig{alcFrom: 0, powerFlat: 1}
+	_, err := calibrate.Run(context.Background(), fast, rig, fakeSink{rig})
+	if !errors.Is(err, calibrate.ErrOverdriven) {
+		t.Fatalf("Expected ErrOverdriven, got %v", err)
+	}
+	if rig.keyings != 1 || rig.keyed {
+		t.Fatalf("Expected one keying and an unkeyed rig, got %d keyings, keyed %t", rig.keyings, rig.keyed)
+	}
+}
+
+func TestCalibrateUnkeysAfterFailedTX(t *testing.T) {
+	rig := &fakeRig{alcFrom: 0.55, powerFlat: 2, txErr: errors.New("RPRT -9")}
+	if _, err := calibrate.Run(context.Background(), fast, rig, fakeSink{rig}); err == nil {
+		t.Fatalf("Expected the TX error")
+	}
+	// Callers release their TX lock in RX, it has to follow every TX
+	if rig.keyed || rig.unkeys != rig.keyings {
+		t.Fatalf("Expected RX after every TX, got %d keyings, %d unkeys, keyed %t", rig.keyings, rig.unkeys, rig.keyed)
+	}
+}
+
+func TestTone(t *testing.T) {
+	wave := calibrate.Tone(8000, 1000, 100*time.Millisecond, 0.5)
+	if len(wave) != 1600 {
+		t.Fatalf("Expected 800 samples, got %d bytes", len(wave))
+	}
+	peak := 0.0
+	for i := 0; i < len(wave); i += 2 {
+		peak = math.Max(peak, math.Abs(float64(int16(binary.LittleEndian.Uint16(wave[i:])))/32767))
+	}
+	if math.Abs(peak-0.5) > 0.01 {
+		t.Fatalf("Expected a peak of 0.5, got %v", peak)
+	}
+	if first := int16(binary.LittleEndian.Uint16(wave[2:])); first > 100 || first < -100 {
+		t.Fatalf("Expected the tone to ramp up, second sample is %d", first)
+	}
+}
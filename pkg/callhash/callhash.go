@@ -0,0 +1,177 @@
+package callhash
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+/*
+Hashed callsigns for calls that don't fit the 28 bit packing, like EA8/W1ABC/P.
+
+Same scheme as FT8: the call is padded to 11 characters from " 0-9A-Z/", read as a base 38 number,
+multiplied by a large odd constant and the top bits of the 64 bit product are the hash. The 10 and
+12 bit hashes are the top bits of the 22 bit hash.
+
+Receivers learn full calls from long form messages and keep them in a table persisted in badger, so
+later short form messages can resolve the hash back to the call, or show <...> when it is unknown.
+*/
+
+const (
+	alphabet   = " 0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ/"
+	maxLength  = 11
+	multiplier = 47055833459
+	keyPrefix  = "callhash_"
+
+	// Shown in place of a callsign that has not been heard in long form
+	Unknown = "<...>"
+)
+
+// Hash returns the bits long hash of call, bits must be 10, 12 or 22
+func Hash(call string, bits uint) (uint32, error) {
+	if bits != 10 && bits != 12 && bits != 22 {
+		return 0, fmt.Errorf("hash must be 10, 12 or 22 bits")
+	}
+
+	call = strings.ToUpper(strings.TrimSpace(call))
+	if len(call) == 0 || len(call) > maxLength {
+		return 0, fmt.Errorf("callsign %q must be 1 to %d characters", call, maxLength)
+	}
+	call += strings.Repeat(" ", maxLength-len(call))
+
+	var n uint64
+	for i := 0; i < maxLength; i++ {
+		j := strings.IndexByte(alphabet, call[i])
+		if j < 0 {
+			return 0, fmt.Errorf("callsign %q has invalid character %q", strings.TrimSpace(call), call[i])
+		}
+		n = n*38 + uint64(j)
+	}
+
+	return uint32((multiplier * n) >> (64 - bits)), nil
+}
+
+// A learned call and when it was last heard in long form
+type learned struct {
+	call  string
+	heard time.Time
+}
+
+type Table struct {
+	db     *badger.DB
+	hashes map[uint]map[uint32]learned // Keyed by hash size
+	m      sync.RWMutex
+}
+
+// Open opens the table stored at path, an empty path keeps it in memory only
+func Open(path string) (*Table, error) {
+	opts := badger.DefaultOptions(path)
+	if path == "" {
+		opts = opts.WithInMemory(true)
+	}
+	opts.Logger = nil
+
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open hash table: %w", err)
+	}
+
+	t := &Table{
+		db:     db,
+		hashes: map[uint]map[uint32]learned{10: {}, 12: {}, 22: {}},
+	}
+
+	// Load calls learned in earlier runs
+	err = db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(keyPrefix)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		// Keys come back sorted, not in the order they were learned, so collisions are settled by time
+		for it.Rewind(); it.Valid(); it.Next() {
+			var heard time.Time
+			err := it.Item().Value(func(value []byte) error {
+				return heard.UnmarshalBinary(value)
+			})
+			if err != nil {
+				return err
+			}
+			t.add(strings.TrimPrefix(string(it.Item().Key()), keyPrefix), heard)
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to load hash table: %w", err)
+	}
+
+	return t, nil
+}
+
+func (t *Table) Close() error {
+	return t.db.Close()
+}
+
+func (t *Table) add(call string, heard time.Time) {
+	for bits, table := range t.hashes {
+		hash, err := Hash(call, bits)
+		if err != nil {
+			return
+		}
+		// The most recently heard call wins on a collision
+		if existing, ok := table[hash]; ok && existing.heard.After(heard) {
+			continue
+		}
+		table[hash] = learned{call: call, heard: heard}
+	}
+}
+
+// Learn stores a full callsign heard in a long form message
+func (t *Table) Learn(call string) error {
+	call = strings.ToUpper(strings.TrimSpace(call))
+	if _, err := Hash(call, 22); err != nil {
+		return err
+	}
+
+	heard := time.Now()
+	err := t.db.Update(func(txn *badger.Txn) error {
+		value, err := heard.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		return txn.Set([]byte(keyPrefix+call), value)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store %s: %w", call, err)
+	}
+
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.add(call, heard)
+	return nil
+}
+
+// Lookup returns the callsign for a bits long hash if it has been learned
+func (t *Table) Lookup(hash uint32, bits uint) (string, bool) {
+	t.m.RLock()
+	defer t.m.RUnlock()
+
+	table, ok := t.hashes[bits]
+	if !ok {
+		return "", false
+	}
+	entry, ok := table[hash]
+	return entry.call, ok
+}
+
+// Resolve returns the callsign for a bits long hash, or Unknown
+func (t *Table) Resolve(hash uint32, bits uint) string {
+	if call, ok := t.Lookup(hash, bits); ok {
+		return call
+	}
+	return Unknown
+}
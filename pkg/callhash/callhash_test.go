@@ -0,0 +1,113 @@
+package callhash_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/8ff/udarp/pkg/callhash"
+)
+
+func TestHash(t *testing.T) {
+	h22, err := callhash.Hash("EA8/W1ABC/P", 22)
+	if err != nil {
+		t.Fatalf("Hash failed with error: %v", err)
+	}
+	if h22 >= 1<<22 {
+		t.Fatalf("22 bit hash %d is out of range", h22)
+	}
+
+	// Shorter hashes are the top bits of the 22 bit hash
+	h12, _ := callhash.Hash("ea8/w1abc/p", 12)
+	h10, _ := callhash.Hash("EA8/W1ABC/P", 10)
+	if h12 != h22>>10 || h10 != h22>>12 {
+		t.Fatalf("Hashes %d/%d/%d are not consistent", h10, h12, h22)
+	}
+
+	other, _ := callhash.Hash("EA8/W1ABD/P", 22)
+	if other == h22 {
+		t.Fatalf("Different calls should not hash the same")
+	}
+
+	for _, call := range []string{"", "EA8/W1ABC/PP", "K1-ABC"} {
+		if _, err := callhash.Hash(call, 22); err == nil {
+			t.Fatalf("Expected error hashing %q", call)
+		}
+	}
+	if _, err := callhash.Hash("K1ABC", 16); err == nil {
+		t.Fatalf("Expected error for unsupported hash size")
+	}
+}
+
+func TestTablePersists(t *testing.T) {
+	path := t.TempDir()
+
+	table, err := callhash.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed with error: %v", err)
+	}
+	h12, _ := callhash.Hash("EA8/W1ABC/P", 12)
+	if call := table.Resolve(h12, 12); call != callhash.Unknown {
+		t.Fatalf("Expected %s before learning, got %s", callhash.Unknown, call)
+	}
+	if err := table.Learn("EA8/W1ABC/P"); err != nil {
+		t.Fatalf("Learn failed with error: %v", err)
+	}
+	if call := table.Resolve(h12, 12); call != "EA8/W1ABC/P" {
+		t.Fatalf("Expected EA8/W1ABC/P, got %s", call)
+	}
+	table.Close()
+
+	// Learned calls survive a restart
+	table, err = callhash.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed with error: %v", err)
+	}
+	defer table.Close()
+	h22, _ := callhash.Hash("EA8/W1ABC/P", 22)
+	if call, ok := table.Lookup(h22, 22); !ok || call != "EA8/W1ABC/P" {
+		t.Fatalf("Expected EA8/W1ABC/P after reopening, got %s", call)
+	}
+}
+
+func TestCollisionSurvivesRestart(t *testing.T) {
+	// Find two calls sharing a 10 bit hash
+	seen := make(map[uint32]string)
+	var first, second string
+	for i := 0; first == ""; i++ {
+		call := fmt.Sprintf("K%dABC", i)
+		hash, _ := callhash.Hash(call, 10)
+		if other, ok := seen[hash]; ok {
+			first, second = other, call
+		}
+		seen[hash] = call
+	}
+	// Learn the call that sorts last first, so key order and learning order differ
+	if first < second {
+		first, second = second, first
+	}
+	hash, _ := callhash.Hash(first, 10)
+
+	path := t.TempDir()
+	table, err := callhash.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed with error: %v", err)
+	}
+	for _, call := range []string{first, second} {
+		if err := table.Learn(call); err != nil {
+			t.Fatalf("Learn failed with error: %v", err)
+		}
+	}
+	if call := table.Resolve(hash, 10); call != second {
+		t.Fatalf("Expected the latest call %s, got %s", second, call)
+	}
+	table.Close()
+
+	table, err = callhash.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed with error: %v", err)
+	}
+	defer table.Close()
+	if call := table.Resolve(hash, 10); call != second {
+		t.Fatalf("Expected the latest call %s after reopening, got %s", second, call)
+	}
+}
@@ -0,0 +1,152 @@
+package viterbi_codec
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/8ff/udarp/pkg/crc"
+	"github.com/8ff/viterbi"
+)
+
+/*
+Tail-biting convolutional coding.
+
+The encoder state only depends on the last Constraint-1 input bits, so we start the encoder in the
+state the message ends in. The encoder then finishes in the state it started in and no flush bits are
+needed, which saves Constraint-1 bits worth of airtime per frame.
+
+The decoder does not know the start state, so it uses the wrap-around Viterbi algorithm (WAVA): start
+with all states equally likely, run over the data and check whether the best path ends in the state it
+started in. If not, run over the data again starting from the path metrics of the previous pass.
+*/
+
+const defaultWrapIterations = 4
+
+// EncodeWithMode adds CRC and encodes data using params.Mode
+func EncodeWithMode(params Params, codec *viterbi.ViterbiCodec, data []byte) ([]int, error) {
+	switch params.Mode {
+	case ZeroTail:
+		return Encode(codec, data)
+	case TailBiting:
+		return EncodeTailBiting(params, codec, data)
+	default:
+		return nil, fmt.Errorf("unknown mode %d", params.Mode)
+	}
+}
+
+// DecodeWithMode decodes data using params.Mode and verifies the CRC
+func DecodeWithMode(params Params, codec *viterbi.ViterbiCodec, data []int) ([]byte, error) {
+	switch params.Mode {
+	case ZeroTail:
+		return Decode(codec, data)
+	case TailBiting:
+		return DecodeTailBiting(params, codec, data)
+	default:
+		return nil, fmt.Errorf("unknown mode %d", params.Mode)
+	}
+}
+
+// EncodeTailBiting adds CRC and encodes data without flush bits
+func EncodeTailBiting(params Params, codec *viterbi.ViterbiCodec, data []byte) ([]int, error) {
+	// Add CRC.
+	crc16 := crc.Encode16(data)
+	crcBytes := make([]byte, 2)
+	binary.BigEndian.PutUint16(crcBytes, crc16)
+	crcData := append(append([]byte{}, data...), crcBytes...)
+
+	bits := viterbi.BytesToBits(crcData)
+	if len(bits) < params.Constraint-1 {
+		return nil, fmt.Errorf("data must be at least %d bits long for tail-biting", params.Constraint-1)
+	}
+
+	// Find the state the encoder ends in, it only depends on the last Constraint-1 bits
+	state := 0
+	for i := len(bits) - (params.Constraint - 1); i < len(bits); i++ {
+		state = codec.NextState(state, int(bits[i]-'0'))
+	}
+
+	// Encode data.
+	encoded := make([]int, 0, len(bits)*len(params.Polynomials))
+	for i := 0; i < len(bits); i++ {
+		input := int(bits[i] - '0')
+		encoded = append(encoded, viterbi.BitsToInts(codec.Output(state, input))...)
+		state = codec.NextState(state, input)
+	}
+
+	return encoded, nil
+}
+
+// DecodeTailBiting runs wrap-around Viterbi over data, strips and verifies the CRC
+func DecodeTailBiting(params Params, codec *viterbi.ViterbiCodec, data []int) ([]byte, error) {
+	parityBits := len(params.Polynomials)
+	if parityBits == 0 || len(data)%parityBits != 0 {
+		return nil, fmt.Errorf("length of data must be a multiple of %d", parityBits)
+	}
+	steps := len(data) / parityBits
+	if steps < params.Constraint-1 || steps%8 != 0 {
+		return nil, fmt.Errorf("data does not hold a whole number of bytes")
+	}
+	if steps < 16+8 {
+		return nil, fmt.Errorf("data must hold at least one byte and the 16 bit CRC")
+	}
+
+	iterations := params.WrapIterations
+	if iterations <= 0 {
+		iterations = defaultWrapIterations
+	}
+
+	encoded := viterbi.IntsToBits(data)
+	pathMetrics := make([]int, 1<<(params.Constraint-1))
+	var decodedBits string
+
+	for iteration := 0; iteration < iterations; iteration++ {
+		// Normalize so metrics carried over from the last pass don't overflow
+		min := pathMetrics[0]
+		for _, pm := range pathMetrics {
+			if pm < min {
+				min = pm
+			}
+		}
+		for i := range pathMetrics {
+			pathMetrics[i] -= min
+		}
+
+		trellis := make(viterbi.Trellis, 0, steps)
+		var column []int
+		for i := 0; i < len(encoded); i += parityBits {
+			pathMetrics, column = codec.UpdatePathMetrics(encoded[i:i+parityBits], pathMetrics, trellis)
+			trellis = append(trellis, column)
+		}
+
+		// Traceback.
+		endState := 0
+		for state, pm := range pathMetrics {
+			if pm < pathMetrics[endState] {
+				endState = state
+			}
+		}
+
+		decoded := make([]byte, steps)
+		state := endState
+		for i := steps - 1; i >= 0; i-- {
+			decoded[i] = byte('0' + state>>(params.Constraint-2))
+			state = trellis[i][state]
+		}
+		decodedBits = string(decoded)
+
+		// Best path is tail-biting, no need for another pass
+		if state == endState {
+			break
+		}
+	}
+
+	// Strip and verify CRC.
+	decodedBytes := viterbi.BitsToBytes(decodedBits)
+	decodedData := decodedBytes[:len(decodedBytes)-2]
+	decodedCrc16 := binary.BigEndian.Uint16(decodedBytes[len(decodedBytes)-2:])
+	if !crc.Match16(decodedData, decodedCrc16) {
+		return nil, fmt.Errorf("CRC mismatch")
+	}
+
+	return decodedData, nil
+}
@@ -8,10 +8,21 @@ import (
 	"github.com/8ff/viterbi"
 )
 
+type Mode int
+
+const (
+	// Flush the encoder with Constraint-1 zero bits after the data, this is the default
+	ZeroTail Mode = iota
+	// Start the encoder in the state the data ends in, no flush bits are sent
+	TailBiting
+)
+
 type Params struct {
 	Constraint         int
 	Polynomials        []int
 	ReversePolynomials bool
+	Mode               Mode
+	WrapIterations     int // Max passes over the data when decoding tail-biting, defaults to 4
 }
 
 // Function that does Init and returns viterbi_codec
@@ -43,3 +43,55 @@ func TestRandomData(t *testing.T) {
 		t.Fatalf("Decoded data doesn't match input data")
 	}
 }
+
+func TestTailBiting(t *testing.T) {
+	for _, params := range []viterbi_codec.Params{
+		{Constraint: 7, Polynomials: []int{79, 109}, Mode: viterbi_codec.TailBiting},
+		{Constraint: 15, Polynomials: []int{16811, 29491}, Mode: viterbi_codec.TailBiting},
+	} {
+		// 64 bit payload like the ones in runAllTests.
+		inputData := make([]byte, 8)
+		rand.Read(inputData)
+
+		codec, err := viterbi_codec.Init(params)
+		if err != nil {
+			t.Fatalf("Init failed with error: %v", err)
+		}
+
+		encodedData, err := viterbi_codec.EncodeWithMode(params, codec, inputData)
+		if err != nil {
+			t.Fatalf("Encode failed with error: %v", err)
+		}
+
+		// No flush bits are sent.
+		if len(encodedData) != (len(inputData)+2)*8*len(params.Polynomials) {
+			t.Fatalf("Constraint %d: expected %d encoded bits, got %d", params.Constraint, (len(inputData)+2)*8*len(params.Polynomials), len(encodedData))
+		}
+
+		// Flip a couple of bits away from each other.
+		encodedData[3] ^= 1
+		encodedData[len(encodedData)/2] ^= 1
+
+		decodedData, err := viterbi_codec.DecodeWithMode(params, codec, encodedData)
+		if err != nil {
+			t.Fatalf("Constraint %d: decode failed with error: %v", params.Constraint, err)
+		}
+
+		if !bytes.Equal(inputData, decodedData) {
+			t.Fatalf("Constraint %d: decoded data doesn't match input data", params.Constraint)
+		}
+	}
+}
+
+func TestTailBitingShortInput(t *testing.T) {
+	params := viterbi_codec.Params{Constraint: 7, Polynomials: []int{79, 109}, Mode: viterbi_codec.TailBiting}
+	codec, err := viterbi_codec.Init(params)
+	if err != nil {
+		t.Fatalf("Init failed with error: %v", err)
+	}
+
+	// One byte of steps is too short to hold the CRC
+	if _, err := viterbi_codec.DecodeWithMode(params, codec, make([]int, 8*len(params.Polynomials))); err == nil {
+		t.Fatalf("Expected an error decoding fewer steps than the CRC needs")
+	}
+}
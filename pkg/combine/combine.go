@@ -0,0 +1,164 @@
+package combine
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/8ff/udarp/pkg/golay"
+)
+
+/*
+Soft combining of repeated transmissions (Chase combining).
+
+Frames that failed to decode but had good sync are kept as LLRs keyed by their header. When a repeat
+with the same key arrives its LLRs are added to the stored ones and the decoder gets another try with
+the combined values, so noise averages out while the signal adds up.
+
+The header only carries mode, type, flags and length, so two stations sending frames of the same type
+and length share a key. Before combining, the hard decisions of the new reception are compared with
+the kept ones; repeats of one frame agree on most bits while unrelated frames agree on about half,
+so a reception that agrees on less than MinAgreement of the bits replaces the kept one instead of
+being added to it.
+
+Soft values are in 0..1 like everywhere else in the decoder, they are turned into LLRs with 2v-1 and
+the combined LLRs are turned back into 0..1 by averaging. The decoders only compare distances so the
+scale doesn't matter.
+*/
+
+type Params struct {
+	MinSyncQuality float64       // Frames with worse sync are not kept for combining
+	MaxAge         time.Duration // Kept frames older than this are dropped
+	MaxEntries     int           // Max number of kept frames, oldest is dropped first
+	MinAgreement   float64       // Fraction of hard decisions that must match the kept frame to combine, 0.7 when unset
+}
+
+type entry struct {
+	llr     []float64
+	count   int
+	updated time.Time
+}
+
+type Store struct {
+	params  Params
+	entries map[string]*entry
+	m       sync.Mutex
+}
+
+func New(params Params) (*Store, error) {
+	if params.MaxEntries < 1 {
+		return nil, fmt.Errorf("max entries must be at least 1")
+	}
+	if params.MaxAge <= 0 {
+		return nil, fmt.Errorf("max age must be greater than 0")
+	}
+	if params.MinAgreement == 0 {
+		params.MinAgreement = 0.7
+	}
+	if params.MinAgreement < 0 || params.MinAgreement > 1 {
+		return nil, fmt.Errorf("min agreement must be between 0 and 1")
+	}
+	return &Store{params: params, entries: make(map[string]*entry)}, nil
+}
+
+// KeyFromHeader builds a combining key from a decoded frame header.
+// Unrelated frames can share it, Add tells them apart by comparing the soft values.
+func KeyFromHeader(h golay.Header) string {
+	return fmt.Sprintf("%d/%d/%d/%d", h.Mode, h.Type, h.Flags, h.Length)
+}
+
+// Add combines soft with any kept receptions of the same key and returns the combined soft values
+// and how many receptions went into them. The combined frame is kept if syncQuality is good enough.
+func (s *Store) Add(key string, soft []float64, syncQuality float64) ([]float64, int) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.expire()
+
+	e, ok := s.entries[key]
+	if !ok || len(e.llr) != len(soft) || !s.agrees(e.llr, soft) {
+		e = &entry{llr: make([]float64, len(soft))}
+	}
+
+	llr := make([]float64, len(soft))
+	for i, v := range soft {
+		llr[i] = e.llr[i] + 2*v - 1
+	}
+	count := e.count + 1
+
+	if syncQuality >= s.params.MinSyncQuality {
+		if !ok {
+			s.evict()
+		}
+		s.entries[key] = &entry{llr: llr, count: count, updated: time.Now()}
+	}
+
+	combined := make([]float64, len(llr))
+	for i, v := range llr {
+		combined[i] = (v/float64(count) + 1) / 2
+	}
+	return combined, count
+}
+
+// Remove drops the kept receptions of key, call it once the frame has been decoded
+func (s *Store) Remove(key string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	delete(s.entries, key)
+}
+
+// Len returns the number of kept frames
+func (s *Store) Len() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return len(s.entries)
+}
+
+// Decode combines soft with kept receptions of key and runs decode on the result
+// The kept receptions are dropped on success and updated on failure
+func (s *Store) Decode(key string, soft []float64, syncQuality float64, decode func([]float64) ([]byte, error)) ([]byte, int, error) {
+	combined, count := s.Add(key, soft, syncQuality)
+	data, err := decode(combined)
+	if err != nil {
+		return nil, count, err
+	}
+	s.Remove(key)
+	return data, count, nil
+}
+
+// agrees reports if soft is likely a repeat of the frame kept as llr
+func (s *Store) agrees(llr, soft []float64) bool {
+	compared, matching := 0, 0
+	for i, v := range soft {
+		if llr[i] == 0 || v == 0.5 {
+			continue
+		}
+		compared++
+		if (llr[i] > 0) == (v > 0.5) {
+			matching++
+		}
+	}
+	return compared == 0 || float64(matching) >= s.params.MinAgreement*float64(compared)
+}
+
+func (s *Store) expire() {
+	for key, e := range s.entries {
+		if time.Since(e.updated) > s.params.MaxAge {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// Make room for a new entry by dropping the oldest ones
+func (s *Store) evict() {
+	for len(s.entries) >= s.params.MaxEntries {
+		oldestKey := ""
+		var oldest time.Time
+		for key, e := range s.entries {
+			if oldestKey == "" || e.updated.Before(oldest) {
+				oldestKey = key
+				oldest = e.updated
+			}
+		}
+		delete(s.entries, oldestKey)
+	}
+}
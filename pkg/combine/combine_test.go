@@ -0,0 +1,128 @@
+package combine_test
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/8ff/udarp/pkg/combine"
+	"github.com/8ff/udarp/pkg/crc"
+	"github.com/8ff/udarp/pkg/euclidean"
+	"github.com/8ff/udarp/pkg/golay"
+)
+
+// Threshold soft values at 0.5 and check the crc16
+func decodeCRC16(soft []float64) ([]byte, error) {
+	bits := make([]int, len(soft))
+	for i, v := range soft {
+		if v >= 0.5 {
+			bits[i] = 1
+		}
+	}
+	if !euclidean.CheckCRC16(bits) {
+		return nil, fmt.Errorf("CRC mismatch")
+	}
+	return []byte{}, nil
+}
+
+func TestCombiningRepeats(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	store, err := combine.New(combine.Params{MinSyncQuality: 0.5, MaxAge: time.Minute, MaxEntries: 4})
+	if err != nil {
+		t.Fatalf("New failed with error: %v", err)
+	}
+
+	data := []byte("UDARP!")
+	crc16 := crc.Encode16(data)
+	frame := append(data, byte(crc16>>8), byte(crc16))
+	bits := make([]int, 0, len(frame)*8)
+	for _, b := range frame {
+		for i := 7; i >= 0; i-- {
+			bits = append(bits, int(b>>uint(i))&1)
+		}
+	}
+
+	key := combine.KeyFromHeader(golay.Header{Mode: 1, Type: 2, Length: uint16(len(frame))})
+	var count int
+	singleFailed := false
+	for rx := 0; rx < 10; rx++ {
+		soft := make([]float64, len(bits))
+		for i, bit := range bits {
+			soft[i] = float64(bit) + r.NormFloat64()*0.4
+		}
+		if _, err := decodeCRC16(soft); err != nil {
+			singleFailed = true
+		}
+
+		_, count, err = store.Decode(key, soft, 0.9, decodeCRC16)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatalf("Combining %d receptions did not decode: %v", count, err)
+	}
+	if count < 2 || !singleFailed {
+		t.Fatalf("Noise should be high enough that single receptions fail")
+	}
+	if store.Len() != 0 {
+		t.Fatalf("Decoded frame should be removed from the store")
+	}
+}
+
+func TestStoreLimits(t *testing.T) {
+	store, _ := combine.New(combine.Params{MinSyncQuality: 0.5, MaxAge: time.Minute, MaxEntries: 2})
+
+	// Bad sync is never kept
+	store.Add("a", []float64{0.1, 0.9}, 0.1)
+	if store.Len() != 0 {
+		t.Fatalf("Frame with bad sync should not be kept")
+	}
+
+	store.Add("a", []float64{0.1, 0.9}, 0.9)
+	store.Add("b", []float64{0.1, 0.9}, 0.9)
+	store.Add("c", []float64{0.1, 0.9}, 0.9)
+	if store.Len() != 2 {
+		t.Fatalf("Expected 2 kept frames, got %d", store.Len())
+	}
+
+	combined, count := store.Add("c", []float64{0.3, 0.7}, 0.9)
+	if count != 2 || math.Abs(combined[0]-0.2) > 1e-9 || math.Abs(combined[1]-0.8) > 1e-9 {
+		t.Fatalf("Expected average of 2 receptions, got %v from %d", combined, count)
+	}
+}
+
+func TestUnrelatedFramesNotCombined(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	store, _ := combine.New(combine.Params{MinSyncQuality: 0.5, MaxAge: time.Minute, MaxEntries: 2})
+
+	// Two stations, same header, different payloads
+	first := make([]float64, 256)
+	second := make([]float64, 256)
+	for i := range first {
+		first[i] = float64(r.Intn(2))
+		second[i] = float64(r.Intn(2))
+	}
+
+	store.Add("k", first, 0.9)
+	combined, count := store.Add("k", second, 0.9)
+	if count != 1 {
+		t.Fatalf("Expected an unrelated frame to start over, got %d receptions", count)
+	}
+	for i, v := range combined {
+		if v != second[i] {
+			t.Fatalf("Expected the unrelated frame to replace the kept one, bit %d is %v", i, v)
+		}
+	}
+
+	// A repeat of the second frame with a few errors still combines
+	repeat := append([]float64{}, second...)
+	for i := 0; i < 20; i++ {
+		repeat[i] = 1 - repeat[i]
+	}
+	if _, count = store.Add("k", repeat, 0.9); count != 2 {
+		t.Fatalf("Expected a repeat to combine, got %d receptions", count)
+	}
+}
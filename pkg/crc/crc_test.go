@@ -0,0 +1,150 @@
+package crc_test
+
+import (
+	"hash/crc32"
+	"math/rand"
+	"testing"
+
+	"github.com/8ff/udarp/pkg/crc"
+)
+
+var checkInput = []byte("123456789")
+
+// Bit by bit reference implementation of the Rocksoft model
+func reference(p crc.Params, data []byte) uint64 {
+	top := uint64(1) << (p.Width - 1)
+	mask := top | (top - 1)
+	reg := p.Init
+	for _, b := range data {
+		for i := 0; i < 8; i++ {
+			bit := (b >> uint(7-i)) & 1
+			if p.RefIn {
+				bit = (b >> uint(i)) & 1
+			}
+			msb := reg&top != 0
+			reg = (reg << 1) & mask
+			if msb != (bit == 1) {
+				reg ^= p.Poly
+			}
+		}
+	}
+	if p.RefOut {
+		var r uint64
+		for i := uint(0); i < p.Width; i++ {
+			if reg&(1<<i) != 0 {
+				r |= 1 << (p.Width - 1 - i)
+			}
+		}
+		reg = r
+	}
+	return (reg ^ p.XorOut) & mask
+}
+
+func TestCatalogCheckValues(t *testing.T) {
+	for _, p := range crc.Catalog {
+		table, err := crc.MakeTable(p)
+		if err != nil {
+			t.Fatalf("%s: MakeTable failed with error: %v", p.Name, err)
+		}
+		if got := table.Checksum(checkInput); got != p.Check {
+			t.Fatalf("%s: check value is %#x, expected %#x", p.Name, got, p.Check)
+		}
+		if got := reference(p, checkInput); got != p.Check {
+			t.Fatalf("%s: reference check value is %#x, expected %#x", p.Name, got, p.Check)
+		}
+	}
+}
+
+// FT8's CRC-14 is not in reveng, its check value is pinned here so the catalog can't lose or change it
+func TestFT8CheckValue(t *testing.T) {
+	p, err := crc.Lookup("CRC-14/FT8")
+	if err != nil {
+		t.Fatalf("Lookup failed with error: %v", err)
+	}
+	table, err := crc.MakeTable(p)
+	if err != nil {
+		t.Fatalf("MakeTable failed with error: %v", err)
+	}
+	if got := table.Checksum(checkInput); got != 0x0F31 {
+		t.Fatalf("Check value is %#x, expected 0x0f31", got)
+	}
+}
+
+func TestTableMatchesReference(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	params := append([]crc.Params{
+		{Name: "CRC-3/GSM", Width: 3, Poly: 0x3, XorOut: 0x7},
+		{Name: "CRC-5/USB", Width: 5, Poly: 0x05, Init: 0x1F, RefIn: true, RefOut: true, XorOut: 0x1F},
+		{Name: "CRC-7/MMC", Width: 7, Poly: 0x09},
+		{Name: "CRC-64/XZ", Width: 64, Poly: 0x42F0E1EBA9EA3693, Init: ^uint64(0), RefIn: true, RefOut: true, XorOut: ^uint64(0)},
+		{Name: "Mixed reflection", Width: 10, Poly: 0x233, Init: 0x3FF, RefIn: true},
+	}, crc.Catalog...)
+
+	for _, p := range params {
+		table, err := crc.MakeTable(p)
+		if err != nil {
+			t.Fatalf("%s: MakeTable failed with error: %v", p.Name, err)
+		}
+		for run := 0; run < 50; run++ {
+			data := make([]byte, r.Intn(64))
+			r.Read(data)
+			if got, expected := table.Checksum(data), reference(p, data); got != expected {
+				t.Fatalf("%s: table crc %#x doesn't match reference %#x", p.Name, got, expected)
+			}
+		}
+	}
+}
+
+func TestHashInterface(t *testing.T) {
+	table, err := crc.MakeTable(crc.CRC32)
+	if err != nil {
+		t.Fatalf("MakeTable failed with error: %v", err)
+	}
+
+	h := crc.New(table)
+	h.Write(checkInput[:4])
+	h.Write(checkInput[4:])
+	if h.Sum64() != uint64(crc32.ChecksumIEEE(checkInput)) {
+		t.Fatalf("Streamed crc doesn't match hash/crc32")
+	}
+
+	sum := h.Sum([]byte{0xAA})
+	if len(sum) != 5 || sum[0] != 0xAA || sum[1] != 0xCB || sum[4] != 0x26 {
+		t.Fatalf("Sum should append the crc big endian, got %x", sum)
+	}
+
+	h.Reset()
+	if h.Sum64() != table.Checksum(nil) {
+		t.Fatalf("Reset should return to the initial state")
+	}
+
+	table12, _ := crc.MakeTable(crc.CRC12DECT)
+	if crc.New(table12).Size() != 2 {
+		t.Fatalf("12 bit crc should use 2 bytes")
+	}
+}
+
+func TestLegacyFunctionsMatchCatalog(t *testing.T) {
+	for name, legacy := range map[string]uint64{
+		crc.CRC8SMBUS.Name:   uint64(crc.Encode8(checkInput)),
+		crc.CRC16MODBUS.Name: uint64(crc.Encode16(checkInput)),
+		crc.CRC32JAMCRC.Name: uint64(crc.Encode32(checkInput)),
+	} {
+		p, err := crc.Lookup(name)
+		if err != nil {
+			t.Fatalf("Lookup failed with error: %v", err)
+		}
+		if legacy != p.Check {
+			t.Fatalf("%s: legacy function gives %#x, expected %#x", name, legacy, p.Check)
+		}
+	}
+}
+
+func TestMakeTableValidation(t *testing.T) {
+	if _, err := crc.MakeTable(crc.Params{Width: 0}); err == nil {
+		t.Fatalf("Expected error for zero width")
+	}
+	if _, err := crc.MakeTable(crc.Params{Width: 8, Poly: 0x107}); err == nil {
+		t.Fatalf("Expected error for poly wider than width")
+	}
+}
@@ -0,0 +1,235 @@
+package crc
+
+import (
+	"fmt"
+	"hash"
+)
+
+/*
+Generic table driven CRC engine, parameterised the same way as the Rocksoft model / reveng catalogue.
+Widths from 1 to 64 bits are supported, the register is kept in a uint64.
+Widths under 8 bits are worked on shifted up into an 8 bit register so the same byte table can be used.
+*/
+
+type Params struct {
+	Name   string
+	Width  uint   // Width of the crc in bits
+	Poly   uint64 // Polynomial without the top bit, normal (not reflected) form
+	Init   uint64 // Initial register value
+	RefIn  bool   // Reflect input bytes
+	RefOut bool   // Reflect the final register value
+	XorOut uint64 // Value xored with the final register value
+	Check  uint64 // CRC of the ASCII string "123456789"
+}
+
+// Catalog of named standard variants, check values are from the reveng catalogue
+var (
+	CRC8SMBUS = Params{Name: "CRC-8/SMBUS", Width: 8, Poly: 0x07, Init: 0x00, Check: 0xF4}
+	// Short frames, 12 bits
+	CRC12DECT = Params{Name: "CRC-12/DECT", Width: 12, Poly: 0x80F, Init: 0x000, Check: 0xF5B}
+	CRC12UMTS = Params{Name: "CRC-12/UMTS", Width: 12, Poly: 0x80F, Init: 0x000, RefOut: true, Check: 0xDAF}
+	// FT8's crc14 run over bytes, poly 0x2757, init 0, no reflection
+	CRC14FT8  = Params{Name: "CRC-14/FT8", Width: 14, Poly: 0x2757, Init: 0x0000, Check: 0x0F31}
+	CRC14DARC = Params{Name: "CRC-14/DARC", Width: 14, Poly: 0x0805, Init: 0x0000, RefIn: true, RefOut: true, Check: 0x082D}
+	// CRC16ARC is what Encode16 was meant to be, Encode16 starts from 0xFFFF which makes it CRC16MODBUS
+	CRC16ARC    = Params{Name: "CRC-16/ARC", Width: 16, Poly: 0x8005, Init: 0x0000, RefIn: true, RefOut: true, Check: 0xBB3D}
+	CRC16MODBUS = Params{Name: "CRC-16/MODBUS", Width: 16, Poly: 0x8005, Init: 0xFFFF, RefIn: true, RefOut: true, Check: 0x4B37}
+	CRC16CCITT  = Params{Name: "CRC-16/CCITT-FALSE", Width: 16, Poly: 0x1021, Init: 0xFFFF, Check: 0x29B1}
+	CRC16KERMIT = Params{Name: "CRC-16/KERMIT", Width: 16, Poly: 0x1021, Init: 0x0000, RefIn: true, RefOut: true, Check: 0x2189}
+	CRC16XMODEM = Params{Name: "CRC-16/XMODEM", Width: 16, Poly: 0x1021, Init: 0x0000, Check: 0x31C3}
+	CRC32       = Params{Name: "CRC-32/ISO-HDLC", Width: 32, Poly: 0x04C11DB7, Init: 0xFFFFFFFF, RefIn: true, RefOut: true, XorOut: 0xFFFFFFFF, Check: 0xCBF43926}
+	CRC32C      = Params{Name: "CRC-32/ISCSI", Width: 32, Poly: 0x1EDC6F41, Init: 0xFFFFFFFF, RefIn: true, RefOut: true, XorOut: 0xFFFFFFFF, Check: 0xE3069283}
+	// Encode32 skips the final xor which makes it CRC32JAMCRC
+	CRC32JAMCRC = Params{Name: "CRC-32/JAMCRC", Width: 32, Poly: 0x04C11DB7, Init: 0xFFFFFFFF, RefIn: true, RefOut: true, XorOut: 0x00000000, Check: 0x340BC6D9}
+)
+
+var Catalog = []Params{
+	CRC8SMBUS,
+	CRC12DECT,
+	CRC12UMTS,
+	CRC14FT8,
+	CRC14DARC,
+	CRC16ARC,
+	CRC16MODBUS,
+	CRC16CCITT,
+	CRC16KERMIT,
+	CRC16XMODEM,
+	CRC32,
+	CRC32C,
+	CRC32JAMCRC,
+}
+
+// Lookup returns the catalog entry with the given name
+func Lookup(name string) (Params, error) {
+	for _, p := range Catalog {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return Params{}, fmt.Errorf("crc %s not found in catalog", name)
+}
+
+type Table struct {
+	params Params
+	mask   uint64 // Mask of Width bits
+	shift  uint   // How far the register is shifted up for widths under 8 bits
+	table  [256]uint64
+}
+
+// MakeTable validates params and precomputes the byte table
+func MakeTable(params Params) (*Table, error) {
+	if params.Width < 1 || params.Width > 64 {
+		return nil, fmt.Errorf("width must be between 1 and 64")
+	}
+
+	t := &Table{params: params, mask: widthMask(params.Width)}
+	if params.Poly&^t.mask != 0 || params.Init&^t.mask != 0 || params.XorOut&^t.mask != 0 {
+		return nil, fmt.Errorf("poly, init and xorout must fit in %d bits", params.Width)
+	}
+
+	if params.RefIn {
+		poly := reflect(params.Poly, params.Width)
+		for i := range t.table {
+			c := uint64(i)
+			for bit := 0; bit < 8; bit++ {
+				if c&1 != 0 {
+					c = (c >> 1) ^ poly
+				} else {
+					c >>= 1
+				}
+			}
+			t.table[i] = c
+		}
+		return t, nil
+	}
+
+	if params.Width < 8 {
+		t.shift = 8 - params.Width
+	}
+	width := params.Width + t.shift
+	top := uint64(1) << (width - 1)
+	poly := params.Poly << t.shift
+	regMask := widthMask(width)
+	for i := range t.table {
+		c := uint64(i) << (width - 8)
+		for bit := 0; bit < 8; bit++ {
+			if c&top != 0 {
+				c = (c << 1) ^ poly
+			} else {
+				c <<= 1
+			}
+		}
+		t.table[i] = c & regMask
+	}
+	return t, nil
+}
+
+// Params returns the parameters the table was built from
+func (t *Table) Params() Params {
+	return t.params
+}
+
+// init returns the register value before any data is written
+func (t *Table) init() uint64 {
+	if t.params.RefIn {
+		return reflect(t.params.Init, t.params.Width)
+	}
+	return t.params.Init << t.shift
+}
+
+// update feeds data through the register
+func (t *Table) update(crc uint64, data []byte) uint64 {
+	if t.params.RefIn {
+		for _, b := range data {
+			crc = t.table[byte(crc)^b] ^ (crc >> 8)
+		}
+		return crc
+	}
+
+	width := t.params.Width + t.shift
+	regMask := widthMask(width)
+	for _, b := range data {
+		crc = (t.table[byte(crc>>(width-8))^b] ^ (crc << 8)) & regMask
+	}
+	return crc
+}
+
+// final turns the register value into the crc
+func (t *Table) final(crc uint64) uint64 {
+	if t.params.RefIn {
+		if !t.params.RefOut {
+			crc = reflect(crc, t.params.Width)
+		}
+	} else {
+		crc >>= t.shift
+		if t.params.RefOut {
+			crc = reflect(crc, t.params.Width)
+		}
+	}
+	return (crc ^ t.params.XorOut) & t.mask
+}
+
+// Checksum returns the crc of data
+func (t *Table) Checksum(data []byte) uint64 {
+	return t.final(t.update(t.init(), data))
+}
+
+type digest struct {
+	t   *Table
+	crc uint64
+}
+
+// New returns a hash.Hash64 computing the crc described by t
+func New(t *Table) hash.Hash64 {
+	d := &digest{t: t}
+	d.Reset()
+	return d
+}
+
+func (d *digest) Write(p []byte) (int, error) {
+	d.crc = d.t.update(d.crc, p)
+	return len(p), nil
+}
+
+// Sum appends the crc as big endian bytes, using as many bytes as needed to hold Width bits
+func (d *digest) Sum(in []byte) []byte {
+	s := d.Sum64()
+	for i := d.Size() - 1; i >= 0; i-- {
+		in = append(in, byte(s>>(uint(i)*8)))
+	}
+	return in
+}
+
+func (d *digest) Sum64() uint64 {
+	return d.t.final(d.crc)
+}
+
+func (d *digest) Reset() {
+	d.crc = d.t.init()
+}
+
+func (d *digest) Size() int {
+	return int(d.t.params.Width+7) / 8
+}
+
+func (d *digest) BlockSize() int {
+	return 1
+}
+
+func widthMask(width uint) uint64 {
+	if width >= 64 {
+		return ^uint64(0)
+	}
+	return (uint64(1) << width) - 1
+}
+
+// Reverse the lowest width bits of v
+func reflect(v uint64, width uint) uint64 {
+	var r uint64
+	for i := uint(0); i < width; i++ {
+		if v&(1<<i) != 0 {
+			r |= 1 << (width - 1 - i)
+		}
+	}
+	return r
+}
@@ -0,0 +1,297 @@
+package email
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/8ff/udarp/pkg/frame"
+	"github.com/8ff/udarp/pkg/misc"
+	"github.com/8ff/udarp/pkg/pack"
+)
+
+/*
+Email gateway between UDARP and SMTP.
+
+Over the air email travels in frame.TypeEmail frames. The frame addresses only hold callsigns so the
+email address is carried in the payload:
+	0      Address length
+	1..n   Address, the recipient for frames sent to the gateway, the sender for frames from it
+	n+1    Subject length
+	       Subject, body until the end of the payload
+
+Inbound, a station sends an email frame to the gateway callsign and the gateway delivers it over SMTP
+from CALLSIGN@Domain. Outbound, mail submitted to CALLSIGN@Domain over SMTP is turned into an email frame
+from the gateway to CALLSIGN and queued for TX.
+
+Both directions are limited by the allowlist, it holds callsigns for inbound and addresses or @domain
+entries for outbound. Outbound senders are taken from MAIL FROM and the submission server has no AUTH,
+so it only listens on loopback. Put a local MTA that authenticates users in front of it.
+*/
+
+const MaxField = 0xFF
+
+var (
+	ErrMalformed  = errors.New("malformed email payload")
+	ErrNotAllowed = errors.New("sender not allowed")
+)
+
+type Message struct {
+	Address string // Email address on the internet side
+	Subject string
+	Body    string
+}
+
+func (m Message) MarshalBinary() ([]byte, error) {
+	if len(m.Address) > MaxField || len(m.Subject) > MaxField {
+		return nil, fmt.Errorf("address and subject must be at most %d bytes", MaxField)
+	}
+	if _, err := mail.ParseAddress(m.Address); err != nil {
+		return nil, fmt.Errorf("invalid address %q: %w", m.Address, err)
+	}
+	data := make([]byte, 0, 2+len(m.Address)+len(m.Subject)+len(m.Body))
+	data = append(data, byte(len(m.Address)))
+	data = append(data, m.Address...)
+	data = append(data, byte(len(m.Subject)))
+	data = append(data, m.Subject...)
+	return append(data, m.Body...), nil
+}
+
+func (m *Message) UnmarshalBinary(data []byte) error {
+	var parsed Message
+	for _, field := range []*string{&parsed.Address, &parsed.Subject} {
+		if len(data) < 1 || len(data) < 1+int(data[0]) {
+			return fmt.Errorf("%w: %d bytes", ErrMalformed, len(data))
+		}
+		*field = string(data[1 : 1+int(data[0])])
+		data = data[1+int(data[0]):]
+	}
+	if _, err := mail.ParseAddress(parsed.Address); err != nil {
+		return fmt.Errorf("%w: address %q", ErrMalformed, parsed.Address)
+	}
+	parsed.Body = string(data)
+	*m = parsed
+	return nil
+}
+
+type Params struct {
+	Callsign     string   // Callsign of the gateway station
+	Domain       string   // Mail domain, callsigns are reachable as CALLSIGN@Domain
+	SMTPRelay    string   // host:port of the relay used for inbound delivery
+	SMTPUsername string   // Optional, PLAIN auth against the relay
+	SMTPPassword string   // Optional
+	ListenAddr   string   // Loopback address the submission server listens on, empty disables outbound
+	Allowlist    []string // Callsigns, addresses or @domain entries allowed to use the gateway
+}
+
+type Gateway struct {
+	params Params
+	queue  func(frame.Frame) error
+	server *Server
+
+	allowed map[string]bool
+
+	m        sync.Mutex
+	sequence uint16
+}
+
+// New returns a gateway, queue is called with every outbound frame that should be transmitted
+func New(params Params, queue func(frame.Frame) error) (*Gateway, error) {
+	params.Callsign = strings.ToUpper(params.Callsign)
+	params.Domain = strings.ToLower(params.Domain)
+	if _, err := pack.PackCallsign(params.Callsign); err != nil {
+		return nil, fmt.Errorf("invalid gateway callsign: %w", err)
+	}
+	if params.Domain == "" {
+		return nil, fmt.Errorf("domain is required")
+	}
+	if params.SMTPRelay == "" {
+		return nil, fmt.Errorf("smtp relay is required")
+	}
+	if queue == nil {
+		return nil, fmt.Errorf("queue is required")
+	}
+	if len(params.Allowlist) == 0 {
+		return nil, fmt.Errorf("allowlist is empty, the gateway would refuse everything")
+	}
+
+	g := &Gateway{params: params, queue: queue, allowed: make(map[string]bool)}
+	for _, entry := range params.Allowlist {
+		g.allowed[strings.ToLower(strings.TrimSpace(entry))] = true
+	}
+	return g, nil
+}
+
+// Start starts the submission server if ListenAddr is set
+func (g *Gateway) Start() error {
+	if g.params.ListenAddr == "" {
+		return nil
+	}
+	// Anyone who can connect can claim an allowed sender, keep it off the network
+	if !loopback(g.params.ListenAddr) {
+		return fmt.Errorf("submission server must listen on a loopback address, not %s", g.params.ListenAddr)
+	}
+	g.server = &Server{Hostname: g.params.Domain, Handler: g.submit, CheckRecipient: g.checkRecipient}
+	return g.server.Listen(g.params.ListenAddr)
+}
+
+// loopback reports whether addr only accepts connections from this machine
+func loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Stop stops the submission server
+func (g *Gateway) Stop() error {
+	if g.server == nil {
+		return nil
+	}
+	return g.server.Close()
+}
+
+// Server returns the submission server, nil until Start is called with ListenAddr set
+func (g *Gateway) Server() *Server {
+	return g.server
+}
+
+// Allowed reports whether a callsign or email address is on the allowlist
+func (g *Gateway) Allowed(sender string) bool {
+	sender = strings.ToLower(sender)
+	if g.allowed[sender] {
+		return true
+	}
+	if at := strings.LastIndexByte(sender, '@'); at >= 0 {
+		return g.allowed[sender[at:]]
+	}
+	return false
+}
+
+// Handle delivers an email frame sent to the gateway over SMTP.
+// Frames that are not email for this station return false.
+func (g *Gateway) Handle(f frame.Frame) (bool, error) {
+	if f.Type != frame.TypeEmail || !strings.EqualFold(f.Destination, g.params.Callsign) {
+		return false, nil
+	}
+	if !g.Allowed(f.Source) {
+		return true, fmt.Errorf("%w: %s", ErrNotAllowed, f.Source)
+	}
+
+	var msg Message
+	if err := msg.UnmarshalBinary(f.Payload); err != nil {
+		return true, err
+	}
+	to, err := mail.ParseAddress(msg.Address)
+	if err != nil {
+		return true, fmt.Errorf("%w: address %q", ErrMalformed, msg.Address)
+	}
+	// The subject comes from the air, line breaks in it would start new headers
+	subject := strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ").Replace(msg.Subject)
+
+	from := fmt.Sprintf("%s@%s", strings.ToUpper(f.Source), g.params.Domain)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", to.String())
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "X-UDARP-Gateway: %s\r\n", g.params.Callsign)
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	buf.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+	buf.WriteString("\r\n")
+
+	var auth smtp.Auth
+	if g.params.SMTPUsername != "" {
+		host, _, _ := strings.Cut(g.params.SMTPRelay, ":")
+		auth = smtp.PlainAuth("", g.params.SMTPUsername, g.params.SMTPPassword, host)
+	}
+	if err := smtp.SendMail(g.params.SMTPRelay, auth, from, []string{to.Address}, buf.Bytes()); err != nil {
+		return true, fmt.Errorf("failed to deliver mail to %s: %w", to.Address, err)
+	}
+
+	misc.Log("info", fmt.Sprintf("Delivered mail from %s to %s", f.Source, to.Address))
+	return true, nil
+}
+
+// callsignFor returns the callsign a recipient address belongs to
+func (g *Gateway) callsignFor(to string) (string, error) {
+	local, domain, ok := strings.Cut(to, "@")
+	if !ok || !strings.EqualFold(domain, g.params.Domain) {
+		return "", fmt.Errorf("relaying to %s is not allowed", to)
+	}
+	if _, err := pack.PackCallsign(local); err != nil {
+		return "", fmt.Errorf("%s is not a callsign", local)
+	}
+	return strings.ToUpper(local), nil
+}
+
+func (g *Gateway) checkRecipient(to string) error {
+	_, err := g.callsignFor(to)
+	return err
+}
+
+// submit turns a mail submitted over SMTP into one frame per recipient
+func (g *Gateway) submit(from string, to []string, data []byte) error {
+	if !g.Allowed(from) {
+		misc.Log("warning", fmt.Sprintf("Rejected mail from %s", from))
+		return fmt.Errorf("%w: %s", ErrNotAllowed, from)
+	}
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to parse message: %w", err)
+	}
+	body, err := io.ReadAll(parsed.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read body: %w", err)
+	}
+
+	subject := parsed.Header.Get("Subject")
+	if len(subject) > MaxField {
+		subject = subject[:MaxField]
+	}
+	msg := Message{
+		Address: from,
+		Subject: subject,
+		Body:    strings.TrimRight(strings.ReplaceAll(string(body), "\r\n", "\n"), "\n"),
+	}
+	payload, err := msg.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if len(payload) > frame.MaxPayload {
+		return fmt.Errorf("message is larger than %d bytes", frame.MaxPayload)
+	}
+
+	for _, rcpt := range to {
+		callsign, err := g.callsignFor(rcpt)
+		if err != nil {
+			return err
+		}
+		if err := g.queue(frame.New(frame.TypeEmail, g.params.Callsign, callsign, g.nextSequence(), payload)); err != nil {
+			return fmt.Errorf("failed to queue message for %s: %w", callsign, err)
+		}
+		misc.Log("info", fmt.Sprintf("Queued mail from %s to %s", from, callsign))
+	}
+	return nil
+}
+
+func (g *Gateway) nextSequence() uint16 {
+	g.m.Lock()
+	defer g.m.Unlock()
+	g.sequence++
+	return g.sequence
+}
@@ -0,0 +1,234 @@
+package email_test
+
+import (
+	"bufio"
+	"errors"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/8ff/udarp/pkg/email"
+	"github.com/8ff/udarp/pkg/frame"
+)
+
+type delivery struct {
+	from string
+	to   []string
+	data string
+}
+
+// relay starts an in-process SMTP server standing in for the outgoing mail relay
+func relay(t *testing.T) (*email.Server, func() []delivery) {
+	var m sync.Mutex
+	var delivered []delivery
+
+	s := &email.Server{Handler: func(from string, to []string, data []byte) error {
+		m.Lock()
+		defer m.Unlock()
+		delivered = append(delivered, delivery{from, to, string(data)})
+		return nil
+	}}
+	if err := s.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("Listen failed with error: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	return s, func() []delivery {
+		m.Lock()
+		defer m.Unlock()
+		return append([]delivery{}, delivered...)
+	}
+}
+
+func newGateway(t *testing.T, relayAddr string, queue func(frame.Frame) error) *email.Gateway {
+	g, err := email.New(email.Params{
+		Callsign:   "W1GW",
+		Domain:     "udarp.example",
+		SMTPRelay:  relayAddr,
+		ListenAddr: "127.0.0.1:0",
+		Allowlist:  []string{"K1ABC", "friend@example.com", "@club.example"},
+	}, queue)
+	if err != nil {
+		t.Fatalf("New failed with error: %v", err)
+	}
+	if err := g.Start(); err != nil {
+		t.Fatalf("Start failed with error: %v", err)
+	}
+	t.Cleanup(func() { g.Stop() })
+	return g
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	msg := email.Message{Address: "friend@example.com", Subject: "Hi", Body: "73\nde K1ABC"}
+	data, err := msg.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed with error: %v", err)
+	}
+	var parsed email.Message
+	if err := parsed.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary failed with error: %v", err)
+	}
+	if parsed != msg {
+		t.Fatalf("Round trip gave %+v, expected %+v", parsed, msg)
+	}
+	if err := parsed.UnmarshalBinary(data[:3]); !errors.Is(err, email.ErrMalformed) {
+		t.Fatalf("Expected ErrMalformed for truncated payload, got %v", err)
+	}
+}
+
+func TestInboundDelivery(t *testing.T) {
+	r, delivered := relay(t)
+	g := newGateway(t, r.Addr().String(), func(frame.Frame) error { return nil })
+
+	payload, _ := email.Message{Address: "friend@example.com", Subject: "From the field", Body: "All well\nK1ABC"}.MarshalBinary()
+	handled, err := g.Handle(frame.New(frame.TypeEmail, "K1ABC", "W1GW", 1, payload))
+	if !handled || err != nil {
+		t.Fatalf("Handle returned %v %v", handled, err)
+	}
+
+	got := delivered()
+	if len(got) != 1 {
+		t.Fatalf("Expected one delivery, got %d", len(got))
+	}
+	if got[0].from != "K1ABC@udarp.example" || len(got[0].to) != 1 || got[0].to[0] != "friend@example.com" {
+		t.Fatalf("Unexpected envelope: %+v", got[0])
+	}
+	if !strings.Contains(got[0].data, "Subject: From the field\n") || !strings.Contains(got[0].data, "All well\nK1ABC") {
+		t.Fatalf("Unexpected message: %q", got[0].data)
+	}
+
+	// Stations not on the allowlist can't send mail
+	handled, err = g.Handle(frame.New(frame.TypeEmail, "KA1XYZ", "W1GW", 2, payload))
+	if !handled || !errors.Is(err, email.ErrNotAllowed) {
+		t.Fatalf("Expected ErrNotAllowed, got %v %v", handled, err)
+	}
+	if handled, _ := g.Handle(frame.New(frame.TypeEmail, "K1ABC", "W2GW", 3, payload)); handled {
+		t.Fatalf("Frames for other stations should be ignored")
+	}
+	if len(delivered()) != 1 {
+		t.Fatalf("Rejected frames should not be delivered")
+	}
+}
+
+func TestInboundHeaders(t *testing.T) {
+	r, delivered := relay(t)
+	g := newGateway(t, r.Addr().String(), func(frame.Frame) error { return nil })
+
+	payload, _ := email.Message{Address: "Joe <joe@example.org>", Subject: "Hi\r\nBcc: victim@example.net\nGrüße"}.MarshalBinary()
+	if handled, err := g.Handle(frame.New(frame.TypeEmail, "K1ABC", "W1GW", 1, payload)); !handled || err != nil {
+		t.Fatalf("Handle returned %v %v", handled, err)
+	}
+
+	got := delivered()
+	if len(got) != 1 || len(got[0].to) != 1 || got[0].to[0] != "joe@example.org" {
+		t.Fatalf("Expected the bare address as recipient, got %+v", got)
+	}
+	parsed, err := mail.ReadMessage(strings.NewReader(got[0].data))
+	if err != nil {
+		t.Fatalf("Delivered message does not parse: %v", err)
+	}
+	if parsed.Header.Get("Bcc") != "" {
+		t.Fatalf("Subject injected a header: %q", got[0].data)
+	}
+	if to := parsed.Header.Get("To"); to != `"Joe" <joe@example.org>` {
+		t.Fatalf("Unexpected To header %q", to)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil || subject != "Hi Bcc: victim@example.net Grüße" {
+		t.Fatalf("Unexpected subject %q, %v", subject, err)
+	}
+}
+
+func TestSubmissionLoopbackOnly(t *testing.T) {
+	for _, addr := range []string{":0", "0.0.0.0:0", "[::]:0", "192.0.2.1:25"} {
+		g, err := email.New(email.Params{Callsign: "W1GW", Domain: "udarp.example", SMTPRelay: "127.0.0.1:25", ListenAddr: addr, Allowlist: []string{"K1ABC"}}, func(frame.Frame) error { return nil })
+		if err != nil {
+			t.Fatalf("New failed with error: %v", err)
+		}
+		if err := g.Start(); err == nil {
+			g.Stop()
+			t.Fatalf("Start should refuse to listen on %s", addr)
+		}
+	}
+}
+
+func TestServerCloseDropsSessions(t *testing.T) {
+	s := &email.Server{}
+	if err := s.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("Listen failed with error: %v", err)
+	}
+
+	// An idle client must not hold up Close until the read timeout
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed with error: %v", err)
+	}
+	defer conn.Close()
+	if _, err := textproto.NewReader(bufio.NewReader(conn)).ReadLine(); err != nil {
+		t.Fatalf("Expected a greeting, got %v", err)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		s.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Close is waiting on an idle session")
+	}
+}
+
+func TestOutboundSubmission(t *testing.T) {
+	r, _ := relay(t)
+	var m sync.Mutex
+	var queued []frame.Frame
+	g := newGateway(t, r.Addr().String(), func(f frame.Frame) error {
+		m.Lock()
+		defer m.Unlock()
+		queued = append(queued, f)
+		return nil
+	})
+	addr := g.Server().Addr().String()
+
+	body := "Subject: Sked\r\n\r\nSee you on 14.074\r\n"
+	err := smtp.SendMail(addr, nil, "op@club.example", []string{"k1abc@udarp.example", "KA1XYZ@udarp.example"}, []byte(body))
+	if err != nil {
+		t.Fatalf("SendMail failed with error: %v", err)
+	}
+
+	if len(queued) != 2 {
+		t.Fatalf("Expected a frame per recipient, got %d", len(queued))
+	}
+	f := queued[0]
+	if f.Type != frame.TypeEmail || f.Source != "W1GW" || f.Destination != "K1ABC" || queued[1].Destination != "KA1XYZ" {
+		t.Fatalf("Unexpected frame header: %+v", f.Header)
+	}
+	if _, err := f.MarshalBinary(); err != nil {
+		t.Fatalf("Queued frame does not marshal: %v", err)
+	}
+	var msg email.Message
+	if err := msg.UnmarshalBinary(f.Payload); err != nil {
+		t.Fatalf("UnmarshalBinary failed with error: %v", err)
+	}
+	if msg.Address != "op@club.example" || msg.Subject != "Sked" || msg.Body != "See you on 14.074" {
+		t.Fatalf("Unexpected message: %+v", msg)
+	}
+
+	if err := smtp.SendMail(addr, nil, "spam@example.net", []string{"K1ABC@udarp.example"}, []byte(body)); err == nil {
+		t.Fatalf("Senders not on the allowlist should be rejected")
+	}
+	if err := smtp.SendMail(addr, nil, "friend@example.com", []string{"someone@example.org"}, []byte(body)); err == nil {
+		t.Fatalf("Relaying to other domains should be rejected")
+	}
+	if len(queued) != 2 {
+		t.Fatalf("Rejected mail should not be queued, got %d frames", len(queued))
+	}
+}
@@ -0,0 +1,233 @@
+package email
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Minimal SMTP server, just enough to accept submissions from a local MTA or mail client.
+No TLS or AUTH, it is meant to listen on localhost or behind a relay that handles those.
+*/
+
+const maxMessageSize = 1 << 20
+
+// Handler is called for every accepted message, returning an error rejects it
+type Handler func(from string, to []string, data []byte) error
+
+// RecipientCheck is called for every RCPT TO, returning an error rejects the recipient
+type RecipientCheck func(to string) error
+
+type Server struct {
+	Hostname       string
+	Handler        Handler
+	CheckRecipient RecipientCheck
+	Timeout        time.Duration
+
+	listener net.Listener
+	wg       sync.WaitGroup
+	m        sync.Mutex
+	conns    map[net.Conn]struct{} // Open sessions, closed by Close
+	closed   bool
+}
+
+// Listen starts accepting connections on addr, use Addr to get the port when addr ends in :0
+func (s *Server) Listen(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	s.listener = l
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			if !s.track(conn) {
+				conn.Close()
+				return
+			}
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				defer s.untrack(conn)
+				s.serve(conn)
+			}()
+		}
+	}()
+	return nil
+}
+
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// track registers an open session, false means the server is closing
+func (s *Server) track(conn net.Conn) bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.closed {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]struct{})
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	delete(s.conns, conn)
+}
+
+// Close stops the listener, drops open sessions and waits for them to return
+func (s *Server) Close() error {
+	s.m.Lock()
+	if s.closed {
+		s.m.Unlock()
+		return nil
+	}
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.m.Unlock()
+
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close()
+
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Minute
+	}
+	hostname := s.Hostname
+	if hostname == "" {
+		hostname = "localhost"
+	}
+
+	tp := textproto.NewConn(conn)
+	reply := func(code int, msg string) error {
+		conn.SetWriteDeadline(time.Now().Add(timeout))
+		return tp.PrintfLine("%d %s", code, msg)
+	}
+
+	if reply(220, hostname+" UDARP gateway ready") != nil {
+		return
+	}
+
+	var from string
+	var to []string
+	haveFrom := false
+	for {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			err = reply(250, hostname)
+		case "EHLO":
+			err = tp.PrintfLine("250-%s\r\n250-SIZE %d\r\n250 8BITMIME", hostname, maxMessageSize)
+		case "MAIL":
+			addr, ok := parsePath(arg, "FROM:")
+			if !ok {
+				err = reply(501, "Syntax: MAIL FROM:<address>")
+				break
+			}
+			from, to, haveFrom = addr, nil, true
+			err = reply(250, "OK")
+		case "RCPT":
+			if !haveFrom {
+				err = reply(503, "Need MAIL before RCPT")
+				break
+			}
+			addr, ok := parsePath(arg, "TO:")
+			if !ok {
+				err = reply(501, "Syntax: RCPT TO:<address>")
+				break
+			}
+			if s.CheckRecipient != nil {
+				if checkErr := s.CheckRecipient(addr); checkErr != nil {
+					err = reply(550, checkErr.Error())
+					break
+				}
+			}
+			to = append(to, addr)
+			err = reply(250, "OK")
+		case "DATA":
+			if len(to) == 0 {
+				err = reply(503, "Need RCPT before DATA")
+				break
+			}
+			if err = reply(354, "End data with <CR><LF>.<CR><LF>"); err != nil {
+				break
+			}
+			var data []byte
+			data, err = io.ReadAll(io.LimitReader(tp.DotReader(), maxMessageSize+1))
+			if err != nil {
+				return
+			}
+			switch {
+			case len(data) > maxMessageSize:
+				err = reply(552, "Message too large")
+			case s.Handler == nil:
+				err = reply(250, "OK")
+			default:
+				if handleErr := s.Handler(from, to, data); handleErr != nil {
+					err = reply(554, handleErr.Error())
+				} else {
+					err = reply(250, "OK queued")
+				}
+			}
+			from, to, haveFrom = "", nil, false
+		case "RSET":
+			from, to, haveFrom = "", nil, false
+			err = reply(250, "OK")
+		case "NOOP":
+			err = reply(250, "OK")
+		case "QUIT":
+			reply(221, "Bye")
+			return
+		default:
+			err = reply(502, "Command not implemented")
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// parsePath extracts the address from "FROM:<address> params"
+func parsePath(arg, prefix string) (string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", false
+	}
+	arg = strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(arg, "<") {
+		return "", false
+	}
+	end := strings.IndexByte(arg, '>')
+	if end < 0 {
+		return "", false
+	}
+	return arg[1:end], true
+}
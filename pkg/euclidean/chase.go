@@ -0,0 +1,187 @@
+package euclidean
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/8ff/udarp/pkg/crc"
+)
+
+/*
+Bounded list (Chase) decoding.
+
+Instead of expanding every variation around the mean like HybridDecodeV4 does, we take the hard
+decision, rank bits by how close they are to the mean and flip only the least reliable ones.
+Flip patterns are produced lazily in order of increasing total reliability, so the most likely
+candidates are tried first and memory only grows with the number of candidates actually pulled.
+*/
+
+// Max number of least reliable bits we consider for flipping, patterns are stored in a uint64
+const MaxLeastReliable = 64
+
+type ChaseParams struct {
+	LeastReliable int // Number of least reliable bits considered for flipping
+	MaxCandidates int // Max number of candidates to try, including the hard decision
+}
+
+type flipPattern struct {
+	cost float64 // Sum of reliabilities of the flipped bits
+	last int     // Highest index (into the ranked list) flipped in this pattern
+	mask uint64  // Bitmask of ranked indexes to flip
+}
+
+type patternHeap []flipPattern
+
+func (h patternHeap) Len() int            { return len(h) }
+func (h patternHeap) Less(i, j int) bool  { return h[i].cost < h[j].cost }
+func (h patternHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *patternHeap) Push(x interface{}) { *h = append(*h, x.(flipPattern)) }
+func (h *patternHeap) Pop() interface{} {
+	old := *h
+	p := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return p
+}
+
+// CandidateIterator yields the hard decision followed by flip candidates in order of increasing cost
+type CandidateIterator struct {
+	hard        []int
+	positions   []int     // Bit positions ranked from least to most reliable
+	reliability []float64 // Reliability of each ranked position
+	pending     patternHeap
+	started     bool
+	remaining   int
+	candidate   []int
+}
+
+// NewCandidateIterator ranks the bits of data by reliability and prepares lazy candidate generation
+func NewCandidateIterator(data []float64, params ChaseParams) (*CandidateIterator, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("data is empty")
+	}
+	if params.LeastReliable < 0 || params.LeastReliable > MaxLeastReliable {
+		return nil, fmt.Errorf("least reliable bits must be between 0 and %d", MaxLeastReliable)
+	}
+	if params.MaxCandidates < 1 {
+		return nil, fmt.Errorf("max candidates must be at least 1")
+	}
+
+	sum := 0.0
+	for _, v := range data {
+		sum += v
+	}
+	mean := sum / float64(len(data))
+
+	it := &CandidateIterator{
+		hard:      make([]int, len(data)),
+		positions: make([]int, len(data)),
+		remaining: params.MaxCandidates,
+		candidate: make([]int, len(data)),
+	}
+
+	for i, v := range data {
+		if v >= mean {
+			it.hard[i] = 1
+		}
+		it.positions[i] = i
+	}
+
+	// Rank positions by distance to the mean, closest first
+	sort.SliceStable(it.positions, func(a, b int) bool {
+		return math.Abs(data[it.positions[a]]-mean) < math.Abs(data[it.positions[b]]-mean)
+	})
+
+	leastReliable := params.LeastReliable
+	if leastReliable > len(data) {
+		leastReliable = len(data)
+	}
+	it.positions = it.positions[:leastReliable]
+	it.reliability = make([]float64, leastReliable)
+	for i, pos := range it.positions {
+		it.reliability[i] = math.Abs(data[pos] - mean)
+	}
+
+	return it, nil
+}
+
+// Next returns the next candidate, the returned slice is reused between calls so copy it if needed
+func (it *CandidateIterator) Next() ([]int, bool) {
+	if it.remaining == 0 {
+		return nil, false
+	}
+
+	var mask uint64
+	if !it.started {
+		// First candidate is the hard decision, seed the heap with flipping the least reliable bit
+		it.started = true
+		if len(it.positions) > 0 {
+			heap.Push(&it.pending, flipPattern{cost: it.reliability[0], last: 0, mask: 1})
+		}
+	} else {
+		if it.pending.Len() == 0 {
+			return nil, false
+		}
+		p := heap.Pop(&it.pending).(flipPattern)
+		mask = p.mask
+
+		// Each pattern has two successors: also flip the next bit, or move the last flip to the next bit
+		// Together they enumerate every subset exactly once in non decreasing cost
+		if next := p.last + 1; next < len(it.positions) {
+			heap.Push(&it.pending, flipPattern{cost: p.cost + it.reliability[next], last: next, mask: p.mask | 1<<uint(next)})
+			heap.Push(&it.pending, flipPattern{cost: p.cost - it.reliability[p.last] + it.reliability[next], last: next, mask: (p.mask &^ (1 << uint(p.last))) | 1<<uint(next)})
+		}
+	}
+
+	copy(it.candidate, it.hard)
+	for i, pos := range it.positions {
+		if mask&(1<<uint(i)) != 0 {
+			it.candidate[pos] ^= 1
+		}
+	}
+	it.remaining--
+
+	return it.candidate, true
+}
+
+// ListDecode tries candidates in order and returns the first one accepted by check along with the number of attempts
+func ListDecode(data []float64, params ChaseParams, check func([]int) bool) ([]int, int, error) {
+	it, err := NewCandidateIterator(data, params)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	attempts := 0
+	for {
+		candidate, ok := it.Next()
+		if !ok {
+			break
+		}
+		attempts++
+		if check(candidate) {
+			output := make([]int, len(candidate))
+			copy(output, candidate)
+			return output, attempts, nil
+		}
+	}
+
+	return nil, attempts, fmt.Errorf("no candidate passed the check after %d attempts", attempts)
+}
+
+// CheckCRC16 checks bits which are MSB first bytes with a big endian crc16 appended, same layout as viterbi_codec.Encode
+func CheckCRC16(bits []int) bool {
+	if len(bits)%8 != 0 || len(bits) < 24 {
+		return false
+	}
+
+	data := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		if bit != 0 {
+			data[i/8] |= 1 << uint(7-i%8)
+		}
+	}
+
+	return crc.Match16(data[:len(data)-2], binary.BigEndian.Uint16(data[len(data)-2:]))
+}
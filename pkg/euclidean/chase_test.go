@@ -0,0 +1,99 @@
+package euclidean_test
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"testing"
+
+	"github.com/8ff/udarp/pkg/crc"
+	"github.com/8ff/udarp/pkg/euclidean"
+)
+
+// Build soft values for data with crc16 appended, 0 maps to 0.1 and 1 maps to 0.9
+func softFrame(data []byte) ([]int, []float64) {
+	crcBytes := make([]byte, 2)
+	binary.BigEndian.PutUint16(crcBytes, crc.Encode16(data))
+	frame := append(append([]byte{}, data...), crcBytes...)
+
+	bits := make([]int, 0, len(frame)*8)
+	soft := make([]float64, 0, len(frame)*8)
+	for _, b := range frame {
+		for i := 7; i >= 0; i-- {
+			bit := int(b>>uint(i)) & 1
+			bits = append(bits, bit)
+			soft = append(soft, 0.1+0.8*float64(bit))
+		}
+	}
+	return bits, soft
+}
+
+func TestListDecodeRecoversWeakBits(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	data := make([]byte, 8)
+	r.Read(data)
+	bits, soft := softFrame(data)
+
+	// Push 3 bits just past the mean so the hard decision gets them wrong
+	for _, pos := range []int{5, 23, 60} {
+		if bits[pos] == 1 {
+			soft[pos] = 0.45
+		} else {
+			soft[pos] = 0.55
+		}
+	}
+	if euclidean.CheckCRC16(euclidean.HardDecode(soft)) {
+		t.Fatalf("Hard decision should fail the CRC")
+	}
+
+	decoded, attempts, err := euclidean.ListDecode(soft, euclidean.ChaseParams{LeastReliable: 8, MaxCandidates: 256}, euclidean.CheckCRC16)
+	if err != nil {
+		t.Fatalf("ListDecode failed with error: %v", err)
+	}
+	for i := range bits {
+		if decoded[i] != bits[i] {
+			t.Fatalf("Decoded bit %d doesn't match input", i)
+		}
+	}
+	if attempts > 8 {
+		t.Fatalf("Expected the weak bits to be flipped early, took %d attempts", attempts)
+	}
+}
+
+func TestCandidateIteratorIsBounded(t *testing.T) {
+	soft := []float64{0.1, 0.45, 0.9, 0.55, 0.2, 0.8}
+
+	it, err := euclidean.NewCandidateIterator(soft, euclidean.ChaseParams{LeastReliable: 3, MaxCandidates: 100})
+	if err != nil {
+		t.Fatalf("NewCandidateIterator failed with error: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	for {
+		candidate, ok := it.Next()
+		if !ok {
+			break
+		}
+		key := ""
+		for _, b := range candidate {
+			key += string(rune('0' + b))
+		}
+		if seen[key] {
+			t.Fatalf("Candidate %s generated twice", key)
+		}
+		seen[key] = true
+	}
+
+	// 3 least reliable bits give 8 flip patterns including the hard decision
+	if len(seen) != 8 {
+		t.Fatalf("Expected 8 candidates, got %d", len(seen))
+	}
+
+	it, _ = euclidean.NewCandidateIterator(soft, euclidean.ChaseParams{LeastReliable: 3, MaxCandidates: 2})
+	count := 0
+	for _, ok := it.Next(); ok; _, ok = it.Next() {
+		count++
+	}
+	if count != 2 {
+		t.Fatalf("Expected MaxCandidates to cap output at 2, got %d", count)
+	}
+}
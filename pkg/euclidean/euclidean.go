@@ -425,7 +425,7 @@ func HybridDecodeV3DebugOld(data []float64) [][]int {
 	return variations
 }
 
-// This is a wrapper for the EuclideanDistance function
+// This is a wrapper for the TrellisDecode function
 func SoftDecode(constraint int, data []float64) ([]int, error) {
 	if len(data) < constraint {
 		return nil, fmt.Errorf("constraint must be less than or equal to length of data")
@@ -437,7 +437,10 @@ func SoftDecode(constraint int, data []float64) ([]int, error) {
 			break
 		}
 
-		bits := EuclideanDistance(constraint, data[i:i+constraint])
+		bits, err := TrellisDecode(constraint, data[i:i+constraint])
+		if err != nil {
+			return nil, err
+		}
 		if i == 0 {
 			// Keep all bits for first run
 			output = append(output, bits...)
@@ -452,7 +455,7 @@ func SoftDecode(constraint int, data []float64) ([]int, error) {
 	return output, nil
 }
 
-// This works poorly
+// Brute force ML decode over all codewords, this works poorly and is kept as a reference for TrellisDecode
 func EuclideanDistance(constraint int, data []float64) []int {
 	output := make([]int, len(data))
 	// Generate all possible codewords for constaint - 1 bits, and add a xor parity bit
@@ -0,0 +1,63 @@
+package euclidean
+
+import (
+	"fmt"
+	"math"
+)
+
+/*
+Soft decoding of the single parity check block code from misc.GenerateCodewords.
+
+Every codeword of length constraint has even weight, so the code can be drawn as a two state
+trellis where the state is the running parity. Running Viterbi over it with squared euclidean
+branch metrics gives the same ML codeword as EuclideanDistance, but in O(constraint) per block
+instead of trying all 2^(constraint-1) codewords.
+*/
+
+// TrellisDecode decodes data block by block, len(data) must be a multiple of constraint
+func TrellisDecode(constraint int, data []float64) ([]int, error) {
+	if constraint < 2 {
+		return nil, fmt.Errorf("constraint must be at least 2")
+	}
+	if len(data)%constraint != 0 {
+		return nil, fmt.Errorf("length of data must be a multiple of constraint")
+	}
+
+	output := make([]int, len(data))
+	// decisions[k][s] holds the bit that was chosen to reach parity state s at position k
+	decisions := make([][2]int, constraint)
+
+	for r := 0; r < len(data); r += constraint {
+		metrics := [2]float64{0, math.Inf(1)}
+
+		for k := 0; k < constraint; k++ {
+			v := data[r+k]
+			cost := [2]float64{v * v, (1 - v) * (1 - v)}
+			var next [2]float64
+
+			for state := 0; state < 2; state++ {
+				// State is reached either by a 0 from the same parity or a 1 from the other parity
+				stay := metrics[state] + cost[0]
+				flip := metrics[state^1] + cost[1]
+				if flip < stay {
+					next[state] = flip
+					decisions[k][state] = 1
+				} else {
+					next[state] = stay
+					decisions[k][state] = 0
+				}
+			}
+			metrics = next
+		}
+
+		// Valid codewords end with even parity, trace back from state 0
+		state := 0
+		for k := constraint - 1; k >= 0; k-- {
+			bit := decisions[k][state]
+			output[r+k] = bit
+			state ^= bit
+		}
+	}
+
+	return output, nil
+}
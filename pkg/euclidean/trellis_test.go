@@ -0,0 +1,40 @@
+package euclidean_test
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/8ff/udarp/pkg/euclidean"
+)
+
+func TestTrellisMatchesBruteForce(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	for constraint := 2; constraint <= 8; constraint++ {
+		for run := 0; run < 200; run++ {
+			data := make([]float64, constraint*4)
+			for i := range data {
+				data[i] = r.Float64()*1.4 - 0.2
+			}
+
+			expected := euclidean.EuclideanDistance(constraint, data)
+			decoded, err := euclidean.TrellisDecode(constraint, data)
+			if err != nil {
+				t.Fatalf("TrellisDecode failed with error: %v", err)
+			}
+
+			for i := range expected {
+				if decoded[i] != expected[i] {
+					t.Fatalf("Constraint %d: trellis %v doesn't match brute force %v for %v", constraint, decoded, expected, data)
+				}
+			}
+		}
+	}
+}
+
+func TestTrellisRejectsPartialBlock(t *testing.T) {
+	_, err := euclidean.TrellisDecode(3, []float64{0.1, 0.9})
+	if err == nil {
+		t.Fatalf("Expected error for data that is not a multiple of constraint")
+	}
+}
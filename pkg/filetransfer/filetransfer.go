@@ -0,0 +1,586 @@
+package filetransfer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/8ff/udarp/pkg/fragment"
+	"github.com/8ff/udarp/pkg/frame"
+	"github.com/8ff/udarp/pkg/misc"
+	"github.com/8ff/udarp/pkg/pack"
+)
+
+/*
+File transfer with resume.
+
+The sender announces the file with a manifest, the receiver answers with a status bitmap of the
+chunks it already has (empty for a new transfer, partly filled when resuming). The sender then sends
+the missing chunks in batches of Window followed by a query, and the receiver answers every query
+with its bitmap, until the receiver reports the file complete and the hash verified.
+
+Partial files and their bitmaps are kept under Dir/.partial so a transfer survives restarts of
+either side, finished files are moved into Dir.
+*/
+
+var (
+	ErrRejected = errors.New("receiver rejected the transfer")
+	ErrTimeout  = errors.New("receiver stopped answering")
+)
+
+// Transmitter sends frames over the air back to back, keying the transmitter once for all of them
+type Transmitter func(frames ...frame.Frame) error
+
+type Params struct {
+	Callsign   string
+	Dir        string        // Where received files are stored
+	ChunkSize  int           // Bytes per chunk, default 128
+	Window     int           // Chunks sent before asking for a status, default 16
+	Timeout    time.Duration // How long to wait for a status, default 1 minute
+	MaxRetries int           // Unanswered queries before a send fails, default 5
+	MaxSize    int64         // Largest file accepted, default 1 MiB
+
+	// Optional, how long a frame with payload bytes takes on the air.
+	// The wait for a status grows by the time the status itself is on the air.
+	Airtime func(payload int) time.Duration
+}
+
+type Direction string
+
+const (
+	Sending   Direction = "send"
+	Receiving Direction = "receive"
+)
+
+type State string
+
+const (
+	StateActive   State = "active"
+	StateComplete State = "complete"
+	StateFailed   State = "failed"
+)
+
+type Progress struct {
+	ID        uint32    `json:"id"`
+	Direction Direction `json:"direction"`
+	Peer      string    `json:"peer"`
+	Name      string    `json:"name"`
+	Size      uint64    `json:"size"`
+	Chunks    int       `json:"chunks"`
+	Done      int       `json:"done"` // Chunks the receiver has
+	State     State     `json:"state"`
+	Err       string    `json:"error,omitempty"`
+	Updated   time.Time `json:"updated"`
+}
+
+type outgoing struct {
+	status chan status
+}
+
+// incoming is the receiver side state, persisted as JSON next to the partial file
+type incoming struct {
+	Source   string   `json:"source"`
+	Manifest Manifest `json:"manifest"`
+	Bitmap   []byte   `json:"bitmap"`
+	Complete bool     `json:"complete"`
+}
+
+func (in *incoming) status() status {
+	s := status{id: in.Manifest.ID, total: uint16(in.Manifest.Chunks()), bitmap: append([]byte{}, in.Bitmap...)}
+	if in.Complete {
+		s.flags |= statusComplete
+	}
+	return s
+}
+
+type Service struct {
+	params Params
+	tx     Transmitter
+
+	// Called on every progress change, must not block
+	OnProgress func(Progress)
+
+	m        sync.Mutex
+	outgoing map[string]*outgoing
+	incoming map[string]*incoming
+	progress map[string]Progress
+	sequence uint16
+}
+
+func New(params Params, tx Transmitter) (*Service, error) {
+	params.Callsign = strings.ToUpper(params.Callsign)
+	if _, err := pack.PackCallsign(params.Callsign); err != nil {
+		return nil, fmt.Errorf("invalid callsign: %w", err)
+	}
+	if tx == nil {
+		return nil, fmt.Errorf("transmitter is required")
+	}
+	if params.Dir == "" {
+		return nil, fmt.Errorf("directory is required")
+	}
+	if params.ChunkSize == 0 {
+		params.ChunkSize = 128
+	}
+	if params.Window == 0 {
+		params.Window = 16
+	}
+	if params.Timeout == 0 {
+		params.Timeout = time.Minute
+	}
+	if params.MaxRetries == 0 {
+		params.MaxRetries = 5
+	}
+	if params.MaxSize == 0 {
+		params.MaxSize = 1 << 20
+	}
+	if err := os.MkdirAll(filepath.Join(params.Dir, ".partial"), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	return &Service{
+		params:   params,
+		tx:       tx,
+		outgoing: make(map[string]*outgoing),
+		incoming: make(map[string]*incoming),
+		progress: make(map[string]Progress),
+	}, nil
+}
+
+func transferKey(peer string, id uint32) string {
+	return fmt.Sprintf("%s-%08x", strings.ToUpper(peer), id)
+}
+
+// Transfers returns the progress of all transfers, most recently updated first
+func (s *Service) Transfers() []Progress {
+	s.m.Lock()
+	defer s.m.Unlock()
+	list := make([]Progress, 0, len(s.progress))
+	for _, p := range s.progress {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Updated.After(list[j].Updated) })
+	return list
+}
+
+func (s *Service) report(p Progress) {
+	p.Updated = time.Now()
+	s.m.Lock()
+	s.progress[string(p.Direction)+"/"+transferKey(p.Peer, p.ID)] = p
+	s.m.Unlock()
+	if s.OnProgress != nil {
+		s.OnProgress(p)
+	}
+}
+
+func (s *Service) nextSequence() uint16 {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.sequence++
+	return s.sequence
+}
+
+// send transmits payloads to peer as one batch
+func (s *Service) send(peer string, payloads ...[]byte) error {
+	frames := make([]frame.Frame, len(payloads))
+	for i, payload := range payloads {
+		frames[i] = frame.New(frame.TypeFile, s.params.Callsign, peer, s.nextSequence(), payload)
+	}
+	return s.tx(frames...)
+}
+
+// SendFile sends the file at path to peer
+func (s *Service) SendFile(ctx context.Context, peer, path string) (Progress, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return Progress{}, err
+	}
+	if info.Size() > s.params.MaxSize {
+		return Progress{}, fmt.Errorf("file is larger than %d bytes", s.params.MaxSize)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Progress{}, err
+	}
+	return s.Send(ctx, peer, filepath.Base(path), data)
+}
+
+// Send sends data to peer under name and returns once the receiver verified it.
+// Sending the same data to the same peer again resumes where the last attempt stopped.
+func (s *Service) Send(ctx context.Context, peer, name string, data []byte) (Progress, error) {
+	peer = strings.ToUpper(peer)
+	if _, err := pack.PackCallsign(peer); err != nil {
+		return Progress{}, fmt.Errorf("invalid peer: %w", err)
+	}
+	if int64(len(data)) > s.params.MaxSize {
+		return Progress{}, fmt.Errorf("file is larger than %d bytes", s.params.MaxSize)
+	}
+	manifest, err := NewManifest(name, data, s.params.ChunkSize)
+	if err != nil {
+		return Progress{}, err
+	}
+	chunks, err := fragment.Split(manifest.ID, data, s.params.ChunkSize)
+	if err != nil {
+		return Progress{}, err
+	}
+
+	key := transferKey(peer, manifest.ID)
+	out := &outgoing{status: make(chan status, 1)}
+	s.m.Lock()
+	if _, busy := s.outgoing[key]; busy {
+		s.m.Unlock()
+		return Progress{}, fmt.Errorf("already sending %s to %s", manifest.Name, peer)
+	}
+	s.outgoing[key] = out
+	s.m.Unlock()
+	defer func() {
+		s.m.Lock()
+		delete(s.outgoing, key)
+		s.m.Unlock()
+	}()
+
+	progress := Progress{ID: manifest.ID, Direction: Sending, Peer: peer, Name: manifest.Name, Size: manifest.Size, Chunks: len(chunks), State: StateActive}
+	s.report(progress)
+	fail := func(err error) (Progress, error) {
+		progress.State = StateFailed
+		progress.Err = err.Error()
+		s.report(progress)
+		return progress, err
+	}
+
+	// The status reply has to make it over the air before it can be waited for
+	wait := s.params.Timeout
+	if s.params.Airtime != nil {
+		wait += s.params.Airtime(statusSize(len(chunks)))
+	}
+
+	// Ask until the receiver answers, then send what it is missing until it has everything
+	var current status
+	known := false
+	retries := 0
+	for {
+		var batch [][]byte
+		if !known {
+			batch = append(batch, manifest.marshal())
+		} else {
+			for i := 0; i < len(chunks) && len(batch) < s.params.Window; i++ {
+				if current.has(i) {
+					continue
+				}
+				payload, err := marshalChunk(chunks[i])
+				if err != nil {
+					return fail(err)
+				}
+				batch = append(batch, payload)
+			}
+			batch = append(batch, marshalQuery(manifest.ID))
+		}
+
+		if err := s.send(peer, batch...); err != nil {
+			return fail(fmt.Errorf("failed to transmit: %w", err))
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case st := <-out.status:
+			timer.Stop()
+			retries = 0
+			switch {
+			case st.flags&statusRejected != 0:
+				return fail(ErrRejected)
+			case st.flags&statusUnknown != 0:
+				// Receiver lost its state, announce again
+				known = false
+				continue
+			case int(st.total) != len(chunks):
+				return fail(fmt.Errorf("receiver expects %d chunks, we have %d", st.total, len(chunks)))
+			}
+			known = true
+			current = st
+			progress.Done = st.count()
+			if st.flags&statusComplete != 0 {
+				progress.State = StateComplete
+				s.report(progress)
+				misc.Log("info", fmt.Sprintf("Sent %s to %s", manifest.Name, peer))
+				return progress, nil
+			}
+			s.report(progress)
+		case <-timer.C:
+			retries++
+			if retries > s.params.MaxRetries {
+				return fail(ErrTimeout)
+			}
+		case <-ctx.Done():
+			timer.Stop()
+			return fail(ctx.Err())
+		}
+	}
+}
+
+// Handle processes a received file transfer frame and returns the reply to transmit, if any.
+func (s *Service) Handle(f frame.Frame) (frame.Frame, bool) {
+	if f.Type != frame.TypeFile || !strings.EqualFold(f.Destination, s.params.Callsign) || len(f.Payload) < 5 {
+		return frame.Frame{}, false
+	}
+	source := strings.ToUpper(f.Source)
+	id := binary.BigEndian.Uint32(f.Payload[1:5])
+
+	var reply status
+	switch kind(f.Payload[0]) {
+	case kindStatus:
+		st, err := parseStatus(f.Payload)
+		if err != nil {
+			misc.Log("warning", fmt.Sprintf("File transfer status from %s: %v", source, err))
+			return frame.Frame{}, false
+		}
+		s.m.Lock()
+		out, ok := s.outgoing[transferKey(source, st.id)]
+		s.m.Unlock()
+		if ok {
+			// Only the latest status matters
+			select {
+			case <-out.status:
+			default:
+			}
+			out.status <- st
+		}
+		return frame.Frame{}, false
+
+	case kindManifest:
+		manifest, err := parseManifest(f.Payload)
+		if err != nil {
+			misc.Log("warning", fmt.Sprintf("File transfer manifest from %s: %v", source, err))
+			return frame.Frame{}, false
+		}
+		var p *Progress
+		reply, p, err = s.receiveManifest(source, manifest)
+		if p != nil {
+			s.report(*p)
+		}
+		if err != nil {
+			misc.Log("warning", fmt.Sprintf("Rejecting %s from %s: %v", manifest.Name, source, err))
+			reply = status{id: id, flags: statusRejected}
+		}
+
+	case kindChunk:
+		var chunk fragment.Fragment
+		if err := chunk.UnmarshalBinary(f.Payload[1:]); err != nil {
+			misc.Log("warning", fmt.Sprintf("File transfer chunk from %s: %v", source, err))
+			return frame.Frame{}, false
+		}
+		p, err := s.receiveChunk(source, chunk)
+		if err != nil {
+			misc.Log("warning", fmt.Sprintf("File transfer chunk from %s: %v", source, err))
+		}
+		if p != nil {
+			s.report(*p)
+		}
+		// Chunks are answered by the query at the end of the batch
+		return frame.Frame{}, false
+
+	case kindQuery:
+		in, err := s.lookup(source, id)
+		if err != nil {
+			reply = status{id: id, flags: statusUnknown}
+		} else {
+			s.m.Lock()
+			reply = in.status()
+			s.m.Unlock()
+		}
+
+	default:
+		return frame.Frame{}, false
+	}
+
+	return frame.New(frame.TypeFile, s.params.Callsign, source, s.nextSequence(), reply.marshal()), true
+}
+
+func (s *Service) partialPath(source string, id uint32) string {
+	return filepath.Join(s.params.Dir, ".partial", transferKey(source, id))
+}
+
+// lookup returns the receive state of a transfer from memory or disk
+func (s *Service) lookup(source string, id uint32) (*incoming, error) {
+	key := transferKey(source, id)
+	s.m.Lock()
+	defer s.m.Unlock()
+	if in, ok := s.incoming[key]; ok {
+		return in, nil
+	}
+
+	data, err := os.ReadFile(s.partialPath(source, id) + ".json")
+	if err != nil {
+		return nil, err
+	}
+	in := &incoming{}
+	if err := json.Unmarshal(data, in); err != nil {
+		return nil, err
+	}
+	s.incoming[key] = in
+	return in, nil
+}
+
+// save persists the receive state, called with m held
+func (s *Service) save(in *incoming) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	path := s.partialPath(in.Source, in.Manifest.ID) + ".json"
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// receiveManifest starts or resumes a transfer, it returns the progress to report for new transfers
+func (s *Service) receiveManifest(source string, manifest Manifest) (status, *Progress, error) {
+	if in, err := s.lookup(source, manifest.ID); err == nil {
+		s.m.Lock()
+		defer s.m.Unlock()
+		if in.Manifest != manifest {
+			return status{}, nil, fmt.Errorf("manifest changed for transfer %08x", manifest.ID)
+		}
+		misc.Log("info", fmt.Sprintf("Resuming %s from %s", manifest.Name, source))
+		return in.status(), nil, nil
+	}
+
+	if int64(manifest.Size) > s.params.MaxSize {
+		return status{}, nil, fmt.Errorf("file is larger than %d bytes", s.params.MaxSize)
+	}
+
+	part, err := os.Create(s.partialPath(source, manifest.ID) + ".part")
+	if err != nil {
+		return status{}, nil, err
+	}
+	err = part.Truncate(int64(manifest.Size))
+	part.Close()
+	if err != nil {
+		return status{}, nil, err
+	}
+
+	in := &incoming{Source: source, Manifest: manifest, Bitmap: make([]byte, (manifest.Chunks()+7)/8)}
+	s.m.Lock()
+	defer s.m.Unlock()
+	if err := s.save(in); err != nil {
+		return status{}, nil, err
+	}
+	s.incoming[transferKey(source, manifest.ID)] = in
+	misc.Log("info", fmt.Sprintf("Receiving %s (%d bytes) from %s", manifest.Name, manifest.Size, source))
+	p := s.receiveProgress(in)
+	return in.status(), &p, nil
+}
+
+// receiveChunk stores a chunk, it returns the progress to report when the chunk was new
+func (s *Service) receiveChunk(source string, chunk fragment.Fragment) (*Progress, error) {
+	in, err := s.lookup(source, chunk.ID)
+	if err != nil {
+		return nil, fmt.Errorf("no manifest for transfer %08x", chunk.ID)
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	m := in.Manifest
+	if in.Complete || in.status().has(int(chunk.Index)) {
+		return nil, nil
+	}
+	if int(chunk.Total) != m.Chunks() {
+		return nil, fmt.Errorf("chunk says %d chunks, manifest %d", chunk.Total, m.Chunks())
+	}
+	offset := int64(chunk.Index) * int64(m.ChunkSize)
+	expected := int64(m.ChunkSize)
+	if rest := int64(m.Size) - offset; rest < expected {
+		expected = rest
+	}
+	if int64(len(chunk.Data)) != expected {
+		return nil, fmt.Errorf("chunk %d has %d bytes, expected %d", chunk.Index, len(chunk.Data), expected)
+	}
+
+	part, err := os.OpenFile(s.partialPath(source, m.ID)+".part", os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	_, err = part.WriteAt(chunk.Data, offset)
+	part.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	in.Bitmap[chunk.Index/8] |= 0x80 >> (chunk.Index % 8)
+	if in.status().count() == m.Chunks() {
+		if err := s.finish(in); err != nil {
+			// Start over, the sender will resend everything
+			in.Bitmap = make([]byte, len(in.Bitmap))
+			misc.Log("error", fmt.Sprintf("Transfer of %s from %s failed: %v", m.Name, source, err))
+		}
+	}
+	if err := s.save(in); err != nil {
+		return nil, err
+	}
+	p := s.receiveProgress(in)
+	return &p, nil
+}
+
+// finish verifies the hash and moves the file into place, called with m held
+func (s *Service) finish(in *incoming) error {
+	partial := s.partialPath(in.Source, in.Manifest.ID) + ".part"
+	data, err := os.ReadFile(partial)
+	if err != nil {
+		return err
+	}
+	if sha256.Sum256(data) != in.Manifest.Hash {
+		return fmt.Errorf("hash mismatch")
+	}
+
+	// Don't overwrite earlier files with the same name
+	target := filepath.Join(s.params.Dir, in.Manifest.Name)
+	ext := filepath.Ext(in.Manifest.Name)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(target); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		target = filepath.Join(s.params.Dir, fmt.Sprintf("%s.%d%s", strings.TrimSuffix(in.Manifest.Name, ext), i, ext))
+	}
+	if err := os.Rename(partial, target); err != nil {
+		return err
+	}
+
+	in.Complete = true
+	misc.Log("info", fmt.Sprintf("Received %s from %s", filepath.Base(target), in.Source))
+	return nil
+}
+
+func (s *Service) receiveProgress(in *incoming) Progress {
+	p := Progress{
+		ID:        in.Manifest.ID,
+		Direction: Receiving,
+		Peer:      in.Source,
+		Name:      in.Manifest.Name,
+		Size:      in.Manifest.Size,
+		Chunks:    in.Manifest.Chunks(),
+		Done:      in.status().count(),
+		State:     StateActive,
+	}
+	if in.Complete {
+		p.State = StateComplete
+	}
+	return p
+}
+
+// ReadFile returns a received file, for the HTTP API
+func (s *Service) ReadFile(name string) ([]byte, error) {
+	name, err := cleanName(name)
+	if err != nil || strings.HasPrefix(name, ".") {
+		return nil, fmt.Errorf("invalid file name")
+	}
+	return os.ReadFile(filepath.Join(s.params.Dir, name))
+}
@@ -0,0 +1,244 @@
+package filetransfer_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/8ff/udarp/pkg/filetransfer"
+	"github.com/8ff/udarp/pkg/frame"
+)
+
+// link connects a sender and a receiver, dropping frames with probability loss
+type link struct {
+	m       sync.Mutex
+	r       *rand.Rand
+	loss    float64
+	cut     bool // Drop everything, the path went away
+	sent    int  // Frames sent by the sender
+	batches int  // Transmitter calls by the sender, one per keying
+	sender  *filetransfer.Service
+	receive *filetransfer.Service
+}
+
+func (l *link) dropped() bool {
+	l.m.Lock()
+	defer l.m.Unlock()
+	return l.cut || l.r.Float64() < l.loss
+}
+
+// air carries frames from the sender to the receiver and its replies back
+func (l *link) air(t *testing.T) filetransfer.Transmitter {
+	return func(frames ...frame.Frame) error {
+		l.m.Lock()
+		l.sent += len(frames)
+		l.batches++
+		receiver := l.receive
+		l.m.Unlock()
+
+		for _, f := range frames {
+			data, err := f.MarshalBinary()
+			if err != nil {
+				t.Errorf("MarshalBinary failed with error: %v", err)
+				return err
+			}
+			if l.dropped() {
+				continue
+			}
+			var received frame.Frame
+			if err := received.UnmarshalBinary(data); err != nil {
+				t.Errorf("UnmarshalBinary failed with error: %v", err)
+				return err
+			}
+			reply, ok := receiver.Handle(received)
+			if ok && !l.dropped() {
+				go l.sender.Handle(reply)
+			}
+		}
+		return nil
+	}
+}
+
+var params = filetransfer.Params{ChunkSize: 50, Window: 8, Timeout: 50 * time.Millisecond, MaxRetries: 20}
+
+func newLink(t *testing.T, loss float64, recvDir string) *link {
+	l := &link{r: rand.New(rand.NewSource(1)), loss: loss}
+	var err error
+
+	sp := params
+	sp.Callsign, sp.Dir = "K1ABC", t.TempDir()
+	l.sender, err = filetransfer.New(sp, l.air(t))
+	if err != nil {
+		t.Fatalf("New failed with error: %v", err)
+	}
+	l.newReceiver(t, recvDir)
+	return l
+}
+
+// newReceiver replaces the receiver, like restarting the receiving station
+func (l *link) newReceiver(t *testing.T, dir string) {
+	rp := params
+	rp.Callsign, rp.Dir = "KA1XYZ", dir
+	r, err := filetransfer.New(rp, func(...frame.Frame) error { return nil })
+	if err != nil {
+		t.Fatalf("New failed with error: %v", err)
+	}
+	l.m.Lock()
+	l.receive = r
+	l.m.Unlock()
+}
+
+func randomData(n int, seed int64) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func TestTransfer(t *testing.T) {
+	dir := t.TempDir()
+	l := newLink(t, 0.2, dir)
+	data := randomData(2000, 2)
+
+	var m sync.Mutex
+	var updates []filetransfer.Progress
+	l.sender.OnProgress = func(p filetransfer.Progress) {
+		m.Lock()
+		defer m.Unlock()
+		updates = append(updates, p)
+	}
+
+	progress, err := l.sender.Send(context.Background(), "KA1XYZ", "../../etc/form.txt", data)
+	if err != nil {
+		t.Fatalf("Send failed with error: %v", err)
+	}
+	if progress.State != filetransfer.StateComplete || progress.Done != 40 || progress.Chunks != 40 {
+		t.Fatalf("Unexpected progress %+v", progress)
+	}
+
+	received, err := os.ReadFile(filepath.Join(dir, "form.txt"))
+	if err != nil || !bytes.Equal(received, data) {
+		t.Fatalf("Received file does not match (%v)", err)
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	if len(updates) < 3 || updates[len(updates)-1].State != filetransfer.StateComplete {
+		t.Fatalf("Expected progress updates ending in complete, got %+v", updates)
+	}
+	for i := 1; i < len(updates); i++ {
+		if updates[i].Done < updates[i-1].Done {
+			t.Fatalf("Progress went backwards: %+v", updates)
+		}
+	}
+}
+
+func TestResume(t *testing.T) {
+	dir := t.TempDir()
+	l := newLink(t, 0, dir)
+	data := randomData(4000, 3)
+
+	// The path goes away after the first batches
+	l.sender.OnProgress = func(p filetransfer.Progress) {
+		if p.Done >= 24 {
+			l.m.Lock()
+			l.cut = true
+			l.m.Unlock()
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	_, err := l.sender.Send(ctx, "KA1XYZ", "image.jpg", data)
+	cancel()
+	if !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, filetransfer.ErrTimeout) {
+		t.Fatalf("Expected the first attempt to fail, got %v", err)
+	}
+
+	// Receiver restarts, the path comes back and the file is sent again
+	l.newReceiver(t, dir)
+	l.m.Lock()
+	l.cut = false
+	l.sent, l.batches = 0, 0
+	l.m.Unlock()
+	l.sender.OnProgress = nil
+
+	if _, err := l.sender.Send(context.Background(), "KA1XYZ", "image.jpg", data); err != nil {
+		t.Fatalf("Resumed send failed with error: %v", err)
+	}
+	if l.sent >= 80 {
+		t.Fatalf("Resume sent %d frames, the full file is 80 chunks", l.sent)
+	}
+	// Chunks and the query go out together, Window 8 needs at least 9 frames per keying for 80 chunks
+	if l.batches > 1+(80+7)/8 {
+		t.Fatalf("Expected a keying per batch, got %d for %d frames", l.batches, l.sent)
+	}
+	received, err := os.ReadFile(filepath.Join(dir, "image.jpg"))
+	if err != nil || !bytes.Equal(received, data) {
+		t.Fatalf("Received file does not match (%v)", err)
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	dir := t.TempDir()
+	l := newLink(t, 0, dir)
+	data := randomData(300, 4)
+
+	srv := httptest.NewServer(l.sender.Handler())
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/transfers?peer=ka1xyz&name=report.txt", "application/octet-stream", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("POST failed with error: %v", err)
+	}
+	var started filetransfer.Progress
+	json.NewDecoder(resp.Body).Decode(&started)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted || started.Peer != "KA1XYZ" || started.Chunks != 6 {
+		t.Fatalf("Unexpected response %d %+v", resp.StatusCode, started)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := http.Get(srv.URL + "/transfers")
+		if err != nil {
+			t.Fatalf("GET failed with error: %v", err)
+		}
+		var list []filetransfer.Progress
+		json.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+		if len(list) == 1 && list[0].ID == started.ID && list[0].State == filetransfer.StateComplete {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Transfer did not complete, got %+v", list)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Download from the receiver
+	recvSrv := httptest.NewServer(l.receive.Handler())
+	defer recvSrv.Close()
+	resp, err = http.Get(recvSrv.URL + "/files/report.txt")
+	if err != nil {
+		t.Fatalf("GET failed with error: %v", err)
+	}
+	got, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !bytes.Equal(got, data) {
+		t.Fatalf("Downloaded file does not match")
+	}
+
+	resp, _ = http.Post(srv.URL+"/transfers?peer=K1ABC", "application/octet-stream", bytes.NewReader(data))
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected bad request without a name, got %d", resp.StatusCode)
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,99 @@
+package filetransfer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/8ff/udarp/pkg/misc"
+	"github.com/8ff/udarp/pkg/pack"
+)
+
+/*
+HTTP API:
+	GET  /transfers                        progress of all transfers as JSON
+	POST /transfers?peer=CALL&name=FILE    body is the file, the transfer runs in the background
+	GET  /files/NAME                       download a received file
+*/
+
+// Handler returns the HTTP API, mount it with http.StripPrefix
+func (s *Service) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/transfers", s.handleTransfers)
+	mux.HandleFunc("/files/", s.handleFile)
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+func (s *Service) handleTransfers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.Transfers())
+
+	case http.MethodPost:
+		peer := r.URL.Query().Get("peer")
+		name := r.URL.Query().Get("name")
+		data, err := io.ReadAll(io.LimitReader(r.Body, s.params.MaxSize+1))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if int64(len(data)) > s.params.MaxSize {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("file is larger than %d bytes", s.params.MaxSize))
+			return
+		}
+		manifest, err := NewManifest(name, data, s.params.ChunkSize)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if _, err := pack.PackCallsign(peer); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid peer: %w", err))
+			return
+		}
+
+		go func() {
+			if _, err := s.Send(context.Background(), peer, manifest.Name, data); err != nil {
+				misc.Log("error", fmt.Sprintf("Sending %s to %s failed: %v", manifest.Name, peer, err))
+			}
+		}()
+		writeJSON(w, http.StatusAccepted, Progress{
+			ID:        manifest.ID,
+			Direction: Sending,
+			Peer:      strings.ToUpper(peer),
+			Name:      manifest.Name,
+			Size:      manifest.Size,
+			Chunks:    manifest.Chunks(),
+			State:     StateActive,
+		})
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+func (s *Service) handleFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	data, err := s.ReadFile(strings.TrimPrefix(r.URL.Path, "/files/"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("file not found"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(data)
+}
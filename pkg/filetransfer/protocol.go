@@ -0,0 +1,185 @@
+package filetransfer
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/8ff/udarp/pkg/fragment"
+)
+
+/*
+File transfer payloads, carried in frame.TypeFile frames. The transfer ID is the first 4 bytes of the
+file hash, so sending the same file again after an interruption resumes the old transfer.
+
+	0      Kind
+	1..4   Transfer ID
+	Manifest  5..12 size, 13..14 chunk size, 15..46 SHA-256, 47 name length, name
+	Chunk     a fragment.Fragment starting at byte 1, its ID is the transfer ID
+	Query     nothing
+	Status    5 status flags, 6..7 chunk count, bitmap of received chunks, chunk i is bit 7-i%8 of byte i/8
+*/
+
+type kind uint8
+
+const (
+	kindManifest kind = iota + 1
+	kindChunk
+	kindQuery
+	kindStatus
+)
+
+const (
+	statusComplete = 0x01 // All chunks received and the hash matched
+	statusUnknown  = 0x02 // Receiver has no manifest for the transfer
+	statusRejected = 0x04 // Receiver refuses the transfer
+)
+
+const (
+	manifestFixed = 48
+	MaxName       = 0xFF
+)
+
+var ErrMalformed = errors.New("malformed file transfer payload")
+
+type Manifest struct {
+	ID        uint32
+	Name      string
+	Size      uint64
+	ChunkSize uint16
+	Hash      [sha256.Size]byte
+}
+
+// NewManifest describes data sent under name in chunks of chunkSize bytes
+func NewManifest(name string, data []byte, chunkSize int) (Manifest, error) {
+	name, err := cleanName(name)
+	if err != nil {
+		return Manifest{}, err
+	}
+	if chunkSize < 1 || chunkSize > 0xFFFF {
+		return Manifest{}, fmt.Errorf("chunk size must be between 1 and %d", 0xFFFF)
+	}
+	m := Manifest{Name: name, Size: uint64(len(data)), ChunkSize: uint16(chunkSize), Hash: sha256.Sum256(data)}
+	m.ID = binary.BigEndian.Uint32(m.Hash[:4])
+	if m.Chunks() > fragment.MaxFragments {
+		return Manifest{}, fmt.Errorf("file needs %d chunks, at most %d are allowed", m.Chunks(), fragment.MaxFragments)
+	}
+	return m, nil
+}
+
+// Chunks returns the number of chunks the file is sent in, empty files take one
+func (m Manifest) Chunks() int {
+	n := int((m.Size + uint64(m.ChunkSize) - 1) / uint64(m.ChunkSize))
+	if n == 0 {
+		return 1
+	}
+	return n
+}
+
+// cleanName strips directories so a manifest can't write outside the receive directory
+func cleanName(name string) (string, error) {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" || name == ".." || name == "" {
+		return "", fmt.Errorf("invalid file name")
+	}
+	if len(name) > MaxName {
+		return "", fmt.Errorf("file name is longer than %d bytes", MaxName)
+	}
+	return name, nil
+}
+
+func (m Manifest) marshal() []byte {
+	data := []byte{byte(kindManifest)}
+	data = binary.BigEndian.AppendUint32(data, m.ID)
+	data = binary.BigEndian.AppendUint64(data, m.Size)
+	data = binary.BigEndian.AppendUint16(data, m.ChunkSize)
+	data = append(data, m.Hash[:]...)
+	data = append(data, byte(len(m.Name)))
+	return append(data, m.Name...)
+}
+
+func parseManifest(data []byte) (Manifest, error) {
+	if len(data) < manifestFixed || len(data) != manifestFixed+int(data[manifestFixed-1]) {
+		return Manifest{}, fmt.Errorf("%w: manifest", ErrMalformed)
+	}
+	m := Manifest{
+		ID:        binary.BigEndian.Uint32(data[1:5]),
+		Size:      binary.BigEndian.Uint64(data[5:13]),
+		ChunkSize: binary.BigEndian.Uint16(data[13:15]),
+	}
+	copy(m.Hash[:], data[15:47])
+
+	name, err := cleanName(string(data[manifestFixed:]))
+	if err != nil {
+		return Manifest{}, fmt.Errorf("%w: %s", ErrMalformed, err)
+	}
+	m.Name = name
+	if m.ChunkSize == 0 || m.Chunks() > fragment.MaxFragments || m.ID != binary.BigEndian.Uint32(m.Hash[:4]) {
+		return Manifest{}, fmt.Errorf("%w: manifest fields", ErrMalformed)
+	}
+	return m, nil
+}
+
+func marshalChunk(f fragment.Fragment) ([]byte, error) {
+	data, err := f.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{byte(kindChunk)}, data...), nil
+}
+
+func marshalQuery(id uint32) []byte {
+	return binary.BigEndian.AppendUint32([]byte{byte(kindQuery)}, id)
+}
+
+type status struct {
+	id     uint32
+	flags  uint8
+	total  uint16
+	bitmap []byte
+}
+
+func (s status) has(i int) bool {
+	return i/8 < len(s.bitmap) && s.bitmap[i/8]&(0x80>>(i%8)) != 0
+}
+
+func (s status) count() int {
+	n := 0
+	for i := 0; i < int(s.total); i++ {
+		if s.has(i) {
+			n++
+		}
+	}
+	return n
+}
+
+// statusSize is the payload size of a status for a transfer of chunks
+func statusSize(chunks int) int {
+	return 8 + (chunks+7)/8
+}
+
+func (s status) marshal() []byte {
+	data := binary.BigEndian.AppendUint32([]byte{byte(kindStatus)}, s.id)
+	data = append(data, s.flags)
+	data = binary.BigEndian.AppendUint16(data, s.total)
+	return append(data, s.bitmap...)
+}
+
+func parseStatus(data []byte) (status, error) {
+	if len(data) < 8 {
+		return status{}, fmt.Errorf("%w: status", ErrMalformed)
+	}
+	s := status{
+		id:     binary.BigEndian.Uint32(data[1:5]),
+		flags:  data[5],
+		total:  binary.BigEndian.Uint16(data[6:8]),
+		bitmap: append([]byte{}, data[8:]...),
+	}
+	if len(s.bitmap) != (int(s.total)+7)/8 {
+		return status{}, fmt.Errorf("%w: bitmap length", ErrMalformed)
+	}
+	return s, nil
+}
@@ -0,0 +1,263 @@
+package fragment
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/8ff/udarp/pkg/rs"
+)
+
+/*
+Fragmentation of messages that don't fit in one frame.
+
+Every fragment carries a header in front of its slice of the message:
+	0..3   Message ID, chosen by the sender, unique per source
+	4..5   Index, 0 based
+	6..7   Total number of fragments
+The receiver collects fragments per source and message ID in any order, duplicates are ignored, and
+hands back the message once all of them arrived. Completed messages are remembered for the timeout
+so late retransmissions of their fragments are dropped instead of starting the message over.
+Messages that stay incomplete for longer than the timeout are dropped and reported with the indexes
+that never arrived.
+*/
+
+const (
+	HeaderSize   = 8
+	MaxFragments = 0xFFFF
+)
+
+var (
+	ErrMalformed = errors.New("malformed fragment")
+	ErrMismatch  = errors.New("fragment does not match message")
+)
+
+type Fragment struct {
+	ID    uint32
+	Index uint16
+	Total uint16
+	Data  []byte
+}
+
+func (f Fragment) MarshalBinary() ([]byte, error) {
+	if f.Total == 0 || f.Index >= f.Total {
+		return nil, fmt.Errorf("%w: index %d of %d", ErrMalformed, f.Index, f.Total)
+	}
+	data := make([]byte, HeaderSize, HeaderSize+len(f.Data))
+	binary.BigEndian.PutUint32(data[0:4], f.ID)
+	binary.BigEndian.PutUint16(data[4:6], f.Index)
+	binary.BigEndian.PutUint16(data[6:8], f.Total)
+	return append(data, f.Data...), nil
+}
+
+func (f *Fragment) UnmarshalBinary(data []byte) error {
+	if len(data) < HeaderSize {
+		return fmt.Errorf("%w: %d bytes", ErrMalformed, len(data))
+	}
+	parsed := Fragment{
+		ID:    binary.BigEndian.Uint32(data[0:4]),
+		Index: binary.BigEndian.Uint16(data[4:6]),
+		Total: binary.BigEndian.Uint16(data[6:8]),
+		Data:  append([]byte{}, data[HeaderSize:]...),
+	}
+	if parsed.Total == 0 || parsed.Index >= parsed.Total {
+		return fmt.Errorf("%w: index %d of %d", ErrMalformed, parsed.Index, parsed.Total)
+	}
+	*f = parsed
+	return nil
+}
+
+// SizeFor returns the data bytes per fragment so a marshalled fragment fits in one rs.Chunk call
+func SizeFor(params rs.Params) int {
+	return params.DataShards*params.ChunkSize - HeaderSize
+}
+
+// Split cuts data into fragments of at most size data bytes
+func Split(id uint32, data []byte, size int) ([]Fragment, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("fragment size must be at least 1")
+	}
+	total := (len(data) + size - 1) / size
+	if total == 0 {
+		// Empty messages still take one fragment so the receiver sees them
+		total = 1
+	}
+	if total > MaxFragments {
+		return nil, fmt.Errorf("message needs %d fragments, at most %d are allowed", total, MaxFragments)
+	}
+
+	fragments := make([]Fragment, total)
+	for i := range fragments {
+		end := (i + 1) * size
+		if end > len(data) {
+			end = len(data)
+		}
+		fragments[i] = Fragment{ID: id, Index: uint16(i), Total: uint16(total), Data: data[i*size : end]}
+	}
+	return fragments, nil
+}
+
+type Params struct {
+	Timeout     time.Duration // Incomplete messages are dropped after this long without a new fragment, default 10 minutes
+	MaxMessages int           // Incomplete messages kept at once, the oldest is dropped first, default 64
+	MaxSize     int           // Largest message accepted in bytes, default 1 MiB
+}
+
+// Incomplete describes a message that was dropped before all fragments arrived
+type Incomplete struct {
+	Source  string
+	ID      uint32
+	Total   uint16
+	Missing []uint16
+}
+
+type message struct {
+	source    string
+	id        uint32
+	total     uint16
+	fragments map[uint16][]byte
+	size      int
+	updated   time.Time
+}
+
+func (m *message) missing() []uint16 {
+	missing := make([]uint16, 0, int(m.total)-len(m.fragments))
+	for i := uint16(0); i < m.total; i++ {
+		if _, ok := m.fragments[i]; !ok {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
+type Reassembler struct {
+	params Params
+
+	m         sync.Mutex
+	messages  map[string]*message
+	completed map[string]time.Time // Recently completed messages, keyed like messages
+}
+
+func NewReassembler(params Params) *Reassembler {
+	if params.Timeout == 0 {
+		params.Timeout = 10 * time.Minute
+	}
+	if params.MaxMessages == 0 {
+		params.MaxMessages = 64
+	}
+	if params.MaxSize == 0 {
+		params.MaxSize = 1 << 20
+	}
+	return &Reassembler{params: params, messages: make(map[string]*message), completed: make(map[string]time.Time)}
+}
+
+func messageKey(source string, id uint32) string {
+	return fmt.Sprintf("%s/%08x", strings.ToUpper(source), id)
+}
+
+// Add stores a fragment from source and returns the message once it is complete
+func (r *Reassembler) Add(source string, f Fragment) ([]byte, bool, error) {
+	if f.Total == 0 || f.Index >= f.Total {
+		return nil, false, fmt.Errorf("%w: index %d of %d", ErrMalformed, f.Index, f.Total)
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	key := messageKey(source, f.ID)
+	if done, ok := r.completed[key]; ok {
+		if time.Since(done) <= r.params.Timeout {
+			return nil, false, nil
+		}
+		delete(r.completed, key)
+	}
+	m, ok := r.messages[key]
+	if !ok {
+		if len(r.messages) >= r.params.MaxMessages {
+			r.dropOldest()
+		}
+		m = &message{source: strings.ToUpper(source), id: f.ID, total: f.Total, fragments: make(map[uint16][]byte)}
+		r.messages[key] = m
+	}
+	if m.total != f.Total {
+		return nil, false, fmt.Errorf("%w: %s has %d fragments, got one of %d", ErrMismatch, key, m.total, f.Total)
+	}
+	m.updated = time.Now()
+
+	if _, dup := m.fragments[f.Index]; dup {
+		return nil, false, nil
+	}
+	if m.size+len(f.Data) > r.params.MaxSize {
+		delete(r.messages, key)
+		return nil, false, fmt.Errorf("message %s is larger than %d bytes", key, r.params.MaxSize)
+	}
+	m.fragments[f.Index] = append([]byte{}, f.Data...)
+	m.size += len(f.Data)
+
+	if len(m.fragments) < int(m.total) {
+		return nil, false, nil
+	}
+
+	data := make([]byte, 0, m.size)
+	for i := uint16(0); i < m.total; i++ {
+		data = append(data, m.fragments[i]...)
+	}
+	delete(r.messages, key)
+	r.completed[key] = time.Now()
+	return data, true, nil
+}
+
+// dropOldest removes the least recently updated message, called with m held
+func (r *Reassembler) dropOldest() {
+	var oldest string
+	for key, m := range r.messages {
+		if oldest == "" || m.updated.Before(r.messages[oldest].updated) {
+			oldest = key
+		}
+	}
+	delete(r.messages, oldest)
+}
+
+// Missing returns the indexes still missing for a message, false if the message is unknown
+func (r *Reassembler) Missing(source string, id uint32) ([]uint16, bool) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	m, ok := r.messages[messageKey(source, id)]
+	if !ok {
+		return nil, false
+	}
+	return m.missing(), true
+}
+
+// Expire drops messages that timed out and returns what was missing from them
+func (r *Reassembler) Expire() []Incomplete {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	var expired []Incomplete
+	now := time.Now()
+	for key, m := range r.messages {
+		if now.Sub(m.updated) <= r.params.Timeout {
+			continue
+		}
+		expired = append(expired, Incomplete{Source: m.source, ID: m.id, Total: m.total, Missing: m.missing()})
+		delete(r.messages, key)
+	}
+	for key, done := range r.completed {
+		if now.Sub(done) > r.params.Timeout {
+			delete(r.completed, key)
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool {
+		if expired[i].Source != expired[j].Source {
+			return expired[i].Source < expired[j].Source
+		}
+		return expired[i].ID < expired[j].ID
+	})
+	return expired
+}
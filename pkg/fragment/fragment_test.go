@@ -0,0 +1,156 @@
+package fragment_test
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/8ff/udarp/pkg/fragment"
+	"github.com/8ff/udarp/pkg/rs"
+)
+
+func TestSplitFitsChunk(t *testing.T) {
+	params := rs.Params{DataShards: 5, ParityShards: 3, ChunkSize: 8}
+	data := make([]byte, 1000)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	fragments, err := fragment.Split(1, data, fragment.SizeFor(params))
+	if err != nil {
+		t.Fatalf("Split failed with error: %v", err)
+	}
+	if len(fragments) != 32 {
+		t.Fatalf("Expected 32 fragments, got %d", len(fragments))
+	}
+	for _, f := range fragments {
+		encoded, err := f.MarshalBinary()
+		if err != nil {
+			t.Fatalf("MarshalBinary failed with error: %v", err)
+		}
+		if _, _, err := rs.Chunk(params, encoded); err != nil {
+			t.Fatalf("Fragment %d does not fit in a chunk: %v", f.Index, err)
+		}
+	}
+}
+
+func TestReassembleOutOfOrder(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	data := make([]byte, 500)
+	r.Read(data)
+	fragments, _ := fragment.Split(42, data, 37)
+
+	// Shuffle and deliver some fragments twice
+	delivered := append([]fragment.Fragment{}, fragments...)
+	delivered = append(delivered, fragments[3], fragments[7])
+	r.Shuffle(len(delivered), func(i, j int) { delivered[i], delivered[j] = delivered[j], delivered[i] })
+
+	re := fragment.NewReassembler(fragment.Params{})
+	var result []byte
+	for i, f := range delivered {
+		encoded, _ := f.MarshalBinary()
+		var received fragment.Fragment
+		if err := received.UnmarshalBinary(encoded); err != nil {
+			t.Fatalf("UnmarshalBinary failed with error: %v", err)
+		}
+		msg, done, err := re.Add("k1abc", received)
+		if err != nil {
+			t.Fatalf("Add failed with error: %v", err)
+		}
+		if done {
+			if result != nil {
+				t.Fatalf("Message completed twice")
+			}
+			result = msg
+			continue
+		}
+		if result == nil && i < len(delivered)-1 {
+			if missing, ok := re.Missing("K1ABC", 42); !ok || len(missing) == 0 {
+				t.Fatalf("Expected missing fragments while incomplete")
+			}
+		}
+	}
+	if !bytes.Equal(result, data) {
+		t.Fatalf("Reassembled message does not match")
+	}
+	if _, ok := re.Missing("K1ABC", 42); ok {
+		t.Fatalf("Completed messages should be forgotten")
+	}
+}
+
+func TestMissingAndExpire(t *testing.T) {
+	fragments, _ := fragment.Split(7, make([]byte, 50), 10)
+	re := fragment.NewReassembler(fragment.Params{Timeout: 10 * time.Millisecond})
+
+	for _, i := range []int{0, 2, 4} {
+		if _, done, err := re.Add("K1ABC", fragments[i]); done || err != nil {
+			t.Fatalf("Add returned %v %v", done, err)
+		}
+	}
+	missing, ok := re.Missing("K1ABC", 7)
+	if !ok || len(missing) != 2 || missing[0] != 1 || missing[1] != 3 {
+		t.Fatalf("Expected fragments 1 and 3 missing, got %v", missing)
+	}
+
+	// A different source with the same id is a different message
+	if _, ok := re.Missing("KA1XYZ", 7); ok {
+		t.Fatalf("Messages should be kept per source")
+	}
+	wrong := fragments[1]
+	wrong.Total = 6
+	if _, _, err := re.Add("K1ABC", wrong); !errors.Is(err, fragment.ErrMismatch) {
+		t.Fatalf("Expected ErrMismatch for a different total, got %v", err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	expired := re.Expire()
+	if len(expired) != 1 || expired[0].Source != "K1ABC" || expired[0].ID != 7 || len(expired[0].Missing) != 2 {
+		t.Fatalf("Unexpected expired list %+v", expired)
+	}
+	if _, ok := re.Missing("K1ABC", 7); ok {
+		t.Fatalf("Expired message should be forgotten")
+	}
+}
+
+func TestDuplicatesAfterCompletion(t *testing.T) {
+	re := fragment.NewReassembler(fragment.Params{Timeout: 20 * time.Millisecond})
+
+	single, _ := fragment.Split(1, []byte("hi"), 10)
+	if _, done, _ := re.Add("K1ABC", single[0]); !done {
+		t.Fatalf("Single fragment message should complete")
+	}
+	if _, done, err := re.Add("K1ABC", single[0]); done || err != nil {
+		t.Fatalf("Retransmitted single fragment was delivered again: %v %v", done, err)
+	}
+
+	fragments, _ := fragment.Split(2, make([]byte, 30), 10)
+	for _, f := range fragments {
+		re.Add("K1ABC", f)
+	}
+	if _, done, _ := re.Add("K1ABC", fragments[1]); done {
+		t.Fatalf("Late duplicate completed the message again")
+	}
+	if _, ok := re.Missing("K1ABC", 2); ok {
+		t.Fatalf("Late duplicate started a new message")
+	}
+
+	// Once the timeout passed the id can be used again
+	time.Sleep(30 * time.Millisecond)
+	if expired := re.Expire(); len(expired) != 0 {
+		t.Fatalf("Completed messages should not expire as incomplete, got %+v", expired)
+	}
+	if _, done, _ := re.Add("K1ABC", single[0]); !done {
+		t.Fatalf("Expected the id to be reusable after the timeout")
+	}
+}
+
+func TestEmptyMessage(t *testing.T) {
+	fragments, err := fragment.Split(1, nil, 10)
+	if err != nil || len(fragments) != 1 {
+		t.Fatalf("Empty message should take one fragment, got %d (%v)", len(fragments), err)
+	}
+	msg, done, err := fragment.NewReassembler(fragment.Params{}).Add("K1ABC", fragments[0])
+	if !done || err != nil || len(msg) != 0 {
+		t.Fatalf("Expected empty message, got %v %v %v", msg, done, err)
+	}
+}
@@ -0,0 +1,307 @@
+package frame
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/8ff/udarp/pkg/pack"
+)
+
+/*
+UDARP frame format, every higher level feature is carried in one of these.
+
+Header, big endian, HeaderSize bytes:
+	0      Version (4) | Type (4)
+	1      Flags
+	2..5   Source callsign, 28 bit packed
+	6..9   Destination callsign, 28 bit packed, Broadcast for everyone
+	10..11 Sequence
+	12..13 Payload length
+Followed by a Route when FlagRouted is set, then Length bytes of payload.
+*/
+
+const (
+	Version    = 1
+	HeaderSize = 14
+	MaxPayload = 0xFFFF
+
+	// Destination of frames meant for every station
+	Broadcast = "CQ"
+	// Packed value of Broadcast, outside of the packed callsign range
+	broadcastPacked = 1<<pack.CallsignBits - 1
+)
+
+type Type uint8
+
+const (
+	TypeBeacon Type = iota
+	TypeText
+	TypeControl
+	TypeBBS
+	TypeEmail
+	TypeSMS
+	TypeAck
+	TypeSession // Connected mode, see pkg/arq
+	TypeRelay   // Relay reachability table, see pkg/relay
+	TypeFile    // File transfer, see pkg/filetransfer
+	typeCount
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeBeacon:
+		return "beacon"
+	case TypeText:
+		return "text"
+	case TypeControl:
+		return "control"
+	case TypeBBS:
+		return "bbs"
+	case TypeEmail:
+		return "email"
+	case TypeSMS:
+		return "sms"
+	case TypeAck:
+		return "ack"
+	case TypeSession:
+		return "session"
+	case TypeRelay:
+		return "relay"
+	case TypeFile:
+		return "file"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(t))
+	}
+}
+
+type Flags uint8
+
+const (
+	// Lowest 2 bits hold the Encoding of the payload
+	FlagEncoding Flags = 0x03
+	// A Route follows the header, set and cleared by MarshalBinary from Header.Route
+	FlagRouted Flags = 0x04
+	// The payload ends with an authentication trailer, see pkg/auth
+	FlagAuth Flags = 0x08
+)
+
+// Flags that are defined, frames with any other flag set are rejected
+const knownFlags = FlagEncoding | FlagRouted | FlagAuth
+
+// Encoding of the payload, set for text payloads that were compressed
+type Encoding uint8
+
+const (
+	EncodingRaw      Encoding = iota // Payload as is
+	EncodingAlphabet                 // Restricted 42 character alphabet
+	EncodingHuffman                  // Adaptive huffman
+	encodingCount
+)
+
+var (
+	ErrShort    = errors.New("frame is too short")
+	ErrLength   = errors.New("payload length does not match")
+	ErrVersion  = errors.New("unsupported protocol version")
+	ErrType     = errors.New("unknown message type")
+	ErrFlags    = errors.New("unknown flags set")
+	ErrAddress  = errors.New("invalid address")
+	ErrTooLarge = errors.New("payload is too large")
+)
+
+type Header struct {
+	Version     uint8
+	Type        Type
+	Flags       Flags
+	Source      string
+	Destination string
+	Sequence    uint16
+	Length      uint16
+	Route       *Route // Source route for relayed frames, nil for direct frames
+}
+
+// Validate checks the header against everything this version of the protocol knows
+func (h Header) Validate() error {
+	if h.Version != Version {
+		return fmt.Errorf("%w: %d", ErrVersion, h.Version)
+	}
+	if h.Type >= typeCount {
+		return fmt.Errorf("%w: %d", ErrType, h.Type)
+	}
+	if h.Flags&^knownFlags != 0 {
+		return fmt.Errorf("%w: %08b", ErrFlags, h.Flags&^knownFlags)
+	}
+	if h.Encoding() >= encodingCount {
+		return fmt.Errorf("%w: unknown encoding %d", ErrFlags, h.Encoding())
+	}
+	if h.Source == Broadcast {
+		return fmt.Errorf("%w: source can not be %s", ErrAddress, Broadcast)
+	}
+	if _, err := packAddress(h.Source); err != nil {
+		return fmt.Errorf("source: %w", err)
+	}
+	if _, err := packAddress(h.Destination); err != nil {
+		return fmt.Errorf("destination: %w", err)
+	}
+	if h.Route != nil {
+		if err := h.Route.Validate(); err != nil {
+			return fmt.Errorf("route: %w", err)
+		}
+	}
+	return nil
+}
+
+// Size returns the number of bytes the header takes on the air
+func (h Header) Size() int {
+	if h.Route != nil {
+		return HeaderSize + h.Route.size()
+	}
+	return HeaderSize
+}
+
+func (h Header) Encoding() Encoding {
+	return Encoding(h.Flags & FlagEncoding)
+}
+
+func (h *Header) SetEncoding(e Encoding) {
+	h.Flags = h.Flags&^FlagEncoding | Flags(e)&FlagEncoding
+}
+
+func packAddress(call string) (uint32, error) {
+	if call == Broadcast {
+		return broadcastPacked, nil
+	}
+	packed, err := pack.PackCallsign(call)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrAddress, err)
+	}
+	return packed, nil
+}
+
+func unpackAddress(packed uint32) (string, error) {
+	if packed == broadcastPacked {
+		return Broadcast, nil
+	}
+	call, err := pack.UnpackCallsign(packed)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrAddress, err)
+	}
+	return call, nil
+}
+
+func (h Header) MarshalBinary() ([]byte, error) {
+	if err := h.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Validate already made sure these pack
+	source, _ := packAddress(h.Source)
+	destination, _ := packAddress(h.Destination)
+
+	flags := h.Flags &^ FlagRouted
+	if h.Route != nil {
+		flags |= FlagRouted
+	}
+
+	data := make([]byte, HeaderSize, h.Size())
+	data[0] = h.Version<<4 | uint8(h.Type)
+	data[1] = uint8(flags)
+	binary.BigEndian.PutUint32(data[2:6], source)
+	binary.BigEndian.PutUint32(data[6:10], destination)
+	binary.BigEndian.PutUint16(data[10:12], h.Sequence)
+	binary.BigEndian.PutUint16(data[12:14], h.Length)
+	if h.Route != nil {
+		data = h.Route.appendBinary(data)
+	}
+	return data, nil
+}
+
+// UnmarshalBinary parses the first Size bytes of data
+func (h *Header) UnmarshalBinary(data []byte) error {
+	if len(data) < HeaderSize {
+		return fmt.Errorf("%w: %d bytes", ErrShort, len(data))
+	}
+
+	parsed := Header{
+		Version:  data[0] >> 4,
+		Type:     Type(data[0] & 0x0F),
+		Flags:    Flags(data[1]),
+		Sequence: binary.BigEndian.Uint16(data[10:12]),
+		Length:   binary.BigEndian.Uint16(data[12:14]),
+	}
+
+	// Check version first, the rest of the layout depends on it
+	if parsed.Version != Version {
+		return fmt.Errorf("%w: %d", ErrVersion, parsed.Version)
+	}
+
+	var err error
+	parsed.Source, err = unpackAddress(binary.BigEndian.Uint32(data[2:6]))
+	if err != nil {
+		return fmt.Errorf("source: %w", err)
+	}
+	parsed.Destination, err = unpackAddress(binary.BigEndian.Uint32(data[6:10]))
+	if err != nil {
+		return fmt.Errorf("destination: %w", err)
+	}
+
+	if parsed.Flags&FlagRouted != 0 {
+		parsed.Route = &Route{}
+		if err := parsed.Route.unmarshalBinary(data[HeaderSize:]); err != nil {
+			return fmt.Errorf("route: %w", err)
+		}
+	}
+
+	if err := parsed.Validate(); err != nil {
+		return err
+	}
+
+	*h = parsed
+	return nil
+}
+
+type Frame struct {
+	Header
+	Payload []byte
+}
+
+// New returns a frame of the current version, Length is filled in when marshalling
+func New(t Type, source, destination string, sequence uint16, payload []byte) Frame {
+	return Frame{
+		Header: Header{
+			Version:     Version,
+			Type:        t,
+			Source:      source,
+			Destination: destination,
+			Sequence:    sequence,
+		},
+		Payload: payload,
+	}
+}
+
+func (f Frame) MarshalBinary() ([]byte, error) {
+	if len(f.Payload) > MaxPayload {
+		return nil, fmt.Errorf("%w: %d bytes", ErrTooLarge, len(f.Payload))
+	}
+	f.Length = uint16(len(f.Payload))
+
+	header, err := f.Header.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return append(header, f.Payload...), nil
+}
+
+func (f *Frame) UnmarshalBinary(data []byte) error {
+	var h Header
+	if err := h.UnmarshalBinary(data); err != nil {
+		return err
+	}
+	if len(data)-h.Size() != int(h.Length) {
+		return fmt.Errorf("%w: header says %d bytes, got %d", ErrLength, h.Length, len(data)-h.Size())
+	}
+
+	f.Header = h
+	f.Payload = append([]byte{}, data[h.Size():]...)
+	return nil
+}
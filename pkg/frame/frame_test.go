@@ -0,0 +1,119 @@
+package frame_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/8ff/udarp/pkg/frame"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	f := frame.New(frame.TypeText, "K1ABC", "EA8BFK", 42, []byte("hello"))
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed with error: %v", err)
+	}
+	if len(data) != frame.HeaderSize+5 {
+		t.Fatalf("Expected %d bytes, got %d", frame.HeaderSize+5, len(data))
+	}
+
+	var decoded frame.Frame
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary failed with error: %v", err)
+	}
+	if decoded.Header != (frame.Header{Version: frame.Version, Type: frame.TypeText, Source: "K1ABC", Destination: "EA8BFK", Sequence: 42, Length: 5}) {
+		t.Fatalf("Decoded header %+v doesn't match", decoded.Header)
+	}
+	if !bytes.Equal(decoded.Payload, []byte("hello")) {
+		t.Fatalf("Decoded payload doesn't match")
+	}
+
+	// Broadcast destination and empty payload
+	f = frame.New(frame.TypeBeacon, "K1ABC", frame.Broadcast, 0, nil)
+	data, _ = f.MarshalBinary()
+	if err := decoded.UnmarshalBinary(data); err != nil || decoded.Destination != frame.Broadcast || len(decoded.Payload) != 0 {
+		t.Fatalf("Broadcast frame did not round trip (err: %v)", err)
+	}
+}
+
+func TestFrameValidation(t *testing.T) {
+	good, _ := frame.New(frame.TypeText, "K1ABC", "EA8BFK", 1, []byte("hi")).MarshalBinary()
+
+	corrupt := func(f func([]byte)) []byte {
+		data := append([]byte{}, good...)
+		f(data)
+		return data
+	}
+
+	for name, test := range map[string]struct {
+		data []byte
+		err  error
+	}{
+		"short":     {good[:frame.HeaderSize-1], frame.ErrShort},
+		"length":    {good[:len(good)-1], frame.ErrLength},
+		"extra":     {append(append([]byte{}, good...), 0), frame.ErrLength},
+		"version":   {corrupt(func(d []byte) { d[0] = 2<<4 | d[0]&0x0F }), frame.ErrVersion},
+		"type":      {corrupt(func(d []byte) { d[0] = d[0]&0xF0 | 0x0F }), frame.ErrType},
+		"flags":     {corrupt(func(d []byte) { d[1] = 0x80 }), frame.ErrFlags},
+		"encoding":  {corrupt(func(d []byte) { d[1] = 0x03 }), frame.ErrFlags},
+		"source":    {corrupt(func(d []byte) { d[2] = 0xFF }), frame.ErrAddress},
+		"broadcast": {corrupt(func(d []byte) { copy(d[2:6], []byte{0x0F, 0xFF, 0xFF, 0xFF}) }), frame.ErrAddress},
+	} {
+		var f frame.Frame
+		err := f.UnmarshalBinary(test.data)
+		if !errors.Is(err, test.err) {
+			t.Fatalf("%s: expected %v, got %v", name, test.err, err)
+		}
+	}
+
+	if _, err := frame.New(frame.TypeText, "EA8/W1ABC/P", "K1ABC", 1, nil).MarshalBinary(); !errors.Is(err, frame.ErrAddress) {
+		t.Fatalf("Expected address error for nonstandard source, got %v", err)
+	}
+	if _, err := frame.New(frame.TypeText, "K1ABC", "K1ABC", 1, make([]byte, frame.MaxPayload+1)).MarshalBinary(); !errors.Is(err, frame.ErrTooLarge) {
+		t.Fatalf("Expected payload too large error, got %v", err)
+	}
+}
+
+func TestRouteRoundTrip(t *testing.T) {
+	f := frame.New(frame.TypeText, "K1ABC", "EA8BFK", 3, []byte("via"))
+	f.Route = frame.NewRoute(0xDEADBEEF, "W1AW", "KA1XYZ")
+	f.Route = f.Route.Forwarded()
+
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed with error: %v", err)
+	}
+	if len(data) != f.Size()+3 || f.Size() != frame.HeaderSize+7+2*4 {
+		t.Fatalf("Unexpected size %d for routed frame", len(data))
+	}
+
+	var decoded frame.Frame
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary failed with error: %v", err)
+	}
+	r := decoded.Route
+	if r == nil || r.ID != 0xDEADBEEF || r.HopLimit != frame.DefaultHopLimit-1 || r.Next != 1 || len(r.Path) != 2 || r.Path[1] != "KA1XYZ" {
+		t.Fatalf("Decoded route %+v doesn't match", r)
+	}
+	if next, ok := r.NextHop(); !ok || next != "KA1XYZ" {
+		t.Fatalf("Expected KA1XYZ as next hop, got %q", next)
+	}
+	if traversed := r.Traversed(); len(traversed) != 1 || traversed[0] != "W1AW" {
+		t.Fatalf("Expected W1AW as the relay record, got %v", traversed)
+	}
+	if !bytes.Equal(decoded.Payload, []byte("via")) {
+		t.Fatalf("Decoded payload doesn't match")
+	}
+
+	f.Route = &frame.Route{Next: 1}
+	if _, err := f.MarshalBinary(); !errors.Is(err, frame.ErrAddress) {
+		t.Fatalf("Expected address error for next hop past the path, got %v", err)
+	}
+	f.Route = nil
+	data, _ = f.MarshalBinary()
+	data[1] |= byte(frame.FlagRouted)
+	if err := decoded.UnmarshalBinary(data); err == nil {
+		t.Fatalf("Expected error for routed flag without a route")
+	}
+}
@@ -0,0 +1,120 @@
+package frame
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+/*
+Source route carried by relayed frames, right after the header.
+
+	0      Hop limit, relays drop the frame when it reaches 0
+	1      Path length
+	2      Next, index of the next relay in the path
+	3..6   Message ID, chosen by the source, used for duplicate suppression
+	7..    Path, 28 bit packed relay callsigns, 4 bytes each
+
+Every relay forwarding the frame increments Next and decrements the hop limit, so Path[:Next]
+is the record of relays the frame went through.
+*/
+
+const (
+	MaxPath         = 8
+	DefaultHopLimit = MaxPath
+
+	routeHeaderSize  = 7
+	routeAddressSize = 4
+)
+
+type Route struct {
+	ID       uint32
+	HopLimit uint8
+	Next     uint8
+	Path     []string
+}
+
+// NewRoute returns a route through path with the default hop limit
+func NewRoute(id uint32, path ...string) *Route {
+	return &Route{ID: id, HopLimit: DefaultHopLimit, Path: append([]string{}, path...)}
+}
+
+func (r Route) Validate() error {
+	if len(r.Path) > MaxPath {
+		return fmt.Errorf("%w: path is longer than %d", ErrAddress, MaxPath)
+	}
+	if int(r.Next) > len(r.Path) {
+		return fmt.Errorf("%w: next hop %d is past the end of the path", ErrAddress, r.Next)
+	}
+	for _, call := range r.Path {
+		if call == Broadcast {
+			return fmt.Errorf("%w: relay can not be %s", ErrAddress, Broadcast)
+		}
+		if _, err := packAddress(call); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NextHop returns the relay that should forward the frame next, false once the path is done
+func (r Route) NextHop() (string, bool) {
+	if int(r.Next) >= len(r.Path) {
+		return "", false
+	}
+	return r.Path[r.Next], true
+}
+
+// Traversed returns the relays the frame went through so far
+func (r Route) Traversed() []string {
+	return append([]string{}, r.Path[:r.Next]...)
+}
+
+// Forwarded returns a copy of the route as it should be sent on by the next hop
+func (r Route) Forwarded() *Route {
+	fwd := r
+	fwd.Path = append([]string{}, r.Path...)
+	fwd.Next++
+	fwd.HopLimit--
+	return &fwd
+}
+
+func (r Route) size() int {
+	return routeHeaderSize + routeAddressSize*len(r.Path)
+}
+
+// appendBinary appends the route, Validate must have passed
+func (r Route) appendBinary(data []byte) []byte {
+	data = append(data, r.HopLimit, uint8(len(r.Path)), r.Next)
+	data = binary.BigEndian.AppendUint32(data, r.ID)
+	for _, call := range r.Path {
+		packed, _ := packAddress(call)
+		data = binary.BigEndian.AppendUint32(data, packed)
+	}
+	return data
+}
+
+func (r *Route) unmarshalBinary(data []byte) error {
+	if len(data) < routeHeaderSize {
+		return fmt.Errorf("%w: %d route bytes", ErrShort, len(data))
+	}
+	parsed := Route{HopLimit: data[0], Next: data[2], ID: binary.BigEndian.Uint32(data[3:7])}
+	n := int(data[1])
+	if n > MaxPath {
+		return fmt.Errorf("%w: path is longer than %d", ErrAddress, MaxPath)
+	}
+	if len(data) < parsed.size()+routeAddressSize*n {
+		return fmt.Errorf("%w: %d route bytes", ErrShort, len(data))
+	}
+
+	for i := 0; i < n; i++ {
+		off := routeHeaderSize + routeAddressSize*i
+		call, err := unpackAddress(binary.BigEndian.Uint32(data[off : off+routeAddressSize]))
+		if err != nil {
+			return err
+		}
+		parsed.Path = append(parsed.Path, call)
+	}
+
+	*r = parsed
+	return nil
+}
@@ -0,0 +1,251 @@
+package fskModem
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/8ff/udarp/pkg/frame"
+)
+
+/*
+Frames over the on/off keyed tone made by fskGenerator.FlexFsk.
+
+A frame goes on the air as Sync followed by the marshalled frame, MSB first, one bit per BitDurationMS.
+
+The receiver measures the tone level steps times per bit with a Goertzel filter, a bit is the sum of
+the steps in the middle half of it, the pulse shaping of FlexFsk keeps the tone off the rest. While
+hunting, every step is tried as the end of Sync and the one where the sync stands out the most from
+the gaps between its on bits is locked on. From there one bit is sliced every steps steps, halfway
+between the on and off levels seen in the sync, until the header says how long the frame is. A
+header that does not parse means the sync was noise and hunting starts over.
+*/
+
+// Sync is the 13 bit Barker code sent in front of every frame
+var Sync = []int{1, 1, 1, 1, 1, 0, 0, 1, 1, 0, 1, 0, 1}
+
+// Level measurements per bit
+const steps = 8
+
+type Params struct {
+	SampleRate    int
+	BitDurationMS int
+	ToneFreq      float64
+	MinContrast   float64 // Weakest on bit of the sync over the strongest off bit, 4 when unset
+}
+
+type Decoder struct {
+	params    Params
+	bitLen    int     // Samples per bit, the same rounding as FlexFsk
+	k         float64 // Goertzel coefficient
+	minMargin float64 // MinContrast as (on-off)/(on+off)
+
+	// Goertzel state of the step being measured
+	s1, s2 float64
+	pos    int // Samples into the current bit
+	step   int // Steps into the current bit
+
+	levels []float64 // Tone level per step, levels[0] is step base
+	base   int
+
+	// Best sync candidate while hunting, bestEnd is the last step of it
+	best    float64
+	bestEnd int
+	found   bool
+
+	// Frame being received once locked, next is the first step of the next bit
+	locked bool
+	next   int
+	slice  float64
+	bits   int
+	data   []byte
+	total  int // Bytes in the frame, 0 until the header is in
+}
+
+// Encode returns the bits to key for f, Sync first
+func Encode(f frame.Frame) ([]int, error) {
+	data, err := f.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	bits := make([]int, 0, len(Sync)+len(data)*8)
+	bits = append(bits, Sync...)
+	for _, b := range data {
+		for i := 7; i >= 0; i-- {
+			bits = append(bits, int(b>>uint(i))&1)
+		}
+	}
+	return bits, nil
+}
+
+func New(params Params) (*Decoder, error) {
+	if params.MinContrast == 0 {
+		params.MinContrast = 4
+	}
+	if params.MinContrast < 1 {
+		return nil, fmt.Errorf("min contrast must be at least 1")
+	}
+	bitLen := (params.SampleRate / 1000) * params.BitDurationMS
+	if bitLen < steps {
+		return nil, fmt.Errorf("a bit must be at least %d samples long", steps)
+	}
+	if params.ToneFreq <= 0 || params.ToneFreq >= float64(params.SampleRate)/2 {
+		return nil, fmt.Errorf("tone must be between 0 and %d Hz", params.SampleRate/2)
+	}
+	return &Decoder{
+		params:    params,
+		bitLen:    bitLen,
+		k:         2 * math.Cos(2*math.Pi*params.ToneFreq/float64(params.SampleRate)),
+		minMargin: (params.MinContrast - 1) / (params.MinContrast + 1),
+	}, nil
+}
+
+// Write takes capture samples in -1..1 and returns the frames completed by them
+func (d *Decoder) Write(samples []float32) []frame.Frame {
+	var frames []frame.Frame
+	for _, x := range samples {
+		d.s1, d.s2 = float64(x)+d.k*d.s1-d.s2, d.s1
+		d.pos++
+		// Steps end at fractions of the bit so they don't drift when the bit doesn't divide evenly
+		if d.pos < (d.step+1)*d.bitLen/steps {
+			continue
+		}
+		length := float64(d.bitLen) / steps
+		power := d.s1*d.s1 + d.s2*d.s2 - d.k*d.s1*d.s2
+		d.levels = append(d.levels, 2*math.Sqrt(math.Max(power, 0))/length)
+		d.s1, d.s2 = 0, 0
+		d.step++
+		if d.step == steps {
+			d.step, d.pos = 0, 0
+		}
+
+		if f, ok := d.process(); ok {
+			frames = append(frames, f)
+		}
+	}
+	return frames
+}
+
+// bit returns the level of the bit starting at step, from the middle half of it where FlexFsk keys the tone
+func (d *Decoder) bit(step int) float64 {
+	sum := 0.0
+	for _, level := range d.levels[step-d.base+steps/4 : step-d.base+steps*3/4] {
+		sum += level
+	}
+	return sum
+}
+
+// end returns the index of the last measured step
+func (d *Decoder) end() int {
+	return d.base + len(d.levels) - 1
+}
+
+// process runs after every new step
+func (d *Decoder) process() (frame.Frame, bool) {
+	if d.locked {
+		return d.receive()
+	}
+
+	last := d.end()
+	first := last - len(Sync)*steps + 1
+	if first < d.base {
+		return frame.Frame{}, false
+	}
+
+	minOn, maxOff := math.Inf(1), 0.0
+	var on, off float64
+	for i, s := range Sync {
+		level := d.bit(first + i*steps)
+		if s == 1 {
+			minOn = math.Min(minOn, level)
+			on += level
+		} else {
+			maxOff = math.Max(maxOff, level)
+			off += level
+		}
+	}
+	if minOn > 0 {
+		if margin := (minOn - maxOff) / (minOn + maxOff); margin >= d.minMargin && (!d.found || margin > d.best) {
+			ones := 0
+			for _, s := range Sync {
+				ones += s
+			}
+			d.best, d.bestEnd, d.found = margin, last, true
+			d.slice = (on/float64(ones) + off/float64(len(Sync)-ones)) / 2
+		}
+	}
+
+	// Lock once a whole bit went by without a better candidate
+	if d.found && last-d.bestEnd >= steps {
+		d.locked = true
+		d.next = d.bestEnd + 1
+		d.found = false
+		return d.receive()
+	}
+
+	// Keep what the next sync check needs
+	d.trim(last - len(Sync)*steps + 1)
+	return frame.Frame{}, false
+}
+
+// receive slices the bits that are complete and returns the frame once it is
+func (d *Decoder) receive() (frame.Frame, bool) {
+	for d.next+steps-1 <= d.end() {
+		bit := 0
+		if d.bit(d.next) > d.slice {
+			bit = 1
+		}
+		d.next += steps
+
+		d.bits++
+		if d.bits%8 == 1 {
+			d.data = append(d.data, 0)
+		}
+		d.data[len(d.data)-1] |= byte(bit) << uint(7-(d.bits-1)%8)
+		if d.bits%8 != 0 {
+			continue
+		}
+
+		if d.total == 0 && len(d.data) >= frame.HeaderSize {
+			var h frame.Header
+			err := h.UnmarshalBinary(d.data)
+			switch {
+			case errors.Is(err, frame.ErrShort):
+				// Route is still coming in
+			case err != nil:
+				d.reset()
+				return frame.Frame{}, false
+			default:
+				d.total = h.Size() + int(h.Length)
+			}
+		}
+		if d.total > 0 && len(d.data) == d.total {
+			var f frame.Frame
+			err := f.UnmarshalBinary(d.data)
+			d.reset()
+			return f, err == nil
+		}
+	}
+	d.trim(d.next)
+	return frame.Frame{}, false
+}
+
+// reset goes back to hunting from the current step
+func (d *Decoder) reset() {
+	d.locked = false
+	d.bits, d.data, d.total = 0, nil, 0
+	d.trim(d.next)
+}
+
+// trim drops the levels of steps before step
+func (d *Decoder) trim(step int) {
+	if step <= d.base {
+		return
+	}
+	if n := step - d.base; n < len(d.levels) {
+		d.levels = append(d.levels[:0], d.levels[n:]...)
+	} else {
+		d.levels = d.levels[:0]
+	}
+	d.base = step
+}
@@ -0,0 +1,122 @@
+package fskModem_test
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/8ff/udarp/pkg/audio"
+	"github.com/8ff/udarp/pkg/frame"
+	"github.com/8ff/udarp/pkg/fskGenerator"
+	"github.com/8ff/udarp/pkg/fskModem"
+)
+
+var params = fskModem.Params{SampleRate: 8000, BitDurationMS: 20, ToneFreq: 1000}
+
+// air keys frames the way the transmitter does and adds gaps and noise
+func air(t *testing.T, r *rand.Rand, noise float64, frames ...frame.Frame) []float32 {
+	var samples []float32
+	gap := func() {
+		for i := r.Intn(3000) + 500; i > 0; i-- {
+			samples = append(samples, 0)
+		}
+	}
+	gap()
+	for _, f := range frames {
+		bits, err := fskModem.Encode(f)
+		if err != nil {
+			t.Fatalf("Encode failed with error: %v", err)
+		}
+		pcm := fskGenerator.FlexFsk(params.SampleRate, params.BitDurationMS, params.ToneFreq, bits)
+		converted := make([]float32, len(pcm)/2)
+		audio.S16ToFloat32(converted, pcm)
+		samples = append(samples, converted...)
+		gap()
+	}
+	for i := range samples {
+		samples[i] += float32(r.NormFloat64() * noise)
+	}
+	return samples
+}
+
+func TestFramesOverTheAir(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	sent := []frame.Frame{
+		frame.New(frame.TypeFile, "K1ABC", "KA1XYZ", 1, []byte("first frame")),
+		frame.New(frame.TypeText, "KA1XYZ", frame.Broadcast, 2, bytes.Repeat([]byte{0x00, 0xFF}, 20)),
+	}
+	routed := frame.New(frame.TypeText, "K1ABC", "W9XYZ", 3, []byte("via"))
+	routed.Route = &frame.Route{HopLimit: 2, Path: []string{"KA1XYZ"}}
+	sent = append(sent, routed)
+
+	d, err := fskModem.New(params)
+	if err != nil {
+		t.Fatalf("New failed with error: %v", err)
+	}
+
+	// Capture arrives in blocks that have nothing to do with bits
+	samples := air(t, r, 0.3, sent...)
+	var received []frame.Frame
+	for len(samples) > 0 {
+		n := r.Intn(700) + 1
+		if n > len(samples) {
+			n = len(samples)
+		}
+		received = append(received, d.Write(samples[:n])...)
+		samples = samples[n:]
+	}
+
+	if len(received) != len(sent) {
+		t.Fatalf("Expected %d frames, got %d", len(sent), len(received))
+	}
+	for i, f := range received {
+		want, _ := sent[i].MarshalBinary()
+		got, _ := f.MarshalBinary()
+		if !bytes.Equal(got, want) {
+			t.Fatalf("Frame %d does not match, got %+v", i, f)
+		}
+	}
+}
+
+func TestNoiseDecodesNothing(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	d, _ := fskModem.New(params)
+	samples := make([]float32, params.SampleRate*600)
+	for i := range samples {
+		samples[i] = float32(r.NormFloat64() * 0.5)
+	}
+	if frames := d.Write(samples); len(frames) != 0 {
+		t.Fatalf("Expected no frames from noise, got %+v", frames)
+	}
+}
+
+func TestBackToBack(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	d, _ := fskModem.New(params)
+
+	// A batch is keyed as one tone, every sync right after the previous frame
+	var bits []int
+	for seq := uint16(1); seq <= 3; seq++ {
+		b, err := fskModem.Encode(frame.New(frame.TypeFile, "K1ABC", "KA1XYZ", seq, []byte("chunk")))
+		if err != nil {
+			t.Fatalf("Encode failed with error: %v", err)
+		}
+		bits = append(bits, b...)
+	}
+	pcm := fskGenerator.FlexFsk(params.SampleRate, params.BitDurationMS, params.ToneFreq, bits)
+	samples := make([]float32, len(pcm)/2+2000)
+	audio.S16ToFloat32(samples, pcm)
+	for i := range samples {
+		samples[i] += float32(r.NormFloat64() * 0.2)
+	}
+
+	received := d.Write(samples)
+	if len(received) != 3 {
+		t.Fatalf("Expected 3 frames, got %d", len(received))
+	}
+	for i, f := range received {
+		if f.Sequence != uint16(i+1) {
+			t.Fatalf("Frame %d has sequence %d", i, f.Sequence)
+		}
+	}
+}
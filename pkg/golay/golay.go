@@ -0,0 +1,121 @@
+package golay
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+/*
+Extended Golay (24,12) code.
+
+12 data bits are protected with 11 check bits from the (23,12) Golay generator polynomial plus an
+overall parity bit, giving minimum distance 8. Up to 3 bit errors are corrected and 4 are detected.
+With only 4096 codewords both hard and soft decoding just search the precomputed codeword table.
+
+Codeword layout, MSB first: 12 data bits | 11 check bits | parity bit
+*/
+
+const (
+	DataBits     = 12
+	CodewordBits = 24
+	// x^11 + x^10 + x^6 + x^5 + x^4 + x^2 + 1
+	generator = 0xC75
+)
+
+var codewords [1 << DataBits]uint32
+
+func init() {
+	for data := range codewords {
+		codewords[data] = encode(uint16(data))
+	}
+}
+
+func encode(data uint16) uint32 {
+	// Remainder of data * x^11 divided by the generator gives the check bits
+	reg := uint32(data) << 11
+	for bit := 22; bit >= 11; bit-- {
+		if reg&(1<<uint(bit)) != 0 {
+			reg ^= generator << uint(bit-11)
+		}
+	}
+	codeword := uint32(data)<<11 | reg
+	return codeword<<1 | uint32(bits.OnesCount32(codeword)&1)
+}
+
+// Encode returns the codeword for the lowest 12 bits of data
+func Encode(data uint16) (uint32, error) {
+	if data >= 1<<DataBits {
+		return 0, fmt.Errorf("data must fit in %d bits", DataBits)
+	}
+	return codewords[data], nil
+}
+
+// Decode corrects up to 3 bit errors in codeword and returns the data and number of corrected bits
+func Decode(codeword uint32) (uint16, int, error) {
+	if codeword >= 1<<CodewordBits {
+		return 0, 0, fmt.Errorf("codeword must fit in %d bits", CodewordBits)
+	}
+
+	best := 0
+	bestDist := CodewordBits + 1
+	for data, c := range codewords {
+		dist := bits.OnesCount32(c ^ codeword)
+		if dist < bestDist {
+			best = data
+			bestDist = dist
+		}
+	}
+
+	// With minimum distance 8, 4 errors can be as close to another codeword as to the sent one
+	if bestDist > 3 {
+		return 0, bestDist, fmt.Errorf("uncorrectable codeword, %d bit errors", bestDist)
+	}
+	return uint16(best), bestDist, nil
+}
+
+// SoftDecode returns the data of the codeword closest to soft values in 0..1, MSB first, and its distance
+func SoftDecode(soft []float64) (uint16, float64, error) {
+	if len(soft) != CodewordBits {
+		return 0, 0, fmt.Errorf("soft decode needs %d values, got %d", CodewordBits, len(soft))
+	}
+
+	best := 0
+	bestDist := 0.0
+	for data, c := range codewords {
+		dist := 0.0
+		for i, v := range soft {
+			bit := float64((c >> uint(CodewordBits-1-i)) & 1)
+			dist += (bit - v) * (bit - v)
+		}
+		if data == 0 || dist < bestDist {
+			best = data
+			bestDist = dist
+		}
+	}
+
+	return uint16(best), bestDist, nil
+}
+
+// ToBits converts a codeword to []int of 1/0s, MSB first
+func ToBits(codeword uint32) []int {
+	output := make([]int, CodewordBits)
+	for i := range output {
+		output[i] = int(codeword>>uint(CodewordBits-1-i)) & 1
+	}
+	return output
+}
+
+// FromBits converts []int of 1/0s, MSB first, to a codeword
+func FromBits(input []int) (uint32, error) {
+	if len(input) != CodewordBits {
+		return 0, fmt.Errorf("codeword needs %d bits, got %d", CodewordBits, len(input))
+	}
+	var codeword uint32
+	for _, bit := range input {
+		codeword <<= 1
+		if bit != 0 {
+			codeword |= 1
+		}
+	}
+	return codeword, nil
+}
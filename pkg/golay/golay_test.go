@@ -0,0 +1,114 @@
+package golay_test
+
+import (
+	"math/bits"
+	"math/rand"
+	"testing"
+
+	"github.com/8ff/udarp/pkg/golay"
+)
+
+func TestMinimumDistance(t *testing.T) {
+	// Code is linear so the minimum distance is the minimum weight of a nonzero codeword
+	for data := uint16(1); data < 1<<golay.DataBits; data++ {
+		c, err := golay.Encode(data)
+		if err != nil {
+			t.Fatalf("Encode failed with error: %v", err)
+		}
+		if w := bits.OnesCount32(c); w < 8 {
+			t.Fatalf("Codeword %06x for %03x has weight %d", c, data, w)
+		}
+		if c>>12 != uint32(data) {
+			t.Fatalf("Codeword %06x is not systematic for %03x", c, data)
+		}
+	}
+}
+
+func TestCorrectsThreeErrors(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for run := 0; run < 2000; run++ {
+		data := uint16(r.Intn(1 << golay.DataBits))
+		c, _ := golay.Encode(data)
+
+		errors := run % 5
+		corrupted := c
+		for _, pos := range r.Perm(golay.CodewordBits)[:errors] {
+			corrupted ^= 1 << uint(pos)
+		}
+
+		decoded, corrected, err := golay.Decode(corrupted)
+		if errors == 4 {
+			if err == nil {
+				t.Fatalf("4 errors should be detected")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Decode failed with %d errors: %v", errors, err)
+		}
+		if decoded != data || corrected != errors {
+			t.Fatalf("Decoded %03x with %d corrections, expected %03x with %d", decoded, corrected, data, errors)
+		}
+	}
+}
+
+func TestSoftDecode(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for run := 0; run < 200; run++ {
+		data := uint16(r.Intn(1 << golay.DataBits))
+		c, _ := golay.Encode(data)
+
+		soft := make([]float64, golay.CodewordBits)
+		for i, bit := range golay.ToBits(c) {
+			soft[i] = float64(bit) + r.NormFloat64()*0.2
+		}
+		// Push 4 bits over the threshold, more than hard decoding can handle
+		for _, pos := range r.Perm(golay.CodewordBits)[:4] {
+			soft[pos] = 0.45 + 0.1*float64(1-golay.ToBits(c)[pos])
+		}
+
+		decoded, _, err := golay.SoftDecode(soft)
+		if err != nil {
+			t.Fatalf("SoftDecode failed with error: %v", err)
+		}
+		if decoded != data {
+			t.Fatalf("Soft decoded %03x, expected %03x", decoded, data)
+		}
+	}
+}
+
+func TestHeaderRoundTrip(t *testing.T) {
+	h := golay.Header{Mode: 3, Type: 9, Flags: 1, Length: 1500}
+	encoded, err := h.Encode()
+	if err != nil {
+		t.Fatalf("Encode failed with error: %v", err)
+	}
+	if len(encoded) != golay.HeaderBits {
+		t.Fatalf("Expected %d header bits, got %d", golay.HeaderBits, len(encoded))
+	}
+
+	// 3 errors in each word
+	for _, pos := range []int{0, 7, 20, 25, 33, 47} {
+		encoded[pos] ^= 1
+	}
+	decoded, err := golay.DecodeHeader(encoded)
+	if err != nil {
+		t.Fatalf("DecodeHeader failed with error: %v", err)
+	}
+	if decoded != h {
+		t.Fatalf("Decoded header %+v doesn't match %+v", decoded, h)
+	}
+
+	soft := make([]float64, len(encoded))
+	for i, bit := range encoded {
+		soft[i] = float64(bit)
+	}
+	decoded, err = golay.SoftDecodeHeader(soft)
+	if err != nil || decoded != h {
+		t.Fatalf("Soft decoded header %+v doesn't match %+v (err: %v)", decoded, h, err)
+	}
+
+	if _, err := (golay.Header{Length: 1 << 12}).Encode(); err == nil {
+		t.Fatalf("Expected error for length that doesn't fit in 12 bits")
+	}
+}
@@ -0,0 +1,99 @@
+package golay
+
+import (
+	"fmt"
+)
+
+/*
+Frame header sent ahead of the payload, protected by two Golay (24,12) codewords.
+It is decoded before the payload FEC parameters are known so it only uses fixed size fields.
+
+Word 0: Mode (4) | Type (4) | Flags (4)
+Word 1: Length (12)
+*/
+
+const HeaderBits = 2 * CodewordBits
+
+type Header struct {
+	Mode   uint8  // Payload FEC/modulation mode
+	Type   uint8  // Message type
+	Flags  uint8  // Reserved for future use
+	Length uint16 // Payload length in bytes
+}
+
+// Validate checks that every field fits in its slot
+func (h Header) Validate() error {
+	if h.Mode >= 1<<4 {
+		return fmt.Errorf("mode must fit in 4 bits")
+	}
+	if h.Type >= 1<<4 {
+		return fmt.Errorf("type must fit in 4 bits")
+	}
+	if h.Flags >= 1<<4 {
+		return fmt.Errorf("flags must fit in 4 bits")
+	}
+	if h.Length >= 1<<12 {
+		return fmt.Errorf("length must fit in 12 bits")
+	}
+	return nil
+}
+
+// Encode returns the header as HeaderBits 1/0s ready for the modulator
+func (h Header) Encode() ([]int, error) {
+	if err := h.Validate(); err != nil {
+		return nil, err
+	}
+
+	word0, _ := Encode(uint16(h.Mode)<<8 | uint16(h.Type)<<4 | uint16(h.Flags))
+	word1, _ := Encode(h.Length)
+
+	return append(ToBits(word0), ToBits(word1)...), nil
+}
+
+func headerFromWords(word0, word1 uint16) Header {
+	return Header{
+		Mode:   uint8(word0 >> 8),
+		Type:   uint8(word0>>4) & 0x0F,
+		Flags:  uint8(word0) & 0x0F,
+		Length: word1,
+	}
+}
+
+// DecodeHeader hard decodes HeaderBits 1/0s, correcting up to 3 errors per codeword
+func DecodeHeader(input []int) (Header, error) {
+	if len(input) != HeaderBits {
+		return Header{}, fmt.Errorf("header needs %d bits, got %d", HeaderBits, len(input))
+	}
+
+	words := [2]uint16{}
+	for i := range words {
+		codeword, err := FromBits(input[i*CodewordBits : (i+1)*CodewordBits])
+		if err != nil {
+			return Header{}, err
+		}
+		words[i], _, err = Decode(codeword)
+		if err != nil {
+			return Header{}, fmt.Errorf("header word %d: %s", i, err)
+		}
+	}
+
+	return headerFromWords(words[0], words[1]), nil
+}
+
+// SoftDecodeHeader decodes HeaderBits soft values in 0..1, it always returns the closest header
+func SoftDecodeHeader(soft []float64) (Header, error) {
+	if len(soft) != HeaderBits {
+		return Header{}, fmt.Errorf("header needs %d values, got %d", HeaderBits, len(soft))
+	}
+
+	words := [2]uint16{}
+	for i := range words {
+		var err error
+		words[i], _, err = SoftDecode(soft[i*CodewordBits : (i+1)*CodewordBits])
+		if err != nil {
+			return Header{}, err
+		}
+	}
+
+	return headerFromWords(words[0], words[1]), nil
+}
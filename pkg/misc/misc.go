@@ -3,9 +3,14 @@ package misc
 import (
 	"crypto/md5"
 	"fmt"
+	"io"
+	"os"
 	"time"
 )
 
+// LogOutput is where Log writes, point it at os.Stderr when stdout carries audio
+var LogOutput io.Writer = os.Stdout
+
 // Function that calculates all possible combinations of bits given a number of bits
 func GenerateBitCombinations(bits int) [][]int {
 	var combinations [][]int
@@ -63,14 +68,14 @@ func Md5HashString(input string) string {
 func Log(level, msg string) {
 	switch level {
 	case "info":
-		fmt.Printf("\x1b[32m%s [INFO] %s\x1b[0m\n", time.Now().Format("15:04:05"), msg)
+		fmt.Fprintf(LogOutput, "\x1b[32m%s [INFO] %s\x1b[0m\n", time.Now().Format("15:04:05"), msg)
 	case "error":
-		fmt.Printf("\x1b[31m%s [ERROR] %s\x1b[0m\n", time.Now().Format("15:04:05"), msg)
+		fmt.Fprintf(LogOutput, "\x1b[31m%s [ERROR] %s\x1b[0m\n", time.Now().Format("15:04:05"), msg)
 	case "warning":
-		fmt.Printf("\x1b[33m%s [WARNING] %s\x1b[0m\n", time.Now().Format("15:04:05"), msg)
+		fmt.Fprintf(LogOutput, "\x1b[33m%s [WARNING] %s\x1b[0m\n", time.Now().Format("15:04:05"), msg)
 	case "debug":
-		fmt.Printf("\x1b[36m%s [DEBUG] %s\x1b[0m\n", time.Now().Format("15:04:05"), msg)
+		fmt.Fprintf(LogOutput, "\x1b[36m%s [DEBUG] %s\x1b[0m\n", time.Now().Format("15:04:05"), msg)
 	default:
-		fmt.Printf("%s [UNKNOWN] %s\n", time.Now().Format("15:04:05"), msg)
+		fmt.Fprintf(LogOutput, "%s [UNKNOWN] %s\n", time.Now().Format("15:04:05"), msg)
 	}
 }
@@ -0,0 +1,250 @@
+package pack
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+Fixed size bit fields for beacon frames, same idea as WSPR.
+
+Callsign (28 bits): standard calls are normalised to 6 characters with the digit in the third
+position, " K1ABC" for K1ABC, and padded with spaces on the right. The characters are then packed
+with a mixed radix: [ 0-9A-Z] [0-9A-Z] [0-9] [ A-Z] [ A-Z] [ A-Z] = 37*36*10*27*27*27 values.
+
+Locator (15 or 25 bits): 4 character squares pack to field*100 + square, 6 character subsquares
+add subsquare*576 on top of that.
+
+Power (6 bits): 0 to 63 dBm. Report (6 bits): -30 to +33 dB.
+
+Beacon (49 bits): callsign | 4 character locator | power
+*/
+
+const (
+	CallsignBits = 28
+	Grid4Bits    = 15
+	Grid6Bits    = 25
+	PowerBits    = 6
+	ReportBits   = 6
+	BeaconBits   = CallsignBits + Grid4Bits + PowerBits
+
+	MinReport = -30
+	MaxReport = 33
+	MaxPower  = 63
+)
+
+const (
+	alphaNum      = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	spaceAlphaNum = " " + alphaNum
+	spaceAlpha    = " ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	digits        = "0123456789"
+
+	callsignValues = 37 * 36 * 10 * 27 * 27 * 27
+	grid4Values    = 18 * 18 * 10 * 10
+	grid6Values    = grid4Values * 24 * 24
+)
+
+// Alphabet for each of the 6 normalised callsign positions
+var callsignAlphabets = [6]string{spaceAlphaNum, alphaNum, digits, spaceAlpha, spaceAlpha, spaceAlpha}
+
+// NormalizeCallsign upper cases call and aligns it so the digit is in the third position
+func NormalizeCallsign(call string) (string, error) {
+	call = strings.ToUpper(strings.TrimSpace(call))
+	if len(call) < 3 || len(call) > 6 {
+		return "", fmt.Errorf("callsign %q is not a standard callsign", call)
+	}
+
+	if !strings.ContainsRune(digits, rune(call[2])) {
+		call = " " + call
+	}
+	if len(call) > 6 {
+		return "", fmt.Errorf("callsign %q is not a standard callsign", call)
+	}
+	call += strings.Repeat(" ", 6-len(call))
+
+	for i := 0; i < 6; i++ {
+		if !strings.ContainsRune(callsignAlphabets[i], rune(call[i])) {
+			return "", fmt.Errorf("callsign %q is not a standard callsign", strings.TrimSpace(call))
+		}
+	}
+
+	// Suffix must be letters followed by spaces, " K1A B" is not a callsign
+	if strings.Contains(strings.TrimRight(call[3:], " "), " ") {
+		return "", fmt.Errorf("callsign %q is not a standard callsign", strings.TrimSpace(call))
+	}
+
+	return call, nil
+}
+
+// PackCallsign packs a standard callsign into CallsignBits
+func PackCallsign(call string) (uint32, error) {
+	normalized, err := NormalizeCallsign(call)
+	if err != nil {
+		return 0, err
+	}
+
+	var packed uint32
+	for i := 0; i < 6; i++ {
+		packed = packed*uint32(len(callsignAlphabets[i])) + uint32(strings.IndexByte(callsignAlphabets[i], normalized[i]))
+	}
+	return packed, nil
+}
+
+// UnpackCallsign is the inverse of PackCallsign
+func UnpackCallsign(packed uint32) (string, error) {
+	if packed >= callsignValues {
+		return "", fmt.Errorf("packed callsign %d is out of range", packed)
+	}
+
+	call := make([]byte, 6)
+	for i := 5; i >= 0; i-- {
+		radix := uint32(len(callsignAlphabets[i]))
+		call[i] = callsignAlphabets[i][packed%radix]
+		packed /= radix
+	}
+
+	// Make sure the value came from a valid callsign
+	normalized, err := NormalizeCallsign(string(call))
+	if err != nil || normalized != string(call) {
+		return "", fmt.Errorf("packed value does not hold a standard callsign")
+	}
+	return strings.TrimSpace(string(call)), nil
+}
+
+func packSquare(grid string) (uint32, error) {
+	f1, f2 := grid[0]-'A', grid[1]-'A'
+	d1, d2 := grid[2]-'0', grid[3]-'0'
+	if f1 >= 18 || f2 >= 18 || d1 >= 10 || d2 >= 10 {
+		return 0, fmt.Errorf("locator %q is not valid", grid)
+	}
+	return ((uint32(f1)*18+uint32(f2))*10+uint32(d1))*10 + uint32(d2), nil
+}
+
+func unpackSquare(packed uint32) string {
+	d2 := packed % 10
+	packed /= 10
+	d1 := packed % 10
+	packed /= 10
+	f2 := packed % 18
+	f1 := packed / 18
+	return string([]byte{byte('A' + f1), byte('A' + f2), byte('0' + d1), byte('0' + d2)})
+}
+
+// PackGrid4 packs a 4 character Maidenhead locator into Grid4Bits
+func PackGrid4(grid string) (uint32, error) {
+	grid = strings.ToUpper(grid)
+	if len(grid) != 4 {
+		return 0, fmt.Errorf("locator %q must be 4 characters", grid)
+	}
+	return packSquare(grid)
+}
+
+// UnpackGrid4 is the inverse of PackGrid4
+func UnpackGrid4(packed uint32) (string, error) {
+	if packed >= grid4Values {
+		return "", fmt.Errorf("packed locator %d is out of range", packed)
+	}
+	return unpackSquare(packed), nil
+}
+
+// PackGrid6 packs a 6 character Maidenhead locator into Grid6Bits
+func PackGrid6(grid string) (uint32, error) {
+	grid = strings.ToUpper(grid)
+	if len(grid) != 6 {
+		return 0, fmt.Errorf("locator %q must be 6 characters", grid)
+	}
+	square, err := packSquare(grid[:4])
+	if err != nil {
+		return 0, err
+	}
+	s1, s2 := grid[4]-'A', grid[5]-'A'
+	if s1 >= 24 || s2 >= 24 {
+		return 0, fmt.Errorf("locator %q is not valid", grid)
+	}
+	return (uint32(s1)*24+uint32(s2))*grid4Values + square, nil
+}
+
+// UnpackGrid6 is the inverse of PackGrid6
+func UnpackGrid6(packed uint32) (string, error) {
+	if packed >= grid6Values {
+		return "", fmt.Errorf("packed locator %d is out of range", packed)
+	}
+	sub := packed / grid4Values
+	return unpackSquare(packed%grid4Values) + string([]byte{byte('A' + sub/24), byte('A' + sub%24)}), nil
+}
+
+// PackPower packs transmit power in dBm into PowerBits
+func PackPower(dbm int) (uint8, error) {
+	if dbm < 0 || dbm > MaxPower {
+		return 0, fmt.Errorf("power must be between 0 and %d dBm", MaxPower)
+	}
+	return uint8(dbm), nil
+}
+
+// UnpackPower is the inverse of PackPower
+func UnpackPower(packed uint8) (int, error) {
+	if packed > MaxPower {
+		return 0, fmt.Errorf("packed power %d is out of range", packed)
+	}
+	return int(packed), nil
+}
+
+// PackReport packs a signal report in dB into ReportBits
+func PackReport(db int) (uint8, error) {
+	if db < MinReport || db > MaxReport {
+		return 0, fmt.Errorf("report must be between %d and %d dB", MinReport, MaxReport)
+	}
+	return uint8(db - MinReport), nil
+}
+
+// UnpackReport is the inverse of PackReport
+func UnpackReport(packed uint8) (int, error) {
+	if int(packed) > MaxReport-MinReport {
+		return 0, fmt.Errorf("packed report %d is out of range", packed)
+	}
+	return int(packed) + MinReport, nil
+}
+
+type Beacon struct {
+	Callsign string
+	Grid     string // 4 character locator
+	Power    int    // dBm
+}
+
+// Pack packs the beacon into the lowest BeaconBits bits
+func (b Beacon) Pack() (uint64, error) {
+	call, err := PackCallsign(b.Callsign)
+	if err != nil {
+		return 0, err
+	}
+	grid, err := PackGrid4(b.Grid)
+	if err != nil {
+		return 0, err
+	}
+	power, err := PackPower(b.Power)
+	if err != nil {
+		return 0, err
+	}
+	return (uint64(call)<<Grid4Bits|uint64(grid))<<PowerBits | uint64(power), nil
+}
+
+// UnpackBeacon is the inverse of Beacon.Pack
+func UnpackBeacon(packed uint64) (Beacon, error) {
+	if packed >= 1<<BeaconBits {
+		return Beacon{}, fmt.Errorf("packed beacon does not fit in %d bits", BeaconBits)
+	}
+	power, err := UnpackPower(uint8(packed & (1<<PowerBits - 1)))
+	if err != nil {
+		return Beacon{}, err
+	}
+	packed >>= PowerBits
+	grid, err := UnpackGrid4(uint32(packed & (1<<Grid4Bits - 1)))
+	if err != nil {
+		return Beacon{}, err
+	}
+	call, err := UnpackCallsign(uint32(packed >> Grid4Bits))
+	if err != nil {
+		return Beacon{}, err
+	}
+	return Beacon{Callsign: call, Grid: grid, Power: power}, nil
+}
@@ -0,0 +1,153 @@
+package pack_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/8ff/udarp/pkg/pack"
+)
+
+func TestCallsignRoundTrip(t *testing.T) {
+	for _, call := range []string{"K1ABC", "W1AW", "EA8BFK", "2E0ABC", "G4A", "9A1A", "k1abc"} {
+		packed, err := pack.PackCallsign(call)
+		if err != nil {
+			t.Fatalf("PackCallsign(%s) failed with error: %v", call, err)
+		}
+		if packed >= 1<<pack.CallsignBits {
+			t.Fatalf("Packed %s doesn't fit in %d bits", call, pack.CallsignBits)
+		}
+		unpacked, err := pack.UnpackCallsign(packed)
+		if err != nil {
+			t.Fatalf("UnpackCallsign(%d) failed with error: %v", packed, err)
+		}
+		if unpacked != strings.ToUpper(call) {
+			t.Fatalf("Unpacked %s doesn't match %s", unpacked, call)
+		}
+	}
+
+	for _, call := range []string{"", "K1", "EA8/W1ABC/P", "KABC", "K1A B", "K1ABCDE", "K-1AB"} {
+		if _, err := pack.PackCallsign(call); err == nil {
+			t.Fatalf("Expected error packing %q", call)
+		}
+	}
+}
+
+func TestCallsignSpace(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping exhaustive callsign test in short mode")
+	}
+	// Every value that unpacks must pack back to itself
+	valid := 0
+	for packed := uint32(0); packed < 1<<pack.CallsignBits; packed++ {
+		call, err := pack.UnpackCallsign(packed)
+		if err != nil {
+			continue
+		}
+		valid++
+		repacked, err := pack.PackCallsign(call)
+		if err != nil {
+			t.Fatalf("PackCallsign(%s) failed with error: %v", call, err)
+		}
+		if repacked != packed {
+			t.Fatalf("%s packed to %d, expected %d", call, repacked, packed)
+		}
+	}
+	if valid == 0 {
+		t.Fatalf("No valid callsigns found")
+	}
+}
+
+func TestGrid4Exhaustive(t *testing.T) {
+	count := 0
+	for f1 := 'A'; f1 <= 'R'; f1++ {
+		for f2 := 'A'; f2 <= 'R'; f2++ {
+			for d1 := '0'; d1 <= '9'; d1++ {
+				for d2 := '0'; d2 <= '9'; d2++ {
+					grid := string([]rune{f1, f2, d1, d2})
+					packed, err := pack.PackGrid4(grid)
+					if err != nil {
+						t.Fatalf("PackGrid4(%s) failed with error: %v", grid, err)
+					}
+					if packed >= 1<<pack.Grid4Bits {
+						t.Fatalf("Packed %s doesn't fit in %d bits", grid, pack.Grid4Bits)
+					}
+					unpacked, err := pack.UnpackGrid4(packed)
+					if err != nil || unpacked != grid {
+						t.Fatalf("Unpacked %s doesn't match %s (err: %v)", unpacked, grid, err)
+					}
+					count++
+				}
+			}
+		}
+	}
+	if count != 18*18*10*10 {
+		t.Fatalf("Expected %d locators, got %d", 18*18*10*10, count)
+	}
+}
+
+func TestGrid6Exhaustive(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping exhaustive 6 character locator test in short mode")
+	}
+	for packed := uint32(0); packed < 18*18*10*10*24*24; packed++ {
+		grid, err := pack.UnpackGrid6(packed)
+		if err != nil {
+			t.Fatalf("UnpackGrid6(%d) failed with error: %v", packed, err)
+		}
+		repacked, err := pack.PackGrid6(grid)
+		if err != nil || repacked != packed {
+			t.Fatalf("%s packed to %d, expected %d (err: %v)", grid, repacked, packed, err)
+		}
+	}
+	if _, err := pack.UnpackGrid6(18 * 18 * 10 * 10 * 24 * 24); err == nil {
+		t.Fatalf("Expected error for out of range locator")
+	}
+	if _, err := pack.PackGrid6("KP12YA"); err == nil {
+		t.Fatalf("Expected error for invalid subsquare")
+	}
+}
+
+func TestPowerAndReportExhaustive(t *testing.T) {
+	for dbm := 0; dbm <= pack.MaxPower; dbm++ {
+		packed, err := pack.PackPower(dbm)
+		if err != nil || packed >= 1<<pack.PowerBits {
+			t.Fatalf("PackPower(%d) gave %d (err: %v)", dbm, packed, err)
+		}
+		if unpacked, _ := pack.UnpackPower(packed); unpacked != dbm {
+			t.Fatalf("Unpacked power %d doesn't match %d", unpacked, dbm)
+		}
+	}
+	for db := pack.MinReport; db <= pack.MaxReport; db++ {
+		packed, err := pack.PackReport(db)
+		if err != nil || packed >= 1<<pack.ReportBits {
+			t.Fatalf("PackReport(%d) gave %d (err: %v)", db, packed, err)
+		}
+		if unpacked, _ := pack.UnpackReport(packed); unpacked != db {
+			t.Fatalf("Unpacked report %d doesn't match %d", unpacked, db)
+		}
+	}
+	if _, err := pack.PackPower(64); err == nil {
+		t.Fatalf("Expected error for power over %d dBm", pack.MaxPower)
+	}
+	if _, err := pack.PackReport(-31); err == nil {
+		t.Fatalf("Expected error for report under %d dB", pack.MinReport)
+	}
+}
+
+func TestBeaconRoundTrip(t *testing.T) {
+	b := pack.Beacon{Callsign: "K1ABC", Grid: "FN42", Power: 37}
+	packed, err := b.Pack()
+	if err != nil {
+		t.Fatalf("Pack failed with error: %v", err)
+	}
+	if packed >= 1<<pack.BeaconBits || pack.BeaconBits > 50 {
+		t.Fatalf("Beacon doesn't fit in %d bits", pack.BeaconBits)
+	}
+	unpacked, err := pack.UnpackBeacon(packed)
+	if err != nil {
+		t.Fatalf("UnpackBeacon failed with error: %v", err)
+	}
+	if unpacked != b {
+		t.Fatalf("Unpacked beacon %+v doesn't match %+v", unpacked, b)
+	}
+}
@@ -0,0 +1,235 @@
+package relay
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/8ff/udarp/pkg/frame"
+	"github.com/8ff/udarp/pkg/misc"
+	"github.com/8ff/udarp/pkg/pack"
+)
+
+/*
+Digipeating of source routed frames.
+
+Every station runs a Relay to filter what it hears: frames addressed to it are delivered once, no
+matter how many paths they arrived over. With Forward set the station also acts as a digipeater and
+re-transmits routed frames whose next hop is its own callsign, until the hop limit runs out.
+
+Stations heard directly make up the reachability table, relays broadcast it in TypeRelay frames so
+other stations learn which relay can reach whom:
+	per entry 0..3 callsign, 28 bit packed
+	          4..5 minutes since last heard
+*/
+
+const tableEntrySize = 6
+
+type Params struct {
+	Callsign     string
+	Forward      bool          // Act as a digipeater
+	DedupWindow  time.Duration // How long message IDs are remembered, default 30 minutes
+	HeardTimeout time.Duration // How long a station stays in the reachability table, default 1 hour
+}
+
+type Heard struct {
+	Callsign string
+	Last     time.Time
+	Count    int
+	Via      string // Relay the station was learned from, empty when heard directly
+}
+
+type Relay struct {
+	params Params
+
+	m       sync.Mutex
+	seen    map[string]time.Time // source/id
+	heard   map[string]Heard     // Heard directly
+	learned map[string]Heard     // From announcements of other relays
+}
+
+func New(params Params) (*Relay, error) {
+	params.Callsign = strings.ToUpper(params.Callsign)
+	if _, err := pack.PackCallsign(params.Callsign); err != nil {
+		return nil, fmt.Errorf("invalid callsign: %w", err)
+	}
+	if params.DedupWindow == 0 {
+		params.DedupWindow = 30 * time.Minute
+	}
+	if params.HeardTimeout == 0 {
+		params.HeardTimeout = time.Hour
+	}
+	return &Relay{
+		params:  params,
+		seen:    make(map[string]time.Time),
+		heard:   make(map[string]Heard),
+		learned: make(map[string]Heard),
+	}, nil
+}
+
+// Handle looks at a received frame. It returns the frame to re-transmit when forward is set, and
+// whether the frame should be delivered to this station.
+func (r *Relay) Handle(f frame.Frame) (fwd frame.Frame, forward bool, deliver bool) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	now := time.Now()
+	r.expire(now)
+
+	// Whoever transmitted this copy is in range
+	transmitter := f.Source
+	if f.Route != nil && f.Route.Next > 0 {
+		transmitter = f.Route.Path[f.Route.Next-1]
+	}
+	h := r.heard[transmitter]
+	r.heard[transmitter] = Heard{Callsign: transmitter, Last: now, Count: h.Count + 1}
+
+	if f.Type == frame.TypeRelay {
+		if err := r.learn(transmitter, f.Payload, now); err != nil {
+			misc.Log("warning", fmt.Sprintf("Bad reachability table from %s: %v", transmitter, err))
+		}
+		return frame.Frame{}, false, false
+	}
+
+	forMe := strings.EqualFold(f.Destination, r.params.Callsign) || f.Destination == frame.Broadcast
+	if f.Route == nil {
+		return frame.Frame{}, false, forMe
+	}
+
+	key := fmt.Sprintf("%s/%08x", f.Source, f.Route.ID)
+	if _, ok := r.seen[key]; ok {
+		return frame.Frame{}, false, false
+	}
+
+	next, ok := f.Route.NextHop()
+	forward = ok && r.params.Forward && strings.EqualFold(next, r.params.Callsign)
+	if forward && f.Route.HopLimit == 0 {
+		misc.Log("warning", fmt.Sprintf("Dropping frame %s, hop limit reached", key))
+		forward = false
+	}
+	if forward {
+		fwd = f
+		fwd.Route = f.Route.Forwarded()
+		fwd.Payload = append([]byte{}, f.Payload...)
+	}
+
+	// Only remember frames we acted on, we may be a later hop of a frame we overheard early
+	if forward || forMe {
+		r.seen[key] = now
+	}
+	return fwd, forward, forMe
+}
+
+func (r *Relay) expire(now time.Time) {
+	for key, t := range r.seen {
+		if now.Sub(t) > r.params.DedupWindow {
+			delete(r.seen, key)
+		}
+	}
+	for call, h := range r.heard {
+		if now.Sub(h.Last) > r.params.HeardTimeout {
+			delete(r.heard, call)
+		}
+	}
+	for call, h := range r.learned {
+		if now.Sub(h.Last) > r.params.HeardTimeout {
+			delete(r.learned, call)
+		}
+	}
+}
+
+// learn stores the stations a relay says it can reach, called with m held
+func (r *Relay) learn(via string, payload []byte, now time.Time) error {
+	entries, err := ParseTable(payload, now)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if strings.EqualFold(e.Callsign, r.params.Callsign) {
+			continue
+		}
+		e.Via = via
+		if old, ok := r.learned[e.Callsign]; !ok || e.Last.After(old.Last) {
+			r.learned[e.Callsign] = e
+		}
+	}
+	return nil
+}
+
+// Table returns the stations heard directly, most recent first
+func (r *Relay) Table() []Heard {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.expire(time.Now())
+
+	table := make([]Heard, 0, len(r.heard))
+	for _, h := range r.heard {
+		table = append(table, h)
+	}
+	sort.Slice(table, func(i, j int) bool { return table[i].Last.After(table[j].Last) })
+	return table
+}
+
+// PathTo returns a path to use for frames to call, empty when it is heard directly
+func (r *Relay) PathTo(call string) ([]string, bool) {
+	call = strings.ToUpper(call)
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.expire(time.Now())
+
+	if _, ok := r.heard[call]; ok {
+		return nil, true
+	}
+	if h, ok := r.learned[call]; ok {
+		if _, ok := r.heard[h.Via]; ok {
+			return []string{h.Via}, true
+		}
+	}
+	return nil, false
+}
+
+// Announcement returns a broadcast frame carrying the reachability table
+func (r *Relay) Announcement() frame.Frame {
+	table := r.Table()
+	max := frame.MaxPayload / tableEntrySize
+	if len(table) > max {
+		table = table[:max]
+	}
+
+	now := time.Now()
+	payload := make([]byte, 0, len(table)*tableEntrySize)
+	for _, h := range table {
+		packed, err := pack.PackCallsign(h.Callsign)
+		if err != nil {
+			// Nonstandard calls can't be relayed to anyway
+			continue
+		}
+		minutes := now.Sub(h.Last) / time.Minute
+		if minutes > 0xFFFF {
+			minutes = 0xFFFF
+		}
+		payload = binary.BigEndian.AppendUint32(payload, packed)
+		payload = binary.BigEndian.AppendUint16(payload, uint16(minutes))
+	}
+	return frame.New(frame.TypeRelay, r.params.Callsign, frame.Broadcast, 0, payload)
+}
+
+// ParseTable parses the payload of a TypeRelay frame received at now
+func ParseTable(payload []byte, now time.Time) ([]Heard, error) {
+	if len(payload)%tableEntrySize != 0 {
+		return nil, fmt.Errorf("table length %d is not a multiple of %d", len(payload), tableEntrySize)
+	}
+	var entries []Heard
+	for i := 0; i < len(payload); i += tableEntrySize {
+		call, err := pack.UnpackCallsign(binary.BigEndian.Uint32(payload[i:]))
+		if err != nil {
+			return nil, err
+		}
+		age := time.Duration(binary.BigEndian.Uint16(payload[i+4:])) * time.Minute
+		entries = append(entries, Heard{Callsign: call, Last: now.Add(-age)})
+	}
+	return entries, nil
+}
@@ -0,0 +1,154 @@
+package relay_test
+
+import (
+	"testing"
+
+	"github.com/8ff/udarp/pkg/frame"
+	"github.com/8ff/udarp/pkg/relay"
+)
+
+// network simulates stations that only hear their neighbours
+type network struct {
+	t         *testing.T
+	stations  map[string]*relay.Relay
+	links     map[string][]string
+	delivered map[string][]frame.Frame
+}
+
+func newNetwork(t *testing.T, links map[string][]string, relays ...string) *network {
+	n := &network{t: t, stations: make(map[string]*relay.Relay), links: make(map[string][]string), delivered: make(map[string][]frame.Frame)}
+	forward := make(map[string]bool)
+	for _, call := range relays {
+		forward[call] = true
+	}
+	for a, neighbours := range links {
+		for _, b := range neighbours {
+			n.links[a] = append(n.links[a], b)
+			n.links[b] = append(n.links[b], a)
+		}
+	}
+	for call := range n.links {
+		r, err := relay.New(relay.Params{Callsign: call, Forward: forward[call]})
+		if err != nil {
+			t.Fatalf("New failed with error: %v", err)
+		}
+		n.stations[call] = r
+	}
+	return n
+}
+
+func (n *network) transmit(from string, f frame.Frame) {
+	data, err := f.MarshalBinary()
+	if err != nil {
+		n.t.Fatalf("MarshalBinary failed with error: %v", err)
+	}
+	for _, to := range n.links[from] {
+		var received frame.Frame
+		if err := received.UnmarshalBinary(data); err != nil {
+			n.t.Fatalf("UnmarshalBinary failed with error: %v", err)
+		}
+		fwd, forward, deliver := n.stations[to].Handle(received)
+		if deliver {
+			n.delivered[to] = append(n.delivered[to], received)
+		}
+		if forward {
+			n.transmit(to, fwd)
+		}
+	}
+}
+
+func TestMultiHop(t *testing.T) {
+	// K1ABC and KA1XYZ are on opposite sides of a mountain, W1AW and W2AW relay
+	n := newNetwork(t, map[string][]string{
+		"K1ABC": {"W1AW"},
+		"W1AW":  {"W2AW"},
+		"W2AW":  {"KA1XYZ"},
+	}, "W1AW", "W2AW")
+
+	f := frame.New(frame.TypeText, "K1ABC", "KA1XYZ", 1, []byte("hello"))
+	f.Route = frame.NewRoute(1, "W1AW", "W2AW")
+	n.transmit("K1ABC", f)
+
+	got := n.delivered["KA1XYZ"]
+	if len(got) != 1 {
+		t.Fatalf("Expected one delivery, got %d", len(got))
+	}
+	if path := got[0].Route.Traversed(); len(path) != 2 || path[0] != "W1AW" || path[1] != "W2AW" {
+		t.Fatalf("Relay record should be W1AW W2AW, got %v", path)
+	}
+	if got[0].Route.HopLimit != frame.DefaultHopLimit-2 || string(got[0].Payload) != "hello" {
+		t.Fatalf("Unexpected delivered frame %+v", got[0])
+	}
+	if len(n.delivered["W1AW"]) != 0 || len(n.delivered["W2AW"]) != 0 {
+		t.Fatalf("Relays should not deliver frames for someone else")
+	}
+
+	// Hop limit runs out at the second relay
+	f.Route = frame.NewRoute(2, "W1AW", "W2AW")
+	f.Route.HopLimit = 1
+	n.transmit("K1ABC", f)
+	if len(n.delivered["KA1XYZ"]) != 1 {
+		t.Fatalf("Frame should have been dropped when the hop limit ran out")
+	}
+}
+
+func TestDuplicateSuppression(t *testing.T) {
+	// KA1XYZ hears K1ABC directly and through both relays, W2AW also hears K1ABC directly
+	n := newNetwork(t, map[string][]string{
+		"K1ABC": {"W1AW", "W2AW", "KA1XYZ"},
+		"W1AW":  {"W2AW", "KA1XYZ"},
+		"W2AW":  {"KA1XYZ"},
+	}, "W1AW", "W2AW")
+
+	f := frame.New(frame.TypeText, "K1ABC", "KA1XYZ", 1, []byte("once"))
+	f.Route = frame.NewRoute(7, "W1AW", "W2AW")
+	n.transmit("K1ABC", f)
+	if got := len(n.delivered["KA1XYZ"]); got != 1 {
+		t.Fatalf("Expected the message to be delivered once, got %d", got)
+	}
+
+	// Same message id again is a duplicate
+	n.transmit("K1ABC", f)
+	if got := len(n.delivered["KA1XYZ"]); got != 1 {
+		t.Fatalf("Repeated message should be suppressed, got %d deliveries", got)
+	}
+}
+
+func TestForwardingIsOptional(t *testing.T) {
+	n := newNetwork(t, map[string][]string{
+		"K1ABC": {"W1AW"},
+		"W1AW":  {"KA1XYZ"},
+	})
+
+	f := frame.New(frame.TypeText, "K1ABC", "KA1XYZ", 1, nil)
+	f.Route = frame.NewRoute(1, "W1AW")
+	n.transmit("K1ABC", f)
+	if len(n.delivered["KA1XYZ"]) != 0 {
+		t.Fatalf("Stations without Forward should not relay")
+	}
+}
+
+func TestReachability(t *testing.T) {
+	n := newNetwork(t, map[string][]string{
+		"K1ABC": {"W1AW"},
+		"W1AW":  {"KA1XYZ"},
+	}, "W1AW")
+
+	n.transmit("KA1XYZ", frame.New(frame.TypeText, "KA1XYZ", frame.Broadcast, 1, []byte("cq")))
+	table := n.stations["W1AW"].Table()
+	if len(table) != 1 || table[0].Callsign != "KA1XYZ" || table[0].Count != 1 {
+		t.Fatalf("Unexpected reachability table %+v", table)
+	}
+
+	if _, ok := n.stations["K1ABC"].PathTo("KA1XYZ"); ok {
+		t.Fatalf("K1ABC should not know a path to KA1XYZ yet")
+	}
+	n.transmit("W1AW", n.stations["W1AW"].Announcement())
+	path, ok := n.stations["K1ABC"].PathTo("ka1xyz")
+	if !ok || len(path) != 1 || path[0] != "W1AW" {
+		t.Fatalf("Expected path through W1AW, got %v %v", path, ok)
+	}
+	if path, ok := n.stations["K1ABC"].PathTo("W1AW"); !ok || len(path) != 0 {
+		t.Fatalf("W1AW is heard directly, got %v %v", path, ok)
+	}
+}
@@ -0,0 +1,340 @@
+package remote
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/8ff/udarp/pkg/auth"
+	"github.com/8ff/udarp/pkg/frame"
+	"github.com/8ff/udarp/pkg/misc"
+	"github.com/8ff/udarp/pkg/pack"
+	"github.com/8ff/udarp/pkg/relay"
+)
+
+/*
+Remote control of unattended stations over frame.TypeControl frames.
+
+Commands must be authenticated (see pkg/auth), frames that fail verification are dropped without an
+answer so a forger learns nothing. Every command has its own allowlist of operator callsigns, a
+verified operator that is not on it gets an error response. Responses are signed with the key of
+the operator. SetFrequency only tunes within the configured bands, nobody is there to notice a rig
+sent somewhere it should not transmit.
+
+Command payload:
+	0      Command
+	SetFrequency  1..8 frequency in Hz
+	others        nothing
+
+Response payload:
+	0      Command | responseBit
+	1      Status, StatusOK or StatusError
+	Error         error text
+	Status        2..5 uptime in seconds, 6..13 frequency in Hz, 14 beacon running
+	SetFrequency  2..9 new frequency in Hz
+	Heard         per station: callsign (4, 28 bit packed), minutes since heard (2)
+	others        nothing
+*/
+
+type Command uint8
+
+const (
+	CommandStatus Command = iota + 1
+	CommandSetFrequency
+	CommandBeaconStart
+	CommandBeaconStop
+	CommandRestartDecoder
+	CommandHeard
+)
+
+func (c Command) String() string {
+	switch c {
+	case CommandStatus:
+		return "status"
+	case CommandSetFrequency:
+		return "set-frequency"
+	case CommandBeaconStart:
+		return "beacon-start"
+	case CommandBeaconStop:
+		return "beacon-stop"
+	case CommandRestartDecoder:
+		return "restart-decoder"
+	case CommandHeard:
+		return "heard"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(c))
+	}
+}
+
+// ParseCommand returns the command with the given name, the inverse of String
+func ParseCommand(name string) (Command, error) {
+	for c := CommandStatus; c <= CommandHeard; c++ {
+		if strings.EqualFold(c.String(), name) {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown command %q", name)
+}
+
+const responseBit = 0x80
+
+const (
+	StatusOK    = 0
+	StatusError = 1
+)
+
+var ErrMalformed = errors.New("malformed control payload")
+
+// Rig is the part of txControl.TxControl remote control uses
+type Rig interface {
+	GetFrequency() (float64, error)
+	SetFrequency(freq float64) error
+}
+
+// Beacon is the part of beacon.Beacon remote control uses
+type Beacon interface {
+	Start()
+	Stop()
+	Running() bool
+}
+
+// Band is a frequency range in Hz, both ends included
+type Band struct {
+	Lo, Hi uint64
+}
+
+type Params struct {
+	Callsign  string
+	Auth      *auth.Authenticator
+	Operators map[Command][]string // Operators allowed to run each command, commands not listed are refused
+	Bands     []Band               // Ranges SetFrequency may tune to, SetFrequency is refused when empty
+
+	Rig            Rig
+	Beacon         Beacon
+	RestartDecoder func() error
+	Heard          func() []relay.Heard
+}
+
+type Controller struct {
+	params    Params
+	operators map[Command]map[string]bool
+	started   time.Time
+}
+
+func New(params Params) (*Controller, error) {
+	params.Callsign = strings.ToUpper(params.Callsign)
+	if _, err := pack.PackCallsign(params.Callsign); err != nil {
+		return nil, fmt.Errorf("invalid callsign: %w", err)
+	}
+	if params.Auth == nil {
+		return nil, fmt.Errorf("authenticator is required")
+	}
+	if !params.Auth.Required(frame.TypeControl) {
+		return nil, fmt.Errorf("authenticator must require control frames to be authenticated")
+	}
+
+	for _, b := range params.Bands {
+		if b.Lo == 0 || b.Lo > b.Hi {
+			return nil, fmt.Errorf("invalid band %d-%d", b.Lo, b.Hi)
+		}
+	}
+
+	c := &Controller{params: params, operators: make(map[Command]map[string]bool), started: time.Now()}
+	for cmd, calls := range params.Operators {
+		c.operators[cmd] = make(map[string]bool)
+		for _, call := range calls {
+			c.operators[cmd][strings.ToUpper(call)] = true
+		}
+	}
+	return c, nil
+}
+
+// Handle runs a command frame and returns the signed response to transmit.
+// Frames that are not commands for this station, or fail authentication, return false.
+func (c *Controller) Handle(f frame.Frame) (frame.Frame, bool) {
+	if f.Type != frame.TypeControl || !strings.EqualFold(f.Destination, c.params.Callsign) {
+		return frame.Frame{}, false
+	}
+
+	verified, err := c.params.Auth.Verify(f)
+	if err != nil {
+		misc.Log("warning", fmt.Sprintf("Dropping control frame from %s: %v", f.Source, err))
+		return frame.Frame{}, false
+	}
+	if len(verified.Payload) == 0 || verified.Payload[0]&responseBit != 0 {
+		return frame.Frame{}, false
+	}
+
+	cmd := Command(verified.Payload[0])
+	operator := strings.ToUpper(verified.Source)
+	var payload []byte
+	if !c.operators[cmd][operator] {
+		misc.Log("warning", fmt.Sprintf("%s is not allowed to run %s", operator, cmd))
+		payload = errorResponse(cmd, "not allowed")
+	} else {
+		misc.Log("info", fmt.Sprintf("Running %s for %s", cmd, operator))
+		payload, err = c.run(cmd, verified.Payload[1:])
+		if err != nil {
+			payload = errorResponse(cmd, err.Error())
+		}
+	}
+
+	resp := frame.New(frame.TypeControl, c.params.Callsign, verified.Source, verified.Sequence, payload)
+	if err := c.params.Auth.Sign(&resp); err != nil {
+		misc.Log("error", fmt.Sprintf("Failed to sign response to %s: %v", operator, err))
+		return frame.Frame{}, false
+	}
+	return resp, true
+}
+
+func (c *Controller) run(cmd Command, args []byte) ([]byte, error) {
+	payload := []byte{byte(cmd) | responseBit, StatusOK}
+
+	switch cmd {
+	case CommandStatus:
+		var freq float64
+		if c.params.Rig != nil {
+			var err error
+			if freq, err = c.params.Rig.GetFrequency(); err != nil {
+				return nil, fmt.Errorf("failed to read frequency: %w", err)
+			}
+		}
+		running := byte(0)
+		if c.params.Beacon != nil && c.params.Beacon.Running() {
+			running = 1
+		}
+		payload = binary.BigEndian.AppendUint32(payload, uint32(time.Since(c.started)/time.Second))
+		payload = binary.BigEndian.AppendUint64(payload, uint64(freq))
+		payload = append(payload, running)
+
+	case CommandSetFrequency:
+		if len(args) != 8 {
+			return nil, fmt.Errorf("%w: frequency", ErrMalformed)
+		}
+		if c.params.Rig == nil {
+			return nil, fmt.Errorf("no rig")
+		}
+		freq := binary.BigEndian.Uint64(args)
+		if !c.inBand(freq) {
+			return nil, fmt.Errorf("%d Hz is outside the allowed bands", freq)
+		}
+		if err := c.params.Rig.SetFrequency(float64(freq)); err != nil {
+			return nil, fmt.Errorf("failed to set frequency: %w", err)
+		}
+		payload = binary.BigEndian.AppendUint64(payload, freq)
+
+	case CommandBeaconStart, CommandBeaconStop:
+		if c.params.Beacon == nil {
+			return nil, fmt.Errorf("no beacon configured")
+		}
+		if cmd == CommandBeaconStart {
+			c.params.Beacon.Start()
+		} else {
+			c.params.Beacon.Stop()
+		}
+
+	case CommandRestartDecoder:
+		if c.params.RestartDecoder == nil {
+			return nil, fmt.Errorf("decoder restart not supported")
+		}
+		if err := c.params.RestartDecoder(); err != nil {
+			return nil, fmt.Errorf("failed to restart decoder: %w", err)
+		}
+
+	case CommandHeard:
+		if c.params.Heard == nil {
+			return nil, fmt.Errorf("no heard list")
+		}
+		now := time.Now()
+		// Leave room for the auth trailer
+		max := (frame.MaxPayload - 64) / 6
+		for i, h := range c.params.Heard() {
+			if i == max {
+				break
+			}
+			packed, err := pack.PackCallsign(h.Callsign)
+			if err != nil {
+				continue
+			}
+			minutes := now.Sub(h.Last) / time.Minute
+			if minutes > 0xFFFF {
+				minutes = 0xFFFF
+			}
+			payload = binary.BigEndian.AppendUint32(payload, packed)
+			payload = binary.BigEndian.AppendUint16(payload, uint16(minutes))
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown command %d", cmd)
+	}
+	return payload, nil
+}
+
+// inBand reports whether freq is inside one of the configured bands
+func (c *Controller) inBand(freq uint64) bool {
+	for _, b := range c.params.Bands {
+		if freq >= b.Lo && freq <= b.Hi {
+			return true
+		}
+	}
+	return false
+}
+
+func errorResponse(cmd Command, msg string) []byte {
+	return append([]byte{byte(cmd) | responseBit, StatusError}, msg...)
+}
+
+// NewCommand returns an unsigned command frame, sign it with auth.Authenticator.Sign before sending
+func NewCommand(operator, station string, sequence uint16, cmd Command, frequency float64) frame.Frame {
+	payload := []byte{byte(cmd)}
+	if cmd == CommandSetFrequency {
+		payload = binary.BigEndian.AppendUint64(payload, uint64(frequency))
+	}
+	return frame.New(frame.TypeControl, operator, station, sequence, payload)
+}
+
+type Response struct {
+	Command   Command
+	Err       string
+	Uptime    time.Duration // Status
+	Frequency float64       // Status and SetFrequency
+	Beacon    bool          // Status
+	Heard     []relay.Heard // Heard
+}
+
+// ParseResponse parses the payload of a verified response frame received at now
+func ParseResponse(payload []byte, now time.Time) (Response, error) {
+	if len(payload) < 2 || payload[0]&responseBit == 0 {
+		return Response{}, fmt.Errorf("%w: not a response", ErrMalformed)
+	}
+	resp := Response{Command: Command(payload[0] &^ responseBit)}
+	if payload[1] != StatusOK {
+		resp.Err = string(payload[2:])
+		return resp, nil
+	}
+	data := payload[2:]
+
+	switch resp.Command {
+	case CommandStatus:
+		if len(data) != 13 {
+			return Response{}, fmt.Errorf("%w: status", ErrMalformed)
+		}
+		resp.Uptime = time.Duration(binary.BigEndian.Uint32(data)) * time.Second
+		resp.Frequency = float64(binary.BigEndian.Uint64(data[4:]))
+		resp.Beacon = data[12] != 0
+	case CommandSetFrequency:
+		if len(data) != 8 {
+			return Response{}, fmt.Errorf("%w: frequency", ErrMalformed)
+		}
+		resp.Frequency = float64(binary.BigEndian.Uint64(data))
+	case CommandHeard:
+		heard, err := relay.ParseTable(data, now)
+		if err != nil {
+			return Response{}, fmt.Errorf("%w: %s", ErrMalformed, err)
+		}
+		resp.Heard = heard
+	}
+	return resp, nil
+}
@@ -0,0 +1,182 @@
+package remote_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/8ff/udarp/pkg/auth"
+	"github.com/8ff/udarp/pkg/frame"
+	"github.com/8ff/udarp/pkg/relay"
+	"github.com/8ff/udarp/pkg/remote"
+)
+
+var key = []byte("0123456789abcdef0123456789abcdef")
+
+type fakeRig struct{ freq float64 }
+
+func (r *fakeRig) GetFrequency() (float64, error) { return r.freq, nil }
+func (r *fakeRig) SetFrequency(f float64) error   { r.freq = f; return nil }
+
+type fakeBeacon struct{ running bool }
+
+func (b *fakeBeacon) Start()        { b.running = true }
+func (b *fakeBeacon) Stop()         { b.running = false }
+func (b *fakeBeacon) Running() bool { return b.running }
+
+type setup struct {
+	station  *remote.Controller
+	operator *auth.Authenticator
+	rig      *fakeRig
+	beacon   *fakeBeacon
+	restarts int
+}
+
+func newSetup(t *testing.T) *setup {
+	s := &setup{rig: &fakeRig{freq: 14074000}, beacon: &fakeBeacon{}}
+
+	stationAuth, _ := auth.New(auth.Params{})
+	stationAuth.SetKey("K1ABC", key)
+	stationAuth.SetKey("W9XYZ", key)
+	s.operator, _ = auth.New(auth.Params{})
+	s.operator.SetKey("W1NOD", key)
+
+	var err error
+	s.station, err = remote.New(remote.Params{
+		Callsign: "W1NOD",
+		Auth:     stationAuth,
+		Operators: map[remote.Command][]string{
+			remote.CommandStatus:         {"K1ABC", "W9XYZ"},
+			remote.CommandSetFrequency:   {"K1ABC"},
+			remote.CommandBeaconStart:    {"K1ABC"},
+			remote.CommandBeaconStop:     {"K1ABC"},
+			remote.CommandRestartDecoder: {"K1ABC"},
+			remote.CommandHeard:          {"K1ABC"},
+		},
+		Bands:          []remote.Band{{Lo: 7000000, Hi: 7300000}, {Lo: 14000000, Hi: 14350000}},
+		Rig:            s.rig,
+		Beacon:         s.beacon,
+		RestartDecoder: func() error { s.restarts++; return nil },
+		Heard: func() []relay.Heard {
+			return []relay.Heard{{Callsign: "KA1XYZ", Last: time.Now().Add(-3 * time.Minute)}}
+		},
+	})
+	if err != nil {
+		t.Fatalf("New failed with error: %v", err)
+	}
+	return s
+}
+
+// run sends a signed command from operator and returns the verified response
+func (s *setup) run(t *testing.T, operator string, cmd remote.Command, freq float64) remote.Response {
+	f := remote.NewCommand(operator, "W1NOD", 5, cmd, freq)
+	if err := s.operator.Sign(&f); err != nil {
+		t.Fatalf("Sign failed with error: %v", err)
+	}
+	out, ok := s.station.Handle(f)
+	if !ok {
+		t.Fatalf("No response to %s", cmd)
+	}
+	if out.Destination != operator || out.Sequence != 5 {
+		t.Fatalf("Response should go back to %s with the command sequence", operator)
+	}
+	verified, err := s.operator.Verify(out)
+	if err != nil {
+		t.Fatalf("Response does not verify: %v", err)
+	}
+	resp, err := remote.ParseResponse(verified.Payload, time.Now())
+	if err != nil {
+		t.Fatalf("ParseResponse failed with error: %v", err)
+	}
+	if resp.Command != cmd {
+		t.Fatalf("Response is for %s, expected %s", resp.Command, cmd)
+	}
+	return resp
+}
+
+func TestCommands(t *testing.T) {
+	s := newSetup(t)
+
+	if resp := s.run(t, "K1ABC", remote.CommandSetFrequency, 7074000); resp.Err != "" || resp.Frequency != 7074000 || s.rig.freq != 7074000 {
+		t.Fatalf("SetFrequency failed: %+v", resp)
+	}
+	if resp := s.run(t, "K1ABC", remote.CommandBeaconStart, 0); resp.Err != "" || !s.beacon.running {
+		t.Fatalf("BeaconStart failed: %+v", resp)
+	}
+
+	resp := s.run(t, "K1ABC", remote.CommandStatus, 0)
+	if resp.Err != "" || resp.Frequency != 7074000 || !resp.Beacon {
+		t.Fatalf("Unexpected status: %+v", resp)
+	}
+
+	if resp := s.run(t, "K1ABC", remote.CommandBeaconStop, 0); resp.Err != "" || s.beacon.running {
+		t.Fatalf("BeaconStop failed: %+v", resp)
+	}
+	if resp := s.run(t, "K1ABC", remote.CommandRestartDecoder, 0); resp.Err != "" || s.restarts != 1 {
+		t.Fatalf("RestartDecoder failed: %+v", resp)
+	}
+
+	resp = s.run(t, "K1ABC", remote.CommandHeard, 0)
+	if resp.Err != "" || len(resp.Heard) != 1 || resp.Heard[0].Callsign != "KA1XYZ" || time.Since(resp.Heard[0].Last) < 2*time.Minute {
+		t.Fatalf("Unexpected heard list: %+v", resp)
+	}
+}
+
+func TestOperatorAllowlist(t *testing.T) {
+	s := newSetup(t)
+
+	if resp := s.run(t, "W9XYZ", remote.CommandStatus, 0); resp.Err != "" {
+		t.Fatalf("W9XYZ may query status: %s", resp.Err)
+	}
+	if resp := s.run(t, "W9XYZ", remote.CommandSetFrequency, 3573000); resp.Err == "" || s.rig.freq != 14074000 {
+		t.Fatalf("W9XYZ should not be allowed to change frequency")
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	for c := remote.CommandStatus; c <= remote.CommandHeard; c++ {
+		if parsed, err := remote.ParseCommand(c.String()); err != nil || parsed != c {
+			t.Fatalf("ParseCommand(%s) gave %d, %v", c, parsed, err)
+		}
+	}
+	if _, err := remote.ParseCommand("reboot"); err == nil {
+		t.Fatalf("Expected error for unknown command")
+	}
+}
+
+func TestFrequencyLimitedToBands(t *testing.T) {
+	s := newSetup(t)
+
+	for _, freq := range []float64{6999999, 7300001, 30000000, 0} {
+		if resp := s.run(t, "K1ABC", remote.CommandSetFrequency, freq); resp.Err == "" || s.rig.freq != 14074000 {
+			t.Fatalf("Tuning to %.0f Hz should be refused, rig is on %.0f", freq, s.rig.freq)
+		}
+	}
+	if resp := s.run(t, "K1ABC", remote.CommandSetFrequency, 14350000); resp.Err != "" || s.rig.freq != 14350000 {
+		t.Fatalf("Band edge should be allowed: %+v", resp)
+	}
+
+	stationAuth, _ := auth.New(auth.Params{})
+	if _, err := remote.New(remote.Params{Callsign: "W1NOD", Auth: stationAuth, Bands: []remote.Band{{Lo: 7300000, Hi: 7000000}}}); err == nil {
+		t.Fatalf("Expected error for an inverted band")
+	}
+}
+
+func TestRejectsUnauthenticated(t *testing.T) {
+	s := newSetup(t)
+
+	f := remote.NewCommand("K1ABC", "W1NOD", 1, remote.CommandBeaconStart, 0)
+	if _, ok := s.station.Handle(f); ok || s.beacon.running {
+		t.Fatalf("Unsigned commands must be dropped")
+	}
+
+	f = remote.NewCommand("K1ABC", "W1NOD", 1, remote.CommandBeaconStart, 0)
+	s.operator.Sign(&f)
+	f.Payload[0] = byte(remote.CommandBeaconStop)
+	if _, ok := s.station.Handle(f); ok {
+		t.Fatalf("Tampered commands must be dropped")
+	}
+
+	if _, ok := s.station.Handle(frame.New(frame.TypeText, "K1ABC", "W1NOD", 1, nil)); ok {
+		t.Fatalf("Non control frames should be ignored")
+	}
+}
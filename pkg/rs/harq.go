@@ -0,0 +1,183 @@
+package rs
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+Incremental redundancy hybrid ARQ.
+
+The sender encodes the full codeword once with Params.ParityShards parity shards but only sends the
+data shards and InitialParity of the parity shards. Every NACK releases the next IncrementParity parity
+shards of the same codeword. The receiver keeps whatever shards it got per message and tries to
+reconstruct once it holds at least DataShards of them, so a good path only pays for the first burst.
+
+Shards must be verified before they are added to the store (for example with crc.Decode8Chunks),
+reedsolomon can only fill in missing shards, not find corrupt ones.
+*/
+
+type HarqParams struct {
+	Params
+	InitialParity   int // Parity shards sent with the data shards
+	IncrementParity int // Parity shards sent on every NACK
+}
+
+type Shard struct {
+	Index int // Position in the codeword, data shards first then parity shards
+	Data  []byte
+}
+
+type HarqSender struct {
+	params HarqParams
+	shards [][]byte
+	sent   int // Number of shards sent so far
+}
+
+// NewHarqSender chunks and encodes data with all parity shards up front
+func NewHarqSender(params HarqParams, data []byte) (*HarqSender, error) {
+	if params.InitialParity < 0 || params.InitialParity > params.ParityShards {
+		return nil, fmt.Errorf("initial parity must be between 0 and %d", params.ParityShards)
+	}
+	if params.IncrementParity < 1 {
+		return nil, fmt.Errorf("increment parity must be at least 1")
+	}
+
+	chunks, _, err := Chunk(params.Params, data)
+	if err != nil {
+		return nil, err
+	}
+
+	shards, err := Encode(params.Params, chunks)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HarqSender{params: params, shards: shards}, nil
+}
+
+func (s *HarqSender) take(n int) []Shard {
+	if s.sent+n > len(s.shards) {
+		n = len(s.shards) - s.sent
+	}
+	output := make([]Shard, n)
+	for i := range output {
+		output[i] = Shard{Index: s.sent + i, Data: s.shards[s.sent+i]}
+	}
+	s.sent += n
+	return output
+}
+
+// Initial returns the data shards and the first InitialParity parity shards
+func (s *HarqSender) Initial() []Shard {
+	s.sent = 0
+	return s.take(s.params.DataShards + s.params.InitialParity)
+}
+
+// Next returns the next IncrementParity parity shards to send after a NACK
+func (s *HarqSender) Next() ([]Shard, error) {
+	if s.Exhausted() {
+		return nil, fmt.Errorf("all %d parity shards have been sent", s.params.ParityShards)
+	}
+	return s.take(s.params.IncrementParity), nil
+}
+
+// Exhausted reports if every shard of the codeword has been sent
+func (s *HarqSender) Exhausted() bool {
+	return s.sent >= len(s.shards)
+}
+
+type partialFrame struct {
+	shards   [][]byte
+	received int
+	updated  time.Time
+}
+
+// HarqStore keeps partial frames on the receiver side until they can be reconstructed
+type HarqStore struct {
+	params Params
+	frames map[uint32]*partialFrame
+	m      sync.Mutex
+}
+
+func NewHarqStore(params Params) *HarqStore {
+	return &HarqStore{params: params, frames: make(map[uint32]*partialFrame)}
+}
+
+// Add stores shards for message id and tries to reconstruct it
+// Returns the padded data and true once reconstructed, false means a NACK should be sent
+func (s *HarqStore) Add(id uint32, shards []Shard) ([]byte, bool, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	total := s.params.DataShards + s.params.ParityShards
+	// Validate everything first so a bad shard leaves the store untouched
+	for _, shard := range shards {
+		if shard.Index < 0 || shard.Index >= total {
+			return nil, false, fmt.Errorf("shard index %d out of range", shard.Index)
+		}
+		if len(shard.Data) != s.params.ChunkSize {
+			return nil, false, fmt.Errorf("shard %d is %d bytes, expected %d", shard.Index, len(shard.Data), s.params.ChunkSize)
+		}
+	}
+
+	frame, ok := s.frames[id]
+	if !ok {
+		frame = &partialFrame{shards: make([][]byte, total)}
+		s.frames[id] = frame
+	}
+	frame.updated = time.Now()
+
+	for _, shard := range shards {
+		if frame.shards[shard.Index] == nil {
+			frame.received++
+		}
+		frame.shards[shard.Index] = append([]byte{}, shard.Data...)
+	}
+
+	if frame.received < s.params.DataShards {
+		return nil, false, nil
+	}
+
+	// Decode works on a copy so a failed attempt doesn't leave reconstructed shards behind
+	attempt := make([][]byte, total)
+	copy(attempt, frame.shards)
+	data, err := Decode(s.params, attempt)
+	if err != nil {
+		return nil, false, err
+	}
+
+	delete(s.frames, id)
+	return data, true, nil
+}
+
+// Missing returns how many more shards message id needs before it can be reconstructed
+func (s *HarqStore) Missing(id uint32) int {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	frame, ok := s.frames[id]
+	if !ok {
+		return s.params.DataShards
+	}
+	if frame.received >= s.params.DataShards {
+		return 0
+	}
+	return s.params.DataShards - frame.received
+}
+
+// Expire drops partial frames that have not been updated within maxAge
+func (s *HarqStore) Expire(maxAge time.Duration) int {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	expired := 0
+	for id, frame := range s.frames {
+		if time.Since(frame.updated) > maxAge {
+			delete(s.frames, id)
+			expired++
+		}
+	}
+	return expired
+}
@@ -0,0 +1,97 @@
+package rs_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/8ff/udarp/pkg/rs"
+)
+
+func TestHarqIncrementalParity(t *testing.T) {
+	params := rs.HarqParams{
+		Params:          rs.Params{DataShards: 4, ParityShards: 6, ChunkSize: 8},
+		InitialParity:   1,
+		IncrementParity: 2,
+	}
+
+	inputData := make([]byte, 32)
+	rand.Read(inputData)
+
+	sender, err := rs.NewHarqSender(params, inputData)
+	if err != nil {
+		t.Fatalf("NewHarqSender failed with error: %v", err)
+	}
+	store := rs.NewHarqStore(params.Params)
+
+	// Weak path, lose 3 of the first 5 shards
+	initial := sender.Initial()
+	if len(initial) != 5 {
+		t.Fatalf("Expected 5 initial shards, got %d", len(initial))
+	}
+	_, done, err := store.Add(1, []rs.Shard{initial[0], initial[4]})
+	if err != nil || done {
+		t.Fatalf("Frame should not be complete yet (err: %v)", err)
+	}
+	if missing := store.Missing(1); missing != 2 {
+		t.Fatalf("Expected 2 missing shards, got %d", missing)
+	}
+
+	// First NACK, only one of the two extra parity shards arrives
+	next, err := sender.Next()
+	if err != nil {
+		t.Fatalf("Next failed with error: %v", err)
+	}
+	if next[0].Index != 5 || len(next) != 2 {
+		t.Fatalf("Expected parity shards 5 and 6, got %d shards starting at %d", len(next), next[0].Index)
+	}
+	_, done, _ = store.Add(1, next[:1])
+	if done {
+		t.Fatalf("Frame should not be complete after 3 shards")
+	}
+
+	// Second NACK fills the gap
+	next, _ = sender.Next()
+	decoded, done, err := store.Add(1, next)
+	if err != nil || !done {
+		t.Fatalf("Frame should be complete (err: %v)", err)
+	}
+	if !bytes.Equal(decoded, inputData) {
+		t.Fatalf("Decoded data doesn't match input data")
+	}
+
+	// Last NACK gets the final parity shard, then the sender runs out
+	if _, err = sender.Next(); err != nil {
+		t.Fatalf("Next failed with error: %v", err)
+	}
+	if !sender.Exhausted() {
+		t.Fatalf("Sender should be exhausted")
+	}
+	if _, err = sender.Next(); err == nil {
+		t.Fatalf("Expected error once all parity shards are sent")
+	}
+}
+
+func TestHarqStoreExpire(t *testing.T) {
+	params := rs.Params{DataShards: 2, ParityShards: 2, ChunkSize: 4}
+	store := rs.NewHarqStore(params)
+	store.Add(7, []rs.Shard{{Index: 0, Data: make([]byte, 4)}})
+
+	if expired := store.Expire(time.Hour); expired != 0 {
+		t.Fatalf("Nothing should expire yet")
+	}
+	if expired := store.Expire(0); expired != 1 {
+		t.Fatalf("Expected 1 expired frame, got %d", expired)
+	}
+	// A bad shard after a good one must not store either or create the frame
+	if _, _, err := store.Add(7, []rs.Shard{{Index: 0, Data: make([]byte, 4)}, {Index: 9, Data: make([]byte, 4)}}); err == nil {
+		t.Fatalf("Expected error for out of range shard index")
+	}
+	if missing := store.Missing(7); missing != 2 {
+		t.Fatalf("Expected the rejected shards not to be stored, %d missing", missing)
+	}
+	if expired := store.Expire(0); expired != 0 {
+		t.Fatalf("Expected no frame to be created by a rejected Add, %d expired", expired)
+	}
+}
@@ -0,0 +1,224 @@
+package text
+
+import (
+	"container/heap"
+	"fmt"
+	"strings"
+)
+
+/*
+Adaptive huffman coding over bytes and common QSO tokens.
+
+Besides the 256 byte values the alphabet has an end symbol and a symbol for each entry in qsoTokens,
+text is split greedily into the longest matching token or a single byte. Both sides start from the
+same symbol counts, taken from qsoCorpus, and after every symbol its count is bumped by
+adaptIncrement and the code is rebuilt. Messages are short so rebuilding the whole tree is cheaper
+than it sounds and much simpler than Vitter's algorithm. The end symbol marks where the message
+stops so the padding bits of the last byte are ignored.
+*/
+
+const (
+	endSymbol      = 256
+	firstToken     = 257
+	corpusWeight   = 32
+	adaptIncrement = 16
+)
+
+// Common QSO words and abbreviations that get a symbol of their own
+var qsoTokens = []string{
+	"CQ ", " DE ", "DX", " K", "TNX ", "FER ", "QSO", "QSL", "QTH ", "QRZ", "QRV", "QSY", "QSB", "QRM",
+	"RST ", "UR ", "NAME ", " ES ", "73", "PSE ", "HR ", "HW", "GM ", "GA ", "GE ", "OM ", "BK", "TU ",
+	"GL", "SK", "AGN", "CPY", "RPT", "SIG", "WX ", "ANT ", "RIG ", "PWR ", "599", "5NN", "UTC", "MHZ",
+	"KHZ", " DB", "THE ", "ING", "AND ", " TO ", " ON ", " AT ", " IN ", "ALL ", "WILL ", " FOR ",
+	"FROM ", "GOOD ", "NICE ", "HELLO", "PATH ", "CALL", "CHECK", "TONIGHT", "TOMORROW", "TODAY",
+	"BATTERY", "STATION", "SIGNAL", "REPORT", "PLEASE", "MSG ", "NET ", "OK ",
+}
+
+// Typical QSO traffic the initial symbol counts are taken from
+const qsoCorpus = `CQ CQ CQ DE K1ABC K1ABC K
+CQ DX CQ DX DE EA8BFK EA8BFK PSE K
+K1ABC DE W1AW GM OM TNX FER CALL UR RST 599 599 NAME HIRAM QTH NEWINGTON CT HW CPY
+W1AW DE K1ABC R R TNX FER RPT UR RST 579 579 NAME JOE QTH BOSTON MA BK
+RIG HR IS IC7300 PWR 100W ANT DIPOLE WX HR SUNNY TEMP 20C
+TNX FER NICE QSO 73 ES GUD DX SK
+QRZ? DE G4ABC
+QSL VIA BUREAU OR LOTW 73
+UR SIG 5NN QSB QRM HR
+PSE QSY UP 2 KHZ
+TU 73 GL
+HELLO FROM THE MOUNTAIN STATION, ALL OK HERE. WILL CALL AGAIN AT 1800 UTC.
+NET CHECK IN, NO TRAFFIC. QRV ON 7.074 MHZ.
+MSG FOR EA8BFK: PLEASE CONFIRM SKED TOMORROW 0900Z ON 14.095
+SIGNAL REPORT -12 DB FROM FN42 TO IM08, GOOD PATH TONIGHT
+`
+
+var symbolCount = firstToken + len(qsoTokens)
+
+var initialCounts []int
+
+func init() {
+	initialCounts = make([]int, symbolCount)
+	for i := range initialCounts {
+		initialCounts[i] = 1
+	}
+	for _, symbol := range tokenize(qsoCorpus) {
+		initialCounts[symbol] += corpusWeight
+	}
+	// Every message has exactly one end symbol
+	initialCounts[endSymbol] += corpusWeight
+}
+
+// tokenize splits s into symbols, preferring the longest matching token
+func tokenize(s string) []int {
+	var symbols []int
+	for i := 0; i < len(s); {
+		symbol, length := int(s[i]), 1
+		for t, token := range qsoTokens {
+			if len(token) > length && strings.HasPrefix(s[i:], token) {
+				symbol, length = firstToken+t, len(token)
+			}
+		}
+		symbols = append(symbols, symbol)
+		i += length
+	}
+	return symbols
+}
+
+type huffNode struct {
+	weight int
+	symbol int // Lowest symbol under this node, used to break ties the same way on both sides
+	left   int
+	right  int
+}
+
+type nodeHeap struct {
+	nodes []huffNode
+	index []int
+}
+
+func (h nodeHeap) Len() int { return len(h.index) }
+func (h nodeHeap) Less(i, j int) bool {
+	a, b := h.nodes[h.index[i]], h.nodes[h.index[j]]
+	if a.weight != b.weight {
+		return a.weight < b.weight
+	}
+	return a.symbol < b.symbol
+}
+func (h nodeHeap) Swap(i, j int)       { h.index[i], h.index[j] = h.index[j], h.index[i] }
+func (h *nodeHeap) Push(x interface{}) { h.index = append(h.index, x.(int)) }
+func (h *nodeHeap) Pop() interface{} {
+	old := h.index
+	n := old[len(old)-1]
+	h.index = old[:len(old)-1]
+	return n
+}
+
+type model struct {
+	counts []int
+	nodes  []huffNode // Leaves are nodes 0..symbolCount-1, root is the last node
+	codes  [][]byte
+}
+
+func newModel() *model {
+	m := &model{counts: append([]int{}, initialCounts...), codes: make([][]byte, symbolCount)}
+	m.build()
+	return m
+}
+
+func (m *model) build() {
+	m.nodes = m.nodes[:0]
+	h := &nodeHeap{}
+	for symbol, count := range m.counts {
+		m.nodes = append(m.nodes, huffNode{weight: count, symbol: symbol, left: -1, right: -1})
+		h.index = append(h.index, symbol)
+	}
+	h.nodes = m.nodes
+	heap.Init(h)
+
+	for h.Len() > 1 {
+		a := heap.Pop(h).(int)
+		b := heap.Pop(h).(int)
+		symbol := m.nodes[a].symbol
+		if m.nodes[b].symbol < symbol {
+			symbol = m.nodes[b].symbol
+		}
+		m.nodes = append(m.nodes, huffNode{weight: m.nodes[a].weight + m.nodes[b].weight, symbol: symbol, left: a, right: b})
+		h.nodes = m.nodes
+		heap.Push(h, len(m.nodes)-1)
+	}
+
+	m.assign(len(m.nodes)-1, nil)
+}
+
+func (m *model) assign(node int, code []byte) {
+	n := m.nodes[node]
+	if n.left < 0 {
+		m.codes[node] = append([]byte{}, code...)
+		return
+	}
+	m.assign(n.left, append(code, 0))
+	m.assign(n.right, append(code, 1))
+}
+
+func (m *model) update(symbol int) {
+	m.counts[symbol] += adaptIncrement
+	m.build()
+}
+
+// EncodeHuffman codes s with the adaptive huffman model
+func EncodeHuffman(s string) []byte {
+	m := newModel()
+	var output []byte
+	bit := 0
+
+	emit := func(symbol int) {
+		for _, b := range m.codes[symbol] {
+			if bit%8 == 0 {
+				output = append(output, 0)
+			}
+			if b == 1 {
+				output[len(output)-1] |= 1 << uint(7-bit%8)
+			}
+			bit++
+		}
+		m.update(symbol)
+	}
+
+	for _, symbol := range tokenize(s) {
+		emit(symbol)
+	}
+	emit(endSymbol)
+
+	return output
+}
+
+// DecodeHuffman is the inverse of EncodeHuffman
+func DecodeHuffman(data []byte) (string, error) {
+	m := newModel()
+	var output []byte
+	node := len(m.nodes) - 1
+
+	for bit := 0; bit < len(data)*8; bit++ {
+		if (data[bit/8]>>uint(7-bit%8))&1 == 0 {
+			node = m.nodes[node].left
+		} else {
+			node = m.nodes[node].right
+		}
+
+		if m.nodes[node].left >= 0 {
+			continue
+		}
+		if node == endSymbol {
+			return string(output), nil
+		}
+		if node >= firstToken {
+			output = append(output, qsoTokens[node-firstToken]...)
+		} else {
+			output = append(output, byte(node))
+		}
+		m.update(node)
+		node = len(m.nodes) - 1
+	}
+
+	return "", fmt.Errorf("data ended before the end symbol")
+}
@@ -0,0 +1,102 @@
+package text
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/8ff/udarp/pkg/frame"
+)
+
+/*
+Free text coding for frame payloads.
+
+Two codings are tried and the shorter one is used, the choice is stored in the frame header flags:
+  - Alphabet: the 42 character FT8 free text set packed as one base 42 number, ~5.4 bits per character.
+  - Huffman: adaptive huffman over bytes, starting from symbol counts of typical QSO traffic so short
+    messages compress from the first character. Works for any UTF-8 text.
+Raw is used when neither is shorter.
+*/
+
+const Alphabet = " 0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ+-./?"
+
+var alphabetRadix = big.NewInt(int64(len(Alphabet)))
+
+// EncodeAlphabet packs s, which must only use Alphabet characters
+func EncodeAlphabet(s string) ([]byte, error) {
+	// Leading 1 marks where the message starts so leading spaces survive
+	value := big.NewInt(1)
+	for i := 0; i < len(s); i++ {
+		digit := strings.IndexByte(Alphabet, s[i])
+		if digit < 0 {
+			return nil, fmt.Errorf("character %q is not in the alphabet", s[i])
+		}
+		value.Mul(value, alphabetRadix)
+		value.Add(value, big.NewInt(int64(digit)))
+	}
+	return value.Bytes(), nil
+}
+
+// DecodeAlphabet is the inverse of EncodeAlphabet
+func DecodeAlphabet(data []byte) (string, error) {
+	value := new(big.Int).SetBytes(data)
+	if value.Sign() == 0 {
+		return "", fmt.Errorf("data does not hold an alphabet message")
+	}
+
+	var output []byte
+	digit := new(big.Int)
+	for value.Cmp(big.NewInt(1)) > 0 {
+		value.DivMod(value, alphabetRadix, digit)
+		output = append(output, Alphabet[digit.Int64()])
+	}
+	if value.Cmp(big.NewInt(1)) != 0 {
+		return "", fmt.Errorf("data does not hold an alphabet message")
+	}
+
+	for i, j := 0, len(output)-1; i < j; i, j = i+1, j-1 {
+		output[i], output[j] = output[j], output[i]
+	}
+	return string(output), nil
+}
+
+// Encode codes s with whichever encoding gives the shortest payload
+func Encode(s string) (frame.Encoding, []byte) {
+	encoding, best := frame.EncodingRaw, []byte(s)
+
+	if packed, err := EncodeAlphabet(s); err == nil && len(packed) < len(best) {
+		encoding, best = frame.EncodingAlphabet, packed
+	}
+	if packed := EncodeHuffman(s); len(packed) < len(best) {
+		encoding, best = frame.EncodingHuffman, packed
+	}
+
+	return encoding, best
+}
+
+// Decode decodes a payload coded with encoding
+func Decode(encoding frame.Encoding, data []byte) (string, error) {
+	switch encoding {
+	case frame.EncodingRaw:
+		return string(data), nil
+	case frame.EncodingAlphabet:
+		return DecodeAlphabet(data)
+	case frame.EncodingHuffman:
+		return DecodeHuffman(data)
+	default:
+		return "", fmt.Errorf("unknown encoding %d", encoding)
+	}
+}
+
+// NewFrame builds a text frame with the payload coded and the encoding flagged in the header
+func NewFrame(source, destination string, sequence uint16, s string) frame.Frame {
+	encoding, payload := Encode(s)
+	f := frame.New(frame.TypeText, source, destination, sequence, payload)
+	f.SetEncoding(encoding)
+	return f
+}
+
+// FromFrame decodes the text payload of f
+func FromFrame(f frame.Frame) (string, error) {
+	return Decode(f.Encoding(), f.Payload)
+}
@@ -0,0 +1,89 @@
+package text_test
+
+import (
+	"testing"
+
+	"github.com/8ff/udarp/pkg/frame"
+	"github.com/8ff/udarp/pkg/text"
+)
+
+var qsoPhrases = []string{
+	"CQ CQ CQ DE EA8BFK EA8BFK K",
+	"G4ABC DE K1ABC GM UR RST 589 589 NAME BOB QTH LONDON HW?",
+	"TNX FER QSO ES 73 GL DX",
+	"QRV ON 14.095 AT 1900Z PSE QSL",
+	"ALL OK AT THE HUT, BATTERY LOW, WILL CHECK IN TOMORROW",
+	"RIG IC7300 ANT VERTICAL PWR 5W WX COLD",
+	"CQ UDARP DE W1AW FN31",
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, s := range append(qsoPhrases, "", " LEADING SPACE", "lower case and ünïcode ✓", "73") {
+		encoding, data := text.Encode(s)
+		decoded, err := text.Decode(encoding, data)
+		if err != nil {
+			t.Fatalf("Decode(%q) failed with error: %v", s, err)
+		}
+		if decoded != s {
+			t.Fatalf("Decoded %q doesn't match %q", decoded, s)
+		}
+
+		huffman, err := text.DecodeHuffman(text.EncodeHuffman(s))
+		if err != nil || huffman != s {
+			t.Fatalf("Huffman decoded %q doesn't match %q (err: %v)", huffman, s, err)
+		}
+	}
+
+	packed, err := text.EncodeAlphabet("  K1ABC/P ?")
+	if err != nil {
+		t.Fatalf("EncodeAlphabet failed with error: %v", err)
+	}
+	if decoded, err := text.DecodeAlphabet(packed); err != nil || decoded != "  K1ABC/P ?" {
+		t.Fatalf("Alphabet decoded %q doesn't match (err: %v)", decoded, err)
+	}
+	if _, err := text.EncodeAlphabet("lower"); err == nil {
+		t.Fatalf("Expected error for characters outside the alphabet")
+	}
+}
+
+func TestCompression(t *testing.T) {
+	coded, raw := 0, 0
+	for _, s := range qsoPhrases {
+		_, data := text.Encode(s)
+		if len(data) >= len(s) {
+			t.Fatalf("%q coded to %d bytes, expected less than %d", s, len(data), len(s))
+		}
+		coded += len(data)
+		raw += len(s)
+	}
+	// Callsigns and locators don't compress much, so the 40% saving is over the whole set
+	if float64(coded) > 0.6*float64(raw) {
+		t.Fatalf("Phrases coded to %d bytes, expected at most 60%% of %d", coded, raw)
+	}
+
+	// Text that doesn't compress is sent raw
+	encoding, _ := text.Encode("\x00\xff\x01")
+	if encoding != frame.EncodingRaw {
+		t.Fatalf("Expected raw encoding for binary data, got %d", encoding)
+	}
+}
+
+func TestFrame(t *testing.T) {
+	f := text.NewFrame("K1ABC", frame.Broadcast, 1, qsoPhrases[0])
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed with error: %v", err)
+	}
+
+	var decoded frame.Frame
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary failed with error: %v", err)
+	}
+	if decoded.Encoding() == frame.EncodingRaw {
+		t.Fatalf("Expected a compressed encoding to be flagged")
+	}
+	s, err := text.FromFrame(decoded)
+	if err != nil || s != qsoPhrases[0] {
+		t.Fatalf("Decoded %q doesn't match (err: %v)", s, err)
+	}
+}
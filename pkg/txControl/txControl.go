@@ -10,6 +10,7 @@ import (
 	"os/exec"
 	"runtime"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/8ff/udarp/pkg/misc"
@@ -250,6 +251,26 @@ func (t *TxControl) SetFrequency(freq float64) error {
 	return nil
 }
 
+// Read a rig level such as ALC, RFPOWER_METER or SWR, see the "l" command of rigctld
+func (t *TxControl) GetLevel(name string) (float64, error) {
+	buf, err := t.TcpCommand(t.Params.ListenAddr, t.Params.ListenPort, fmt.Sprintf("l %s", name))
+	if err != nil {
+		return 0, fmt.Errorf("error sending get level command: %s", err)
+	}
+
+	reply := strings.TrimSpace(string(buf))
+	if strings.HasPrefix(reply, "RPRT") {
+		return 0, fmt.Errorf("rig does not report %s: %s", name, reply)
+	}
+
+	level, err := strconv.ParseFloat(reply, 64)
+	if err != nil {
+		return 0, fmt.Errorf("error converting %s level to float: %s", name, err)
+	}
+
+	return level, nil
+}
+
 // Function that detects OS and architecture and stores the rigctl binary in /tmp/rigctl from rigctlBins
 func (t *TxControl) fetchRigctldBinary() error {
 	// Detect OS and architecture